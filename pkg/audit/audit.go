@@ -0,0 +1,207 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Request types of the audit records
+const (
+	AccessRequest      = "accessRequest"
+	ApplicationRequest = "applicationRequest"
+	CredentialRequest  = "credentialRequest"
+)
+
+// Actions of the audit records
+const (
+	Provision   = "provision"
+	Deprovision = "deprovision"
+	Update      = "update"
+)
+
+// Record is the audit entry of a provisioning action
+type Record struct {
+	Time               time.Time `json:"time"`
+	Request            string    `json:"request"`
+	Action             string    `json:"action"`
+	CentralID          string    `json:"centralId,omitempty"`
+	ManagedApplication string    `json:"managedApplication,omitempty"`
+	APIID              string    `json:"apiId,omitempty"`
+	CredentialType     string    `json:"credentialType,omitempty"`
+	ApplicationID      string    `json:"webmethodsApplicationId,omitempty"`
+	StrategyID         string    `json:"webmethodsStrategyId,omitempty"`
+	SubscriptionID     string    `json:"webmethodsSubscriptionId,omitempty"`
+	Outcome            string    `json:"outcome"`
+	DurationMs         int64     `json:"durationMs"`
+	Error              string    `json:"error,omitempty"`
+}
+
+// Sink receives the audit records
+type Sink interface {
+	Write(record Record) error
+}
+
+// Filter selects audit records, empty fields match every record
+type Filter struct {
+	// Application matches the managed application name or the webMethods application id
+	Application string
+	// API matches the API id
+	API string
+}
+
+// Matches returns true when the record is selected by the filter
+func (f Filter) Matches(record Record) bool {
+	if f.Application != "" && f.Application != record.ManagedApplication && f.Application != record.ApplicationID {
+		return false
+	}
+	return f.API == "" || f.API == record.APIID
+}
+
+// FileSink writes the audit records as JSON lines to a local file. The file is rotated once it reaches the max size,
+// the rotated files are suffixed with .1 for the most recent up to the max number of files kept.
+type FileSink struct {
+	mutex    sync.Mutex
+	path     string
+	maxSize  int64
+	maxFiles int
+	file     *os.File
+	size     int64
+}
+
+// NewFileSink opens the audit file, a max size of 0 disables the rotation
+func NewFileSink(path string, maxSize int64, maxFiles int) (*FileSink, error) {
+	s := &FileSink{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileSink) open() error {
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	s.file = file
+	s.size = info.Size()
+	return nil
+}
+
+// Write appends the record to the file, rotating it first when the record would exceed the max size
+func (s *FileSink) Write(record Record) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.maxSize > 0 && s.size > 0 && s.size+int64(len(line)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	return err
+}
+
+// Close closes the audit file
+func (s *FileSink) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.file.Close()
+}
+
+func (s *FileSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	if s.maxFiles > 0 {
+		os.Remove(rotatedPath(s.path, s.maxFiles))
+		for i := s.maxFiles - 1; i > 0; i-- {
+			os.Rename(rotatedPath(s.path, i), rotatedPath(s.path, i+1))
+		}
+		if err := os.Rename(s.path, rotatedPath(s.path, 1)); err != nil {
+			return err
+		}
+	} else if err := os.Remove(s.path); err != nil {
+		return err
+	}
+	return s.open()
+}
+
+func rotatedPath(path string, i int) string {
+	return fmt.Sprintf("%s.%d", path, i)
+}
+
+// Query returns the records of the audit file and its rotated files matching the filter, oldest first
+func Query(path string, filter Filter) ([]Record, error) {
+	rotated, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	indexes := []int{}
+	for _, p := range rotated {
+		if i, err := strconv.Atoi(strings.TrimPrefix(p, path+".")); err == nil {
+			indexes = append(indexes, i)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(indexes)))
+
+	paths := []string{}
+	for _, i := range indexes {
+		paths = append(paths, rotatedPath(path, i))
+	}
+	paths = append(paths, path)
+
+	records := []Record{}
+	for _, p := range paths {
+		found, err := readFile(p, filter)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, found...)
+	}
+	return records, nil
+}
+
+func readFile(path string, filter Filter) ([]Record, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	records := []Record{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		record := Record{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			// a partially written line is skipped
+			continue
+		}
+		if filter.Matches(record) {
+			records = append(records, record)
+		}
+	}
+	return records, scanner.Err()
+}
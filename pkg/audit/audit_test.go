@@ -0,0 +1,81 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newRecord(app, api string) Record {
+	return Record{
+		Time:               time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC),
+		Request:            AccessRequest,
+		Action:             Provision,
+		ManagedApplication: app,
+		APIID:              api,
+		ApplicationID:      app + "-id",
+		Outcome:            "Success",
+	}
+}
+
+func TestFileSinkQuery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	sink, err := NewFileSink(path, 0, 0)
+	assert.Nil(t, err)
+	assert.Nil(t, sink.Write(newRecord("consumer", "api-1")))
+	assert.Nil(t, sink.Write(newRecord("consumer", "api-2")))
+	assert.Nil(t, sink.Write(newRecord("partner", "api-1")))
+	assert.Nil(t, sink.Close())
+
+	records, err := Query(path, Filter{Application: "consumer"})
+	assert.Nil(t, err)
+	assert.Len(t, records, 2)
+
+	records, err = Query(path, Filter{Application: "partner-id"})
+	assert.Nil(t, err)
+	assert.Len(t, records, 1)
+
+	records, err = Query(path, Filter{API: "api-1"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"consumer", "partner"}, []string{records[0].ManagedApplication, records[1].ManagedApplication})
+
+	records, err = Query(filepath.Join(t.TempDir(), "missing.jsonl"), Filter{})
+	assert.Nil(t, err)
+	assert.Empty(t, records)
+}
+
+func TestFileSinkRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	sink, err := NewFileSink(path, 300, 2)
+	assert.Nil(t, err)
+	for _, api := range []string{"api-1", "api-2", "api-3", "api-4", "api-5"} {
+		assert.Nil(t, sink.Write(newRecord("consumer", api)))
+	}
+	assert.Nil(t, sink.Close())
+
+	// each record is larger than half the max size, older files beyond the two kept are removed
+	_, err = os.Stat(path + ".2")
+	assert.Nil(t, err)
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err))
+
+	records, err := Query(path, Filter{})
+	assert.Nil(t, err)
+	apis := []string{}
+	for _, record := range records {
+		apis = append(apis, record.APIID)
+	}
+	assert.Equal(t, []string{"api-3", "api-4", "api-5"}, apis)
+}
+
+func TestQuerySkipsInvalidLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	assert.Nil(t, os.WriteFile(path, []byte("{\"request\":\"accessRequest\",\"apiId\":\"api-1\"}\n{\"request\":"), 0600))
+
+	records, err := Query(path, Filter{})
+	assert.Nil(t, err)
+	assert.Len(t, records, 1)
+}
@@ -0,0 +1,40 @@
+package discovery
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/Axway/agents-webmethods/pkg/audit"
+	"github.com/spf13/cobra"
+)
+
+// newAuditCmd creates the command printing the provisioning audit records as JSON lines
+func newAuditCmd() *cobra.Command {
+	filter := audit.Filter{}
+	var file string
+	cmd := &cobra.Command{
+		Use:   "audit",
+		Short: "Query the provisioning audit records",
+		Long:  "Prints the provisioning audit records of the audit file and its rotated files, oldest first, filtered by application or API",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if file == "" {
+				return errors.New("the audit file is required")
+			}
+			records, err := audit.Query(file, filter)
+			if err != nil {
+				return err
+			}
+			encoder := json.NewEncoder(cmd.OutOrStdout())
+			for _, record := range records {
+				if err := encoder.Encode(record); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&file, "file", "", "Audit file, as configured by webmethods.audit.file")
+	cmd.Flags().StringVar(&filter.Application, "app", "", "Managed application name or Webmethods application id")
+	cmd.Flags().StringVar(&filter.API, "api", "", "API id")
+	return cmd
+}
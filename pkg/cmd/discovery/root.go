@@ -1,10 +1,11 @@
 package discovery
 
 import (
-	"errors"
+	"time"
 
 	"github.com/Axway/agent-sdk/pkg/agent"
 	"github.com/Axway/agent-sdk/pkg/apic/provisioning"
+	"github.com/Axway/agent-sdk/pkg/jobs"
 	"github.com/Axway/agent-sdk/pkg/util/log"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/exp/slices"
@@ -19,6 +20,7 @@ import (
 	"github.com/Axway/agent-sdk/pkg/cmd/service"
 	corecfg "github.com/Axway/agent-sdk/pkg/config"
 
+	"github.com/Axway/agents-webmethods/pkg/audit"
 	"github.com/Axway/agents-webmethods/pkg/config"
 	"github.com/Axway/agents-webmethods/pkg/discovery"
 )
@@ -42,6 +44,7 @@ func init() {
 	config.AddConfigProperties(RootCmd.GetProperties())
 
 	RootCmd.AddCommand(service.GenServiceCmd("pathConfig"))
+	RootCmd.AddCommand(newAuditCmd())
 }
 
 // run Callback that agent will call to process the execution
@@ -69,52 +72,125 @@ func initConfig(centralConfig corecfg.CentralConfig) (interface{}, error) {
 		return nil, err
 	}
 
-	oauthServersResponse, err := gatewayClient.ListOauth2Servers()
+	oauthServers := subs.NewOauthServers()
+	corsProp := getCorsSchemaPropertyBuilder()
+	provisionerOptions := []subs.ProvisionerOption{
+		subs.WithApplicationMetadata(agent.GetCacheManager(), agent.GetCentralClient()),
+		subs.WithAccessRequestCache(agent.GetCacheManager()),
+		subs.WithCredentialClient(agent.GetCentralClient()),
+		subs.WithEnvironment(centralConfig.GetEnvironmentName()),
+		subs.WithOauthServers(oauthServers),
+	}
+	if conf.WebMethodConfig.AuditFile != "" {
+		maxSize := int64(conf.WebMethodConfig.AuditMaxSize) * 1024 * 1024
+		sink, err := audit.NewFileSink(conf.WebMethodConfig.AuditFile, maxSize, conf.WebMethodConfig.AuditMaxFiles)
+		if err != nil {
+			return nil, err
+		}
+		provisionerOptions = append(provisionerOptions, subs.WithAuditSink(sink))
+	}
+	agent.RegisterProvisioner(subs.NewProvisioner(gatewayClient, conf.WebMethodConfig, logger, provisionerOptions...))
+	agent.RegisterResourceEventHandler("webmethodsApplicationUpdate",
+		subs.NewApplicationUpdateHandler(gatewayClient, agent.GetCacheManager(), agent.GetCentralClient(), logger))
+	if conf.WebMethodConfig.ReconcileInterval > 0 {
+		reconciler := subs.NewReconciler(gatewayClient, agent.GetCacheManager(), agent.GetCentralClient(), logger)
+		_, err = jobs.RegisterIntervalJobWithName(reconciler, conf.WebMethodConfig.ReconcileInterval, "Webmethods Application Reconciliation")
+		if err != nil {
+			return nil, err
+		}
+	}
+	if conf.WebMethodConfig.ExpiryInterval > 0 {
+		monitor := subs.NewExpiryMonitor(gatewayClient, agent.GetCacheManager(), agent.GetCentralClient(), conf.WebMethodConfig, centralConfig.GetEnvironmentName(), logger)
+		_, err = jobs.RegisterIntervalJobWithName(monitor, conf.WebMethodConfig.ExpiryInterval, "Webmethods Credential Expiry")
+		if err != nil {
+			return nil, err
+		}
+	}
+	if conf.WebMethodConfig.ImportInterval > 0 || conf.WebMethodConfig.ImportOnce {
+		importer := subs.NewImporter(gatewayClient, agent.GetCacheManager(), agent.GetCentralClient(), centralConfig.GetEnvironmentName(), logger)
+		if conf.WebMethodConfig.ImportInterval > 0 {
+			_, err = jobs.RegisterIntervalJobWithName(importer, conf.WebMethodConfig.ImportInterval, "Webmethods Application Import")
+		} else {
+			_, err = jobs.RegisterSingleRunJobWithName(importer, "Webmethods Application Import")
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	agent.NewAPIKeyAccessRequestBuilder().SetRequestSchema(subscription.AccessRequestSchema(nil)).Register()
+	apiKeyCRD := agent.NewAPIKeyCredentialRequestBuilder(coreagent.WithCRDRequestSchemaProperty(corsProp)).IsRenewable().IsSuspendable()
+	if days := expirationDays(conf.WebMethodConfig.APIKeyLifetime); days > 0 {
+		apiKeyCRD.SetExpirationDays(days)
+	}
+	apiKeyCRD.Register()
+
+	registerOauth(gatewayClient, conf.WebMethodConfig, oauthServers)
+	subs.RemoveOAuth2CredentialTypes(agent.GetCacheManager(), agent.GetCentralClient(), conf.WebMethodConfig.OauthServerAliases(), logger)
+
+	discoveryAgent = discovery.NewAgent(conf, gatewayClient, oauthServers)
+	discoveryAgent.OnConfigChange(func(cfg *config.AgentConfig) {
+		registerOauth(gatewayClient, cfg.WebMethodConfig, oauthServers)
+		subs.RemoveOAuth2CredentialTypes(agent.GetCacheManager(), agent.GetCentralClient(), cfg.WebMethodConfig.OauthServerAliases(), logger)
+	})
+	return conf, nil
+}
 
+// registerOauth registers the oauth access request definition and a credential request definition for every
+// configured authorization server alias found on the gateway. Only the api key definitions are offered when none is.
+func registerOauth(client webmethods.Client, cfg *config.WebMethodConfig, oauthServers *subs.OauthServers) {
+	registered := map[string]string{}
+	defer func() {
+		oauthServers.Set(registered)
+	}()
+
+	aliases := cfg.OauthServerAliases()
+	if len(aliases) == 0 {
+		log.Info("No Oauth2 Authorization Server alias configured, oauth credentials are not offered")
+		return
+	}
+	oauthServersResponse, err := client.ListOauth2Servers()
 	if err != nil {
-		return nil, err
+		log.Warnf("Unable to list Oauth2 Authorization Servers, oauth credentials are not offered: %s", err)
+		return
 	}
-	servers := []string{}
 
-	for _, server := range oauthServersResponse.Alias {
-		servers = append(servers, server.Name)
+	credentialTypes := subscription.OAuth2CredentialTypes(aliases)
+	for i, alias := range aliases {
+		j := slices.IndexFunc(oauthServersResponse.Alias, func(server webmethods.OauthServerAlias) bool {
+			return server.Name == alias
+		})
+		if j < 0 {
+			log.Warnf("Invalid Oauth2 Authorization Server alias name %s", alias)
+			continue
+		}
+		err := registerOauthCredentialRequest(credentialTypes[i], oauthServersResponse.Alias[j])
+		if err != nil {
+			log.Warnf("Unable to register the oauth credential request definition for %s: %s", alias, err)
+			continue
+		}
+		registered[credentialTypes[i]] = alias
 	}
-
-	// filter oauth authz server based on config
-	//if conf.WebMethodConfig.Oauth2AuthzServerAlias != "" {
-	if slices.Contains(servers, conf.WebMethodConfig.Oauth2AuthzServerAlias) {
-		servers = []string{conf.WebMethodConfig.Oauth2AuthzServerAlias}
-	} else {
-		return nil, errors.New("Invalid Oauth2 Authorization Server alias name")
+	if len(registered) == 0 {
+		return
 	}
+	_, err = agent.NewAccessRequestBuilder().SetName(subscription.OAuth2AuthType).SetRequestSchema(subscription.AccessRequestSchema(nil)).Register()
+	if err != nil {
+		log.Warnf("Unable to register the oauth access request definition: %s", err)
+		registered = map[string]string{}
+	}
+}
 
+// registerOauthCredentialRequest registers the oauth credential request definition of the authorization server alias
+func registerOauthCredentialRequest(name string, server webmethods.OauthServerAlias) error {
 	scopes := []string{}
-
-	for _, server := range oauthServersResponse.Alias {
-		var serverPlusScope string
-		for _, scope := range server.Scopes {
-			if server.Name == conf.WebMethodConfig.Oauth2AuthzServerAlias {
-				serverPlusScope = scope.Name
-
-			} else {
-				// Handle multiple IDP todo - create multipel credential definition if needed.
-				serverPlusScope = server.Name + "-" + scope.Name
-			}
-			scopes = append(scopes, serverPlusScope)
-		}
+	for _, scope := range server.Scopes {
+		scopes = append(scopes, scope.Name)
 	}
+	log.Infof("Available scopes from IDP %s %v", server.Name, scopes)
 
-	log.Infof("Available scopes from IDP %v", scopes)
-
-	corsProp := getCorsSchemaPropertyBuilder()
-	agent.RegisterProvisioner(subs.NewProvisioner(gatewayClient, logger))
-	agent.NewAPIKeyAccessRequestBuilder().Register()
-	agent.NewAPIKeyCredentialRequestBuilder(coreagent.WithCRDRequestSchemaProperty(corsProp)).IsRenewable().Register()
-
-	oAuthRedirects := getAuthRedirectSchemaPropertyBuilder()
 	oAuthServers := provisioning.NewSchemaPropertyBuilder().
 		SetName(subscription.OauthServerField).SetRequired().SetLabel("Oauth Server").
-		IsString().SetEnumValues(servers)
+		IsString().SetEnumValues([]string{server.Name}).SetDefaultValue(server.Name)
 
 	oAuthType := provisioning.NewSchemaPropertyBuilder().
 		SetName(subscription.ApplicationTypeField).SetRequired().SetLabel("Application Type").
@@ -127,19 +203,22 @@ func initConfig(centralConfig corecfg.CentralConfig) (interface{}, error) {
 		SetName(subscription.OauthScopes).SetLabel("Scopes").IsArray().AddItem(
 		provisioning.NewSchemaPropertyBuilder().SetName("scope").IsString().SetEnumValues(scopes))
 
-	agent.NewAccessRequestBuilder().SetName(subscription.OAuth2AuthType).Register()
+	oAuthGrantTypes := provisioning.NewSchemaPropertyBuilder().
+		SetName(subscription.GrantTypesField).SetLabel("Grant Types").IsArray().AddItem(
+		provisioning.NewSchemaPropertyBuilder().SetName("grantType").IsString().
+			SetEnumValues(subscription.OAuth2GrantTypes(server.SupportedGrantTypes)))
 
-	agent.NewOAuthCredentialRequestBuilder(
+	_, err := agent.NewOAuthCredentialRequestBuilder(
 		coreagent.WithCRDOAuthSecret(),
 		coreagent.WithCRDRequestSchemaProperty(oAuthServers),
 		coreagent.WithCRDRequestSchemaProperty(oAuthType),
 		//	coreagent.WithCRDRequestSchemaProperty(audience),
 		coreagent.WithCRDRequestSchemaProperty(oAuthApiScope),
-		coreagent.WithCRDRequestSchemaProperty(oAuthRedirects),
-		coreagent.WithCRDRequestSchemaProperty(corsProp)).SetName(subscription.OAuth2AuthType).IsRenewable().Register()
-
-	discoveryAgent = discovery.NewAgent(conf, gatewayClient)
-	return conf, nil
+		coreagent.WithCRDRequestSchemaProperty(oAuthGrantTypes),
+		coreagent.WithCRDRequestSchemaProperty(getAuthRedirectSchemaPropertyBuilder()),
+		coreagent.WithCRDRequestSchemaProperty(getCorsSchemaPropertyBuilder())).
+		SetName(name).SetTitle("OAuth " + server.Name).IsRenewable().IsSuspendable().Register()
+	return err
 }
 
 func getCorsSchemaPropertyBuilder() provisioning.PropertyBuilder {
@@ -163,3 +242,9 @@ func getAuthRedirectSchemaPropertyBuilder() provisioning.PropertyBuilder {
 				SetName("URL").
 				IsString())
 }
+
+// expirationDays converts the api key lifetime to the whole number of days advertised on the credential definition
+func expirationDays(lifetime time.Duration) int {
+	day := 24 * time.Hour
+	return int((lifetime + day - 1) / day)
+}
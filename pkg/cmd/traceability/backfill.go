@@ -0,0 +1,32 @@
+package traceability
+
+import (
+	"github.com/Axway/agents-webmethods/pkg/traceability"
+	"github.com/spf13/cobra"
+)
+
+// newBackfillCmd creates the command requesting the running agent to collect again the transactions of a time range
+func newBackfillCmd() *cobra.Command {
+	var from, to, cachePath string
+	cmd := &cobra.Command{
+		Use:   "backfill",
+		Short: "Collect again the transactions of a time range",
+		Long: "Requests the agent sharing the cache path to collect the transactions between --from and --to, in the " +
+			"Webmethods API Gateway timezone. The agent picks up the request on its next poll and processes one " +
+			"window per poll, before the live collection, without moving the checkpoint of the live collection.",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			path := traceability.FormatBackfillPath(cachePath)
+			if err := traceability.RequestBackfill(path, from, to); err != nil {
+				return err
+			}
+			cmd.Printf("backfill from %s to %s requested in %s\n", from, to, path)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&from, "from", "", "Start of the range, formatted as 2006-01-02 15:04:05")
+	cmd.Flags().StringVar(&to, "to", "", "End of the range, formatted as 2006-01-02 15:04:05")
+	cmd.Flags().StringVar(&cachePath, "cachePath", "/tmp", "Cache path of the agent, as configured by webmethods.cachePath")
+	cmd.MarkFlagRequired("from")
+	cmd.MarkFlagRequired("to")
+	return cmd
+}
@@ -37,6 +37,7 @@ func init() {
 	)
 	config.AddConfigProperties(RootCmd.GetProperties())
 	//RootCmd.AddCommand(service.GenServiceCmd("path.Config"))
+	RootCmd.AddCommand(newBackfillCmd())
 }
 
 // Callback that agent will call to process the execution
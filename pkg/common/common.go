@@ -1,15 +1,39 @@
 package common
 
-import "fmt"
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
 
 const (
 	AppID        = "appID"
 	AttrAPIID    = "apiId"
 	AttrChecksum = "checksum"
 	AttrAppID    = "webmethodsApplicationId"
+
+	AttrAllowedIPs   = "webmethodsAllowedIPs"
+	AttrAllowedHosts = "webmethodsAllowedHosts"
+	AttrDedicatedApp = "webmethodsDedicatedApplication"
+
+	AttrSubscriptionID = "webmethodsSubscriptionId"
+	AttrPackageID      = "webmethodsPackageId"
+	AttrPlanID         = "webmethodsPlanId"
+
+	AttrStrategyID = "webmethodsStrategyId"
+	AttrAPIKeyID   = "webmethodsApiKeyId"
+
+	AttrImported = "webmethodsImported"
 )
 
+var invalidCentralNameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
 // FormatAPICacheKey ensure consistent naming of the cache key for an API.
 func FormatAPICacheKey(apiID, stageName string) string {
 	return fmt.Sprintf("%s-%s", apiID, stageName)
 }
+
+// CentralName turns a webMethods name into a valid Central resource name
+func CentralName(name string) string {
+	return strings.Trim(invalidCentralNameChars.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
@@ -6,10 +6,13 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
+	"text/template"
 	"time"
 
 	"github.com/Axway/agent-sdk/pkg/cmd/properties"
 	corecfg "github.com/Axway/agent-sdk/pkg/config"
+	"golang.org/x/exp/slices"
 )
 
 var config *AgentConfig
@@ -34,6 +37,50 @@ const (
 	pathProxyURL               = "webmethods.proxyUrl"
 	pathCachePath              = "webmethods.cachePath"
 	pathOauth2AuthzServerAlias = "webmethods.oauth2AuthzServerAlias"
+	pathReconcileInterval      = "webmethods.reconcileInterval"
+
+	pathAPIKeyLifetime           = "webmethods.credential.apiKeyLifetime"
+	pathCredentialExpiryInterval = "webmethods.credential.expiryInterval"
+	pathCredentialExpiryWarning  = "webmethods.credential.expiryWarning"
+	pathCredentialAutoRotate     = "webmethods.credential.autoRotate"
+
+	pathApplicationNameTemplate     = "webmethods.application.nameTemplate"
+	pathApplicationPerAccessRequest = "webmethods.application.perAccessRequest"
+
+	pathProductExport = "webmethods.productExport"
+
+	pathAuditFile     = "webmethods.audit.file"
+	pathAuditMaxSize  = "webmethods.audit.maxSize"
+	pathAuditMaxFiles = "webmethods.audit.maxFiles"
+
+	pathImportInterval = "webmethods.import.interval"
+	pathImportOnce     = "webmethods.import.once"
+
+	pathTransactionWindow = "webmethods.transactions.windowSize"
+	pathMaxLookback       = "webmethods.transactions.maxLookback"
+	pathHeadersAllow      = "webmethods.transactions.headers.allow"
+	pathHeadersDeny       = "webmethods.transactions.headers.deny"
+	pathEventTypes        = "webmethods.transactions.eventTypes"
+
+	pathRedactHeaders   = "webmethods.transactions.redaction.headers"
+	pathRedactQueryArgs = "webmethods.transactions.redaction.queryArgs"
+	pathRedactFields    = "webmethods.transactions.redaction.customFields"
+	pathRedactJSONPaths = "webmethods.transactions.redaction.jsonPaths"
+	pathRedactPatterns  = "webmethods.transactions.redaction.patterns"
+	pathMaxPayloadSize  = "webmethods.transactions.redaction.maxPayloadSize"
+
+	pathTransactionsMode  = "webmethods.transactions.mode"
+	pathMetricsBucketSize = "webmethods.transactions.metrics.bucketSize"
+	pathMetricsSampleRate = "webmethods.transactions.metrics.sampleRate"
+)
+
+// Modes of reporting of the transactions
+const (
+	// TransactionsModeEvents publishes an event for every transaction
+	TransactionsModeEvents = "events"
+	// TransactionsModeMetrics publishes metrics aggregated by API, application, status code and time bucket, along
+	// with the events of a sample of the transactions
+	TransactionsModeMetrics = "metrics"
 )
 
 // SetConfig sets the global AgentConfig reference.
@@ -68,9 +115,47 @@ type WebMethodConfig struct {
 	Timezone               string            `config:"timezone"`
 	AnalyticsDelay         time.Duration     `config:"analyticsDelay"`
 	ProxyURL               string            `config:"proxyUrl"`
+	ReconcileInterval      time.Duration     `config:"reconcileInterval"`
+	APIKeyLifetime         time.Duration     `config:"credential.apiKeyLifetime"`
+	ExpiryInterval         time.Duration     `config:"credential.expiryInterval"`
+	ExpiryWarning          time.Duration     `config:"credential.expiryWarning"`
+	AutoRotate             bool              `config:"credential.autoRotate"`
+	AppNameTemplate        string            `config:"application.nameTemplate"`
+	AppPerAccessRequest    bool              `config:"application.perAccessRequest"`
+	ProductExport          string            `config:"productExport"`
+	AuditFile              string            `config:"audit.file"`
+	AuditMaxSize           int               `config:"audit.maxSize"`
+	AuditMaxFiles          int               `config:"audit.maxFiles"`
+	ImportInterval         time.Duration     `config:"import.interval"`
+	ImportOnce             bool              `config:"import.once"`
+	TransactionWindow      time.Duration     `config:"transactions.windowSize"`
+	MaxLookback            time.Duration     `config:"transactions.maxLookback"`
+	HeadersAllow           []string          `config:"transactions.headers.allow"`
+	HeadersDeny            []string          `config:"transactions.headers.deny"`
+	EventTypes             []string          `config:"transactions.eventTypes"`
+	RedactHeaders          []string          `config:"transactions.redaction.headers"`
+	RedactQueryArgs        []string          `config:"transactions.redaction.queryArgs"`
+	RedactCustomFields     []string          `config:"transactions.redaction.customFields"`
+	RedactJSONPaths        []string          `config:"transactions.redaction.jsonPaths"`
+	RedactPatterns         []string          `config:"transactions.redaction.patterns"`
+	MaxPayloadSize         int               `config:"transactions.redaction.maxPayloadSize"`
+	TransactionsMode       string            `config:"transactions.mode"`
+	MetricsBucketSize      time.Duration     `config:"transactions.metrics.bucketSize"`
+	MetricsSampleRate      int               `config:"transactions.metrics.sampleRate"`
 	TLS                    corecfg.TLSConfig `config:"ssl"`
 }
 
+// OauthServerAliases - returns the configured Oauth2 Authorization Server alias names
+func (c *WebMethodConfig) OauthServerAliases() []string {
+	aliases := []string{}
+	for _, alias := range strings.Split(c.Oauth2AuthzServerAlias, ",") {
+		if alias = strings.TrimSpace(alias); alias != "" && !slices.Contains(aliases, alias) {
+			aliases = append(aliases, alias)
+		}
+	}
+	return aliases
+}
+
 // ValidateCfg - Validates the gateway config
 func (c *WebMethodConfig) ValidateCfg() (err error) {
 	if c.WebmethodsApimUrl == "" {
@@ -98,6 +183,38 @@ func (c *WebMethodConfig) ValidateCfg() (err error) {
 		return errors.New("invalid  Webmethods APIM configuration: pollInterval is invalid")
 	}
 
+	if c.APIKeyLifetime < 0 {
+		return errors.New("invalid  Webmethods APIM configuration: credential.apiKeyLifetime is invalid")
+	}
+
+	if c.TransactionWindow < 0 || c.MaxLookback < 0 {
+		return errors.New("invalid  Webmethods APIM configuration: transactions.windowSize and transactions.maxLookback can not be negative")
+	}
+
+	if c.MaxPayloadSize < 0 {
+		return errors.New("invalid  Webmethods APIM configuration: transactions.redaction.maxPayloadSize can not be negative")
+	}
+
+	if c.TransactionsMode != TransactionsModeEvents && c.TransactionsMode != TransactionsModeMetrics {
+		return fmt.Errorf("invalid  Webmethods APIM configuration: transactions.mode must be %s or %s", TransactionsModeEvents, TransactionsModeMetrics)
+	}
+
+	if c.TransactionsMode == TransactionsModeMetrics && c.MetricsBucketSize <= 0 {
+		return errors.New("invalid  Webmethods APIM configuration: transactions.metrics.bucketSize must be positive")
+	}
+
+	if c.MetricsSampleRate < 0 || c.MetricsSampleRate > 100 {
+		return errors.New("invalid  Webmethods APIM configuration: transactions.metrics.sampleRate must be between 0 and 100")
+	}
+
+	if c.AuditMaxSize < 0 || c.AuditMaxFiles < 0 {
+		return errors.New("invalid  Webmethods APIM configuration: audit.maxSize and audit.maxFiles can not be negative")
+	}
+
+	if _, err := template.New("applicationName").Parse(c.AppNameTemplate); err != nil {
+		return fmt.Errorf("invalid  Webmethods APIM configuration: application.nameTemplate is invalid: %s", err)
+	}
+
 	if _, err := os.Stat(c.CachePath); os.IsNotExist(err) {
 		return fmt.Errorf("invalid  Webmethods APIM cache path: path does not exist: %s", c.CachePath)
 	}
@@ -113,11 +230,38 @@ func AddConfigProperties(props properties.Properties) {
 	props.AddStringProperty(pathAuthPassword, "", "Webmethods APIM password.")
 	props.AddStringProperty(pathMaturityState, "Beta", "Webmethods APIM Maturity State.")
 	props.AddStringProperty(pathFilter, "", "Webmethods Tag filter.")
-	props.AddStringProperty(pathOauth2AuthzServerAlias, "", "Webmethods Oauth2 Authorization Server alias name.")
+	props.AddStringProperty(pathOauth2AuthzServerAlias, "", "Webmethods Oauth2 Authorization Server alias names, comma separated. One oauth credential definition is created per alias, none when empty.")
 	props.AddStringProperty(pathTimezone, "", "Webmethods API Gateway timezone")
 	props.AddDurationProperty(pathAnalyticsDelay, 60*time.Second, "Webmethods API Gateway timezone")
 
 	props.AddStringProperty(pathCachePath, "/tmp", "Webmethods Cache Path")
+	props.AddDurationProperty(pathReconcileInterval, 0, "Interval for reconciling Amplify managed applications with Webmethods applications, 0 (default) disables reconciliation")
+	props.AddDurationProperty(pathAPIKeyLifetime, 0, "Lifetime of the API keys of the applications created by the agent, 0 for keys that never expire")
+	props.AddDurationProperty(pathCredentialExpiryInterval, time.Hour, "Interval for checking the expiry of provisioned credentials, 0 disables the check")
+	props.AddDurationProperty(pathCredentialExpiryWarning, 7*24*time.Hour, "Time before expiry from which a credential is reported as expiring")
+	props.AddBoolProperty(pathCredentialAutoRotate, false, "Set to true to rotate the credentials that are about to expire")
+	props.AddStringProperty(pathApplicationNameTemplate, "{{.App}}", "Template for the names of the Webmethods applications created by the agent, fields: .Team, .App, .Environment, .ID")
+	props.AddBoolProperty(pathApplicationPerAccessRequest, false, "Set to true to create a dedicated Webmethods application for each access request")
+	props.AddStringProperty(pathProductExport, "", "File to export Central product and plan definitions mirroring the Webmethods packages to, empty disables the export")
+	props.AddStringProperty(pathAuditFile, "", "JSON lines file recording every provisioning action, empty disables the audit")
+	props.AddIntProperty(pathAuditMaxSize, 10, "Size in MB at which the audit file is rotated, 0 disables the rotation")
+	props.AddIntProperty(pathAuditMaxFiles, 5, "Number of rotated audit files kept")
+	props.AddDurationProperty(pathImportInterval, 0, "Interval for importing the existing Webmethods applications into Central as managed applications, 0 disables the periodic import")
+	props.AddBoolProperty(pathImportOnce, false, "Set to true to import the existing Webmethods applications into Central once at startup")
+	props.AddDurationProperty(pathTransactionWindow, 15*time.Minute, "Max time range of transactions retrieved in a single request, larger gaps are split in consecutive windows, 0 disables the split")
+	props.AddDurationProperty(pathMaxLookback, 24*time.Hour, "Max time in the past from which transactions are retrieved after an interruption, 0 for no limit")
+	props.AddStringSliceProperty(pathHeadersAllow, []string{}, "Names of the headers forwarded with the transactions, comma separated. All headers are forwarded when empty.")
+	props.AddStringSliceProperty(pathHeadersDeny, []string{}, "Names of the headers never forwarded with the transactions, comma separated.")
+	props.AddStringSliceProperty(pathEventTypes, []string{"transactionalEvents"}, "Event types collected, comma separated, among transactionalEvents, errorEvents, policyViolationEvents and lifecycleEvents")
+	props.AddStringSliceProperty(pathRedactHeaders, []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "x-Gateway-APIKey", "X-API-Key"}, "Names of the headers whose values are masked, comma separated.")
+	props.AddStringSliceProperty(pathRedactQueryArgs, []string{"apikey", "api_key", "access_token", "token"}, "Names of the query arguments whose values are masked, comma separated.")
+	props.AddStringSliceProperty(pathRedactFields, []string{}, "Names of the custom fields whose values are masked, comma separated.")
+	props.AddStringSliceProperty(pathRedactJSONPaths, []string{}, "JSON paths of the payload fields masked, comma separated, like $.card.number or $..cvv")
+	props.AddStringSliceProperty(pathRedactPatterns, []string{}, "Regular expressions masked in the payloads, query argument and custom field values, comma separated")
+	props.AddIntProperty(pathMaxPayloadSize, 0, "Max size in bytes of the payloads forwarded with the transactions, larger payloads are truncated, 0 disables the payloads")
+	props.AddStringProperty(pathTransactionsMode, TransactionsModeEvents, "Reporting of the transactions, events to publish every transaction or metrics to publish aggregated metrics and a sample of the transactions")
+	props.AddDurationProperty(pathMetricsBucketSize, time.Minute, "Time bucket of the aggregated metrics in metrics mode")
+	props.AddIntProperty(pathMetricsSampleRate, 1, "Percentage of the transactions still published as events in metrics mode", properties.WithLowerLimitInt(0), properties.WithUpperLimitInt(100))
 	// ssl properties and command flags
 	props.AddStringSliceProperty(pathSSLNextProtos, []string{}, "List of supported application level protocols, comma separated.")
 	props.AddBoolProperty(pathSSLInsecureSkipVerify, false, "Controls whether a client verifies the server's certificate chain and host name.")
@@ -141,6 +285,33 @@ func NewWebmothodsConfig(props properties.Properties, agentType corecfg.AgentTyp
 		Oauth2AuthzServerAlias: props.StringPropertyValue(pathOauth2AuthzServerAlias),
 		Timezone:               props.StringPropertyValue(pathTimezone),
 		AnalyticsDelay:         props.DurationPropertyValue(pathAnalyticsDelay),
+		ReconcileInterval:      props.DurationPropertyValue(pathReconcileInterval),
+		APIKeyLifetime:         props.DurationPropertyValue(pathAPIKeyLifetime),
+		ExpiryInterval:         props.DurationPropertyValue(pathCredentialExpiryInterval),
+		ExpiryWarning:          props.DurationPropertyValue(pathCredentialExpiryWarning),
+		AutoRotate:             props.BoolPropertyValue(pathCredentialAutoRotate),
+		AppNameTemplate:        props.StringPropertyValue(pathApplicationNameTemplate),
+		AppPerAccessRequest:    props.BoolPropertyValue(pathApplicationPerAccessRequest),
+		ProductExport:          props.StringPropertyValue(pathProductExport),
+		AuditFile:              props.StringPropertyValue(pathAuditFile),
+		AuditMaxSize:           props.IntPropertyValue(pathAuditMaxSize),
+		AuditMaxFiles:          props.IntPropertyValue(pathAuditMaxFiles),
+		ImportInterval:         props.DurationPropertyValue(pathImportInterval),
+		ImportOnce:             props.BoolPropertyValue(pathImportOnce),
+		TransactionWindow:      props.DurationPropertyValue(pathTransactionWindow),
+		MaxLookback:            props.DurationPropertyValue(pathMaxLookback),
+		HeadersAllow:           props.StringSlicePropertyValue(pathHeadersAllow),
+		HeadersDeny:            props.StringSlicePropertyValue(pathHeadersDeny),
+		EventTypes:             props.StringSlicePropertyValue(pathEventTypes),
+		RedactHeaders:          props.StringSlicePropertyValue(pathRedactHeaders),
+		RedactQueryArgs:        props.StringSlicePropertyValue(pathRedactQueryArgs),
+		RedactCustomFields:     props.StringSlicePropertyValue(pathRedactFields),
+		RedactJSONPaths:        props.StringSlicePropertyValue(pathRedactJSONPaths),
+		RedactPatterns:         props.StringSlicePropertyValue(pathRedactPatterns),
+		MaxPayloadSize:         props.IntPropertyValue(pathMaxPayloadSize),
+		TransactionsMode:       props.StringPropertyValue(pathTransactionsMode),
+		MetricsBucketSize:      props.DurationPropertyValue(pathMetricsBucketSize),
+		MetricsSampleRate:      props.IntPropertyValue(pathMetricsSampleRate),
 		TLS: &corecfg.TLSConfiguration{
 			NextProtos:         props.StringSlicePropertyValue(pathSSLNextProtos),
 			InsecureSkipVerify: props.BoolPropertyValue(pathSSLInsecureSkipVerify),
@@ -8,6 +8,7 @@ import (
 	coreAgent "github.com/Axway/agent-sdk/pkg/agent"
 	"github.com/Axway/agent-sdk/pkg/cache"
 	"github.com/Axway/agents-webmethods/pkg/config"
+	"github.com/Axway/agents-webmethods/pkg/subscription"
 	"github.com/Axway/agents-webmethods/pkg/webmethods"
 )
 
@@ -19,14 +20,15 @@ type Repeater interface {
 
 // Agent -
 type Agent struct {
-	client    webmethods.Client
-	stopAgent chan bool
-	discovery Repeater
-	publisher Repeater
+	client              webmethods.Client
+	stopAgent           chan bool
+	discovery           Repeater
+	publisher           Repeater
+	configChangeHandler func(cfg *config.AgentConfig)
 }
 
 // NewAgent creates a new agent
-func NewAgent(cfg *config.AgentConfig, client webmethods.Client) (agent *Agent) {
+func NewAgent(cfg *config.AgentConfig, client webmethods.Client, oauthServers *subscription.OauthServers) (agent *Agent) {
 	buffer := 5
 	apiChan := make(chan *ServiceDetail, buffer)
 
@@ -39,8 +41,10 @@ func NewAgent(cfg *config.AgentConfig, client webmethods.Client) (agent *Agent)
 	c := cache.New()
 
 	svcHandler := &serviceHandler{
-		client: client,
-		cache:  c,
+		client:                client,
+		cache:                 c,
+		oauthServers:          oauthServers,
+		registerAccessRequest: registerAccessRequest,
 	}
 
 	svcHandler.mode = marketplace
@@ -55,6 +59,7 @@ func NewAgent(cfg *config.AgentConfig, client webmethods.Client) (agent *Agent)
 		stopDiscovery:     make(chan bool),
 		serviceHandler:    svcHandler,
 		maturityState:     cfg.WebMethodConfig.MaturityState,
+		productExport:     cfg.WebMethodConfig.ProductExport,
 	}
 
 	return newAgent(client, disc, pub)
@@ -82,6 +87,9 @@ func (a *Agent) onConfigChange() {
 	a.publisher.Stop()
 
 	a.client.OnConfigChange(cfg.WebMethodConfig)
+	if a.configChangeHandler != nil {
+		a.configChangeHandler(cfg)
+	}
 	a.discovery.OnConfigChange(cfg.WebMethodConfig)
 
 	// Restart Discovery & Publish
@@ -89,6 +97,11 @@ func (a *Agent) onConfigChange() {
 	go a.publisher.Loop()
 }
 
+// OnConfigChange registers a handler called with the new config before discovery restarts
+func (a *Agent) OnConfigChange(handler func(cfg *config.AgentConfig)) {
+	a.configChangeHandler = handler
+}
+
 // Run the agent loop
 func (a *Agent) Run() {
 	coreAgent.OnConfigChange(a.onConfigChange)
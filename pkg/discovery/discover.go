@@ -26,6 +26,7 @@ type discovery struct {
 	stopDiscovery     chan bool
 	serviceHandler    ServiceHandler
 	maturityState     string
+	productExport     string
 }
 
 func (d *discovery) Stop() {
@@ -34,6 +35,7 @@ func (d *discovery) Stop() {
 
 func (d *discovery) OnConfigChange(cfg *config.WebMethodConfig) {
 	d.pollInterval = cfg.PollInterval
+	d.productExport = cfg.ProductExport
 	d.serviceHandler.OnConfigChange(cfg)
 }
 
@@ -67,6 +69,13 @@ func (d *discovery) discoverAPIs() {
 		return
 	}
 
+	packages := loadPackageCatalog(d.client)
+	if d.productExport != "" {
+		if err := packages.export(d.productExport); err != nil {
+			log.Errorf("Unable to export products to %s : %v", d.productExport, err)
+		}
+	}
+
 	for _, api := range apis.WebmethodsApi {
 		go func(api webmethods.WebmethodsApi) {
 			apiResponse, err := d.client.GetApiDetails(api.Id)
@@ -102,6 +111,7 @@ func (d *discovery) discoverAPIs() {
 					ApiSpec:       specification,
 					ApiType:       api.ApiType,
 				}
+				amplifyApi.Packages, amplifyApi.Plans, amplifyApi.PlanNames = packages.forAPI(api.Id)
 				svcDetail := d.serviceHandler.ToServiceDetail(&amplifyApi)
 				if svcDetail != nil {
 					d.apiChan <- svcDetail
@@ -0,0 +1,142 @@
+package discovery
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Axway/agent-sdk/pkg/agent"
+	catalog "github.com/Axway/agent-sdk/pkg/apic/apiserver/models/catalog/v1alpha1"
+	"github.com/Axway/agent-sdk/pkg/apic/provisioning"
+	"github.com/Axway/agent-sdk/pkg/util/log"
+	"github.com/Axway/agents-webmethods/pkg/common"
+	"github.com/Axway/agents-webmethods/pkg/webmethods"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	attrPackages  = "webmethodsPackages"
+	attrPlans     = "webmethodsPlans"
+	attrPackageID = "webmethodsPackageId"
+	attrPlanID    = "webmethodsPlanId"
+
+	productPlanType = "free"
+)
+
+// packageCatalog indexes the webMethods packages and their plans
+type packageCatalog struct {
+	packages []webmethods.Package
+	plans    map[string]webmethods.Plan
+}
+
+// loadPackageCatalog reads the packages and plans from webMethods. An empty catalog is returned when they can not be read.
+func loadPackageCatalog(client webmethods.Client) *packageCatalog {
+	c := &packageCatalog{plans: map[string]webmethods.Plan{}}
+	packageResponse, err := client.ListPackages()
+	if err != nil {
+		log.Warnf("Unable to list packages, package membership will not be published: %v", err)
+		return c
+	}
+	planResponse, err := client.ListPlans()
+	if err != nil {
+		log.Warnf("Unable to list plans, package membership will not be published: %v", err)
+		return c
+	}
+	c.packages = packageResponse.Packages
+	for _, plan := range planResponse.Plans {
+		c.plans[plan.Id] = plan
+	}
+	return c
+}
+
+// forAPI returns the names of the active packages exposing the API, the descriptions of their plans and the plan names
+func (c *packageCatalog) forAPI(apiID string) ([]string, []string, []string) {
+	packages := []string{}
+	plans := []string{}
+	planNames := []string{}
+	for _, pkg := range c.packages {
+		if !pkg.Active || !slices.Contains(pkg.Apis, apiID) {
+			continue
+		}
+		packages = append(packages, pkg.Name)
+		for _, planID := range pkg.Plans {
+			if plan, ok := c.plans[planID]; ok {
+				description := describePlan(plan)
+				if !slices.Contains(plans, description) {
+					plans = append(plans, description)
+				}
+				if !slices.Contains(planNames, plan.Name) {
+					planNames = append(planNames, plan.Name)
+				}
+			}
+		}
+	}
+	return packages, plans, planNames
+}
+
+// planAccessRequestName names the access request definition offering the plans, the APIs with the same plans share it
+func planAccessRequestName(ardName string, plans []string) string {
+	sorted := slices.Clone(plans)
+	slices.Sort(sorted)
+	sum := sha256.Sum256([]byte(strings.Join(sorted, "\n")))
+	return fmt.Sprintf("%s-plans-%x", ardName, sum[:4])
+}
+
+// registerAccessRequest creates or updates the access request definition in Central
+func registerAccessRequest(name string, schema provisioning.SchemaBuilder) error {
+	_, err := agent.NewAccessRequestBuilder().SetName(name).SetRequestSchema(schema).Register()
+	return err
+}
+
+// export writes Central product and product plan definitions mirroring the active packages to the file
+func (c *packageCatalog) export(path string) error {
+	resources := []interface{}{}
+	for _, pkg := range c.packages {
+		if !pkg.Active {
+			continue
+		}
+		productName := common.CentralName(pkg.Name)
+		product := catalog.NewProduct(productName)
+		product.Title = pkg.Name
+		product.Attributes = map[string]string{attrPackageID: pkg.Id}
+		product.Spec.Description = pkg.Description
+		resources = append(resources, product)
+
+		for _, planID := range pkg.Plans {
+			plan, ok := c.plans[planID]
+			if !ok {
+				continue
+			}
+			productPlan := catalog.NewProductPlan(productName + "-" + common.CentralName(plan.Name))
+			productPlan.Title = plan.Name
+			productPlan.Attributes = map[string]string{attrPackageID: pkg.Id, attrPlanID: plan.Id}
+			productPlan.Spec.Product = productName
+			productPlan.Spec.Description = describePlan(plan)
+			productPlan.Spec.Type = productPlanType
+			resources = append(resources, productPlan)
+		}
+	}
+
+	buffer, err := json.MarshalIndent(resources, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, buffer, 0644)
+}
+
+// describePlan summarizes the plan and its limits
+func describePlan(plan webmethods.Plan) string {
+	limits := []string{}
+	if plan.RateLimit != nil && plan.RateLimit.MaxRequestCount > 0 {
+		limits = append(limits, fmt.Sprintf("rate limit %d requests per %ds", plan.RateLimit.MaxRequestCount, plan.RateLimit.IntervalInSeconds))
+	}
+	if plan.Quota != nil && plan.Quota.MaxRequestCount > 0 {
+		limits = append(limits, fmt.Sprintf("quota %d requests per %ds", plan.Quota.MaxRequestCount, plan.Quota.IntervalInSeconds))
+	}
+	if len(limits) == 0 {
+		return plan.Name
+	}
+	return fmt.Sprintf("%s (%s)", plan.Name, strings.Join(limits, ", "))
+}
@@ -0,0 +1,143 @@
+package discovery
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	coreapi "github.com/Axway/agent-sdk/pkg/api"
+	"github.com/Axway/agents-webmethods/pkg/config"
+	"github.com/Axway/agents-webmethods/pkg/webmethods"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	testPackages = []webmethods.Package{
+		{Id: "pkg-1", Name: "Gold Package", Description: "gold", Active: true, Apis: []string{"api-1", "api-2"}, Plans: []string{"plan-1", "plan-2"}},
+		{Id: "pkg-2", Name: "Silver", Active: true, Apis: []string{"api-1"}, Plans: []string{"plan-1", "unknown"}},
+		{Id: "pkg-3", Name: "Retired", Active: false, Apis: []string{"api-1"}, Plans: []string{"plan-2"}},
+	}
+	testPlans = []webmethods.Plan{
+		{Id: "plan-1", Name: "Basic"},
+		{
+			Id:        "plan-2",
+			Name:      "Premium",
+			RateLimit: &webmethods.PlanLimit{MaxRequestCount: 10, IntervalInSeconds: 1},
+			Quota:     &webmethods.PlanLimit{MaxRequestCount: 1000, IntervalInSeconds: 86400},
+		},
+	}
+)
+
+// newPackageClient serves the packages and plans, failing the requests to the paths in fail
+func newPackageClient(t *testing.T, fail ...string) webmethods.Client {
+	mc := &webmethods.MockClient{SendFunc: func(request coreapi.Request) (*coreapi.Response, error) {
+		for _, path := range fail {
+			if strings.HasSuffix(request.URL, path) {
+				return &coreapi.Response{Code: http.StatusInternalServerError}, nil
+			}
+		}
+		var body interface{}
+		switch {
+		case strings.HasSuffix(request.URL, "/packages"):
+			body = webmethods.PackageResponse{Packages: testPackages}
+		case strings.HasSuffix(request.URL, "/plans"):
+			body = webmethods.PlanResponse{Plans: testPlans}
+		default:
+			return &coreapi.Response{Code: http.StatusNotFound}, nil
+		}
+		buffer, err := json.Marshal(body)
+		return &coreapi.Response{Code: http.StatusOK, Body: buffer}, err
+	}}
+	client, err := webmethods.NewClient(&config.WebMethodConfig{}, mc)
+	assert.Nil(t, err)
+	return client
+}
+
+func TestLoadPackageCatalog(t *testing.T) {
+	catalog := loadPackageCatalog(newPackageClient(t))
+	assert.Len(t, catalog.packages, 3)
+	assert.Len(t, catalog.plans, 2)
+
+	for _, path := range []string{"/packages", "/plans"} {
+		catalog = loadPackageCatalog(newPackageClient(t, path))
+		assert.Empty(t, catalog.packages, path)
+		assert.Empty(t, catalog.plans, path)
+	}
+}
+
+func TestPackageCatalogForAPI(t *testing.T) {
+	catalog := loadPackageCatalog(newPackageClient(t))
+
+	packages, plans, planNames := catalog.forAPI("api-1")
+	assert.Equal(t, []string{"Gold Package", "Silver"}, packages)
+	assert.Equal(t, []string{"Basic", "Premium (rate limit 10 requests per 1s, quota 1000 requests per 86400s)"}, plans)
+	assert.Equal(t, []string{"Basic", "Premium"}, planNames)
+
+	packages, plans, planNames = catalog.forAPI("api-2")
+	assert.Equal(t, []string{"Gold Package"}, packages)
+	assert.Len(t, plans, 2)
+	assert.Equal(t, []string{"Basic", "Premium"}, planNames)
+
+	packages, plans, planNames = catalog.forAPI("api-3")
+	assert.Empty(t, packages)
+	assert.Empty(t, plans)
+	assert.Empty(t, planNames)
+}
+
+func TestPlanAccessRequestName(t *testing.T) {
+	name := planAccessRequestName("api-key", []string{"Premium", "Basic"})
+	assert.True(t, strings.HasPrefix(name, "api-key-plans-"))
+	assert.Equal(t, name, planAccessRequestName("api-key", []string{"Basic", "Premium"}))
+	assert.NotEqual(t, name, planAccessRequestName("api-key", []string{"Basic"}))
+	assert.NotEqual(t, name, planAccessRequestName("oauth2", []string{"Basic", "Premium"}))
+}
+
+func TestDescribePlan(t *testing.T) {
+	assert.Equal(t, "Basic", describePlan(webmethods.Plan{Name: "Basic"}))
+	assert.Equal(t, "Basic", describePlan(webmethods.Plan{Name: "Basic", Quota: &webmethods.PlanLimit{}}))
+	assert.Equal(t, "Limited (quota 5 requests per 60s)", describePlan(webmethods.Plan{
+		Name:  "Limited",
+		Quota: &webmethods.PlanLimit{MaxRequestCount: 5, IntervalInSeconds: 60},
+	}))
+}
+
+func TestPackageCatalogExport(t *testing.T) {
+	catalog := loadPackageCatalog(newPackageClient(t))
+	path := filepath.Join(t.TempDir(), "products.json")
+
+	assert.Nil(t, catalog.export(path))
+	buffer, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	resources := []struct {
+		Kind       string            `json:"kind"`
+		Name       string            `json:"name"`
+		Title      string            `json:"title"`
+		Attributes map[string]string `json:"attributes"`
+		Spec       struct {
+			Product     string `json:"product"`
+			Description string `json:"description"`
+		} `json:"spec"`
+	}{}
+	assert.Nil(t, json.Unmarshal(buffer, &resources))
+
+	names := []string{}
+	for _, resource := range resources {
+		names = append(names, resource.Kind+"/"+resource.Name)
+	}
+	// the inactive package and the unknown plan are not exported
+	assert.Equal(t, []string{
+		"Product/gold-package",
+		"ProductPlan/gold-package-basic",
+		"ProductPlan/gold-package-premium",
+		"Product/silver",
+		"ProductPlan/silver-basic",
+	}, names)
+	assert.Equal(t, "Gold Package", resources[0].Title)
+	assert.Equal(t, "pkg-1", resources[0].Attributes[attrPackageID])
+	assert.Equal(t, "gold-package", resources[2].Spec.Product)
+	assert.Equal(t, "plan-2", resources[2].Attributes[attrPlanID])
+	assert.Equal(t, describePlan(testPlans[1]), resources[2].Spec.Description)
+}
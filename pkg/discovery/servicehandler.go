@@ -3,7 +3,9 @@ package discovery
 import (
 	"crypto/sha256"
 	"fmt"
+	"strings"
 
+	defs "github.com/Axway/agent-sdk/pkg/apic/definitions"
 	"github.com/Axway/agent-sdk/pkg/apic/provisioning"
 	"github.com/Axway/agent-sdk/pkg/cache"
 	"github.com/Axway/agents-webmethods/pkg/common"
@@ -30,6 +32,10 @@ type serviceHandler struct {
 	client webmethods.Client
 	cache  cache.Cache
 	mode   string
+	// oauthServers are the registered oauth credential request definitions, one per authorization server alias
+	oauthServers *subscription.OauthServers
+	// registerAccessRequest registers the access request definitions offering the plans of the APIs
+	registerAccessRequest func(name string, schema provisioning.SchemaBuilder) error
 }
 
 func (s *serviceHandler) OnConfigChange(cfg *config.WebMethodConfig) {
@@ -57,7 +63,7 @@ func (s *serviceHandler) getServiceDetail(api *webmethods.AmplifyAPI) (*ServiceD
 		"id":   api.ID,
 	})
 
-	isAlreadyPublished, checksum := isPublished(api, s.cache)
+	isAlreadyPublished, checksum := isPublished(api, s.oauthServers.CredentialTypes(), s.cache)
 	// If true, then the api is published and there were no changes detected
 	if isAlreadyPublished {
 		logger.Debug("api is already published")
@@ -100,8 +106,13 @@ func (s *serviceHandler) getServiceDetail(api *webmethods.AmplifyAPI) (*ServiceD
 					break
 				}
 				if value == apic.Oauth {
+					oauthCRDs := s.oauthServers.CredentialTypes()
+					if len(oauthCRDs) == 0 {
+						logger.Warn("Oauth2 is not available on the gateway, the API is published without access request definition")
+						break
+					}
 					ardName = subscription.OAuth2AuthType
-					crds[0] = subscription.OAuth2AuthType
+					crds = oauthCRDs
 					break
 				}
 			}
@@ -110,6 +121,24 @@ func (s *serviceHandler) getServiceDetail(api *webmethods.AmplifyAPI) (*ServiceD
 		logger.Info("Ignoring authentication")
 	}
 
+	if ardName != "" && len(api.PlanNames) > 0 {
+		// only the plans of the packages exposing the API are offered
+		name := planAccessRequestName(ardName, api.PlanNames)
+		if err := s.registerAccessRequest(name, subscription.AccessRequestSchema(api.PlanNames)); err != nil {
+			logger.WithError(err).Warn("unable to register the access request definition offering the plans of the API")
+		} else {
+			ardName = name
+		}
+	}
+
+	serviceAttributes := map[string]string{
+		"GatewayType": "webMethods",
+	}
+	if len(api.Packages) > 0 {
+		serviceAttributes[attrPackages] = strings.Join(api.Packages, ", ")
+		serviceAttributes[attrPlans] = strings.Join(api.Plans, ", ")
+	}
+
 	return &ServiceDetail{
 		AccessRequestDefinition: ardName,
 		CRDs:                    crds,
@@ -118,14 +147,14 @@ func (s *serviceHandler) getServiceDetail(api *webmethods.AmplifyAPI) (*ServiceD
 		//AuthPolicy:              api.AuthPolicy,
 		Description: api.Description,
 		// Use the Asset ID for the externalAPIID so that apis linked to the asset are created as a revision
-		ID:           api.ID,
-		ResourceType: specType,
-		ServiceAttributes: map[string]string{
-			"GatewayType": "webMethods",
-		},
+		ID:                api.ID,
+		ResourceType:      specType,
+		ServiceAttributes: serviceAttributes,
 		AgentDetails: map[string]string{
 			common.AttrAPIID:    api.ID,
 			common.AttrChecksum: checksum,
+			// the traceability agent reports the version of the transactions of the instance
+			defs.AttrExternalAPIVersion: api.Version,
 		},
 		Title:   api.Name,
 		Version: api.Version,
@@ -151,10 +180,15 @@ func makeChecksum(val interface{}) string {
 	return fmt.Sprintf("%x", sum)
 }
 
-// isPublished checks if an api is published with the latest changes. Returns true if it is, and false if it is not.
-func isPublished(api *webmethods.AmplifyAPI, c cache.Cache) (bool, string) {
+// isPublished checks if an api is published with the latest changes and oauth credential request definitions. Returns
+// true if it is, and false if it is not.
+func isPublished(api *webmethods.AmplifyAPI, credentialTypes []string, c cache.Cache) (bool, string) {
 	// Change detection (asset + policies)
 	checksum := makeChecksum(api)
+	if len(credentialTypes) > 0 {
+		// the oauth APIs are published again with the credential request definitions of the current authorization servers
+		checksum = makeChecksum(checksum + strings.Join(credentialTypes, ","))
+	}
 	item, err := c.Get(checksum)
 	if err != nil || item == nil {
 		return false, checksum
@@ -0,0 +1,74 @@
+package discovery
+
+import (
+	"testing"
+
+	"github.com/Axway/agent-sdk/pkg/apic/provisioning"
+	"github.com/Axway/agent-sdk/pkg/cache"
+	"github.com/Axway/agents-webmethods/pkg/config"
+	"github.com/Axway/agents-webmethods/pkg/subscription"
+	"github.com/Axway/agents-webmethods/pkg/webmethods"
+	"github.com/stretchr/testify/assert"
+)
+
+const apiKeySpec = `{
+  "openapi": "3.0.1",
+  "info": {"title": "pets", "version": "1.0"},
+  "paths": {},
+  "components": {"securitySchemes": {"key": {"type": "apiKey", "in": "header", "name": "x-Gateway-APIKey"}}}
+}`
+
+const oauthSpec = `{
+  "openapi": "3.0.1",
+  "info": {"title": "pets", "version": "1.0"},
+  "paths": {},
+  "components": {"securitySchemes": {"oauth": {"type": "oauth2", "flows": {"clientCredentials": {"tokenUrl": "https://idp/token", "scopes": {}}}}}}
+}`
+
+func newTestServiceHandler(registered map[string]provisioning.SchemaBuilder) *serviceHandler {
+	config.SetConfig(&config.AgentConfig{WebMethodConfig: &config.WebMethodConfig{}})
+	return &serviceHandler{
+		cache:        cache.New(),
+		oauthServers: subscription.NewOauthServers(),
+		registerAccessRequest: func(name string, schema provisioning.SchemaBuilder) error {
+			registered[name] = schema
+			return nil
+		},
+	}
+}
+
+func TestServiceDetailOffersThePlansOfTheAPI(t *testing.T) {
+	registered := map[string]provisioning.SchemaBuilder{}
+	handler := newTestServiceHandler(registered)
+
+	api := &webmethods.AmplifyAPI{ID: "api-1", Name: "pets", ApiType: "REST", ApiSpec: []byte(apiKeySpec)}
+	detail := handler.ToServiceDetail(api)
+	assert.Equal(t, provisioning.APIKeyARD, detail.AccessRequestDefinition)
+	assert.Empty(t, registered)
+
+	// the plans of the packages changed, the API is published again with its own plans
+	api.Packages, api.Plans, api.PlanNames = []string{"Gold"}, []string{"Basic"}, []string{"Basic"}
+	detail = handler.ToServiceDetail(api)
+	name := planAccessRequestName(provisioning.APIKeyARD, []string{"Basic"})
+	assert.Equal(t, name, detail.AccessRequestDefinition)
+	assert.Contains(t, registered, name)
+	schema, err := registered[name].Build()
+	assert.Nil(t, err)
+	assert.Contains(t, schema["properties"], subscription.PlanField)
+}
+
+func TestOauthAPIIsPublishedAgainWhenAuthorizationServersChange(t *testing.T) {
+	handler := newTestServiceHandler(map[string]provisioning.SchemaBuilder{})
+	api := &webmethods.AmplifyAPI{ID: "api-1", Name: "pets", ApiType: "REST", ApiSpec: []byte(oauthSpec)}
+
+	detail := handler.ToServiceDetail(api)
+	assert.Equal(t, "", detail.AccessRequestDefinition)
+	assert.Nil(t, handler.ToServiceDetail(api))
+
+	handler.oauthServers.Set(map[string]string{"oauth2-local": "local"})
+	detail = handler.ToServiceDetail(api)
+	assert.NotNil(t, detail)
+	assert.Equal(t, subscription.OAuth2AuthType, detail.AccessRequestDefinition)
+	assert.Equal(t, []string{"oauth2-local"}, detail.CRDs)
+	assert.Nil(t, handler.ToServiceDetail(api))
+}
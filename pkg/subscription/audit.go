@@ -0,0 +1,137 @@
+package subscription
+
+import (
+	"time"
+
+	prov "github.com/Axway/agent-sdk/pkg/apic/provisioning"
+	"github.com/Axway/agent-sdk/pkg/util"
+	"github.com/Axway/agents-webmethods/pkg/audit"
+	"github.com/Axway/agents-webmethods/pkg/common"
+	"github.com/sirupsen/logrus"
+)
+
+// auditedProvisioner records every provisioning action handled by the provisioner in the audit sink
+type auditedProvisioner struct {
+	prov.Provisioning
+	sink audit.Sink
+	log  logrus.FieldLogger
+}
+
+// AccessRequestDeprovision records the removal of an access
+func (a auditedProvisioner) AccessRequestDeprovision(req prov.AccessRequest) prov.RequestStatus {
+	start := time.Now()
+	status := a.Provisioning.AccessRequestDeprovision(req)
+	a.record(accessRecord(req, audit.Deprovision), start, status)
+	return status
+}
+
+// AccessRequestProvision records the grant of an access
+func (a auditedProvisioner) AccessRequestProvision(req prov.AccessRequest) (prov.RequestStatus, prov.AccessData) {
+	start := time.Now()
+	status, data := a.Provisioning.AccessRequestProvision(req)
+	a.record(accessRecord(req, audit.Provision), start, status)
+	return status, data
+}
+
+// ApplicationRequestDeprovision records the removal of an application
+func (a auditedProvisioner) ApplicationRequestDeprovision(req prov.ApplicationRequest) prov.RequestStatus {
+	start := time.Now()
+	status := a.Provisioning.ApplicationRequestDeprovision(req)
+	a.record(applicationRecord(req, audit.Deprovision), start, status)
+	return status
+}
+
+// ApplicationRequestProvision records the creation of an application
+func (a auditedProvisioner) ApplicationRequestProvision(req prov.ApplicationRequest) prov.RequestStatus {
+	start := time.Now()
+	status := a.Provisioning.ApplicationRequestProvision(req)
+	a.record(applicationRecord(req, audit.Provision), start, status)
+	return status
+}
+
+// CredentialDeprovision records the removal of a credential
+func (a auditedProvisioner) CredentialDeprovision(req prov.CredentialRequest) prov.RequestStatus {
+	start := time.Now()
+	status := a.Provisioning.CredentialDeprovision(req)
+	record := credentialRecord(req, audit.Deprovision)
+	record.ApplicationID = req.GetCredentialDetailsValue(common.AttrAppID)
+	a.record(record, start, status)
+	return status
+}
+
+// CredentialProvision records the creation of a credential
+func (a auditedProvisioner) CredentialProvision(req prov.CredentialRequest) (prov.RequestStatus, prov.Credential) {
+	start := time.Now()
+	status, credential := a.Provisioning.CredentialProvision(req)
+	a.record(credentialRecord(req, audit.Provision), start, status)
+	return status, credential
+}
+
+// CredentialUpdate records the rotation, suspension or enablement of a credential
+func (a auditedProvisioner) CredentialUpdate(req prov.CredentialRequest) (prov.RequestStatus, prov.Credential) {
+	start := time.Now()
+	status, credential := a.Provisioning.CredentialUpdate(req)
+	record := credentialRecord(req, audit.Update+" "+req.GetCredentialAction().String())
+	a.record(record, start, status)
+	return status, credential
+}
+
+func accessRecord(req prov.AccessRequest, action string) audit.Record {
+	return audit.Record{
+		Request:            audit.AccessRequest,
+		Action:             action,
+		CentralID:          req.GetID(),
+		ManagedApplication: req.GetApplicationName(),
+		APIID:              util.ToString(req.GetInstanceDetails()[common.AttrAPIID]),
+		ApplicationID:      req.GetAccessRequestDetailsValue(common.AttrAppID),
+		SubscriptionID:     req.GetAccessRequestDetailsValue(common.AttrSubscriptionID),
+	}
+}
+
+func applicationRecord(req prov.ApplicationRequest, action string) audit.Record {
+	return audit.Record{
+		Request:            audit.ApplicationRequest,
+		Action:             action,
+		CentralID:          req.GetID(),
+		ManagedApplication: req.GetManagedApplicationName(),
+		ApplicationID:      req.GetApplicationDetailsValue(common.AttrAppID),
+	}
+}
+
+func credentialRecord(req prov.CredentialRequest, action string) audit.Record {
+	return audit.Record{
+		Request:            audit.CredentialRequest,
+		Action:             action,
+		CentralID:          req.GetID(),
+		ManagedApplication: req.GetApplicationName(),
+		CredentialType:     req.GetCredentialType(),
+		ApplicationID:      req.GetApplicationDetailsValue(common.AttrAppID),
+		StrategyID:         req.GetCredentialDetailsValue(common.AttrStrategyID),
+	}
+}
+
+// record completes the record with the outcome of the request and writes it to the sink
+func (a auditedProvisioner) record(record audit.Record, start time.Time, status prov.RequestStatus) {
+	record.Time = start.UTC()
+	record.DurationMs = time.Since(start).Milliseconds()
+	if status != nil {
+		record.Outcome = status.GetStatus().String()
+		if status.GetStatus() != prov.Success {
+			record.Error = status.GetMessage()
+		}
+		// the ids given by the provisioning take precedence over the ones known before
+		properties := status.GetProperties()
+		if id := properties[common.AttrAppID]; id != "" {
+			record.ApplicationID = id
+		}
+		if id := properties[common.AttrStrategyID]; id != "" {
+			record.StrategyID = id
+		}
+		if id := properties[common.AttrSubscriptionID]; id != "" {
+			record.SubscriptionID = id
+		}
+	}
+	if err := a.sink.Write(record); err != nil {
+		a.log.WithError(err).Error("unable to write the provisioning audit record")
+	}
+}
@@ -0,0 +1,66 @@
+package subscription
+
+import (
+	"testing"
+
+	coreapi "github.com/Axway/agent-sdk/pkg/api"
+	prov "github.com/Axway/agent-sdk/pkg/apic/provisioning"
+	"github.com/Axway/agent-sdk/pkg/apic/provisioning/mock"
+	"github.com/Axway/agents-webmethods/pkg/audit"
+	"github.com/Axway/agents-webmethods/pkg/common"
+	"github.com/Axway/agents-webmethods/pkg/config"
+	"github.com/Axway/agents-webmethods/pkg/webmethods"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeAuditSink []audit.Record
+
+func (s *fakeAuditSink) Write(record audit.Record) error {
+	*s = append(*s, record)
+	return nil
+}
+
+func TestAuditedProvisioner(t *testing.T) {
+	gateway := newFakeGateway()
+	gateway.addApplication(webmethods.Application{Id: "app", Name: "consumer"})
+	gateway.failOn(coreapi.POST, strategiesPath)
+	mc := &webmethods.MockClient{SendFunc: gateway.send}
+	client, err := webmethods.NewClient(&config.WebMethodConfig{}, mc)
+	assert.Nil(t, err)
+	sink := &fakeAuditSink{}
+	p := NewProvisioner(client, &config.WebMethodConfig{}, logrus.New(), WithAuditSink(sink))
+
+	status, _ := p.AccessRequestProvision(mock.MockAccessRequest{
+		ID:              "access-request",
+		AppName:         "consumer",
+		AppDetails:      map[string]string{common.AttrAppID: "app"},
+		InstanceDetails: map[string]interface{}{common.AttrAPIID: "api-1"},
+	})
+	assert.Equal(t, prov.Success, status.GetStatus())
+	status, _ = p.CredentialProvision(mock.MockCredentialRequest{
+		ID:          "credential",
+		AppName:     "consumer",
+		AppDetails:  map[string]string{common.AttrAppID: "app"},
+		CredDefName: OAuth2AuthType,
+	})
+	assert.Equal(t, prov.Error, status.GetStatus())
+
+	assert.Len(t, *sink, 2)
+	access := (*sink)[0]
+	assert.Equal(t, audit.AccessRequest, access.Request)
+	assert.Equal(t, audit.Provision, access.Action)
+	assert.Equal(t, "access-request", access.CentralID)
+	assert.Equal(t, "consumer", access.ManagedApplication)
+	assert.Equal(t, "api-1", access.APIID)
+	assert.Equal(t, "app", access.ApplicationID)
+	assert.Equal(t, prov.Success.String(), access.Outcome)
+	assert.Empty(t, access.Error)
+
+	credential := (*sink)[1]
+	assert.Equal(t, audit.CredentialRequest, credential.Request)
+	assert.Equal(t, OAuth2AuthType, credential.CredentialType)
+	assert.Equal(t, "app", credential.ApplicationID)
+	assert.Equal(t, prov.Error.String(), credential.Outcome)
+	assert.NotEmpty(t, credential.Error)
+}
@@ -0,0 +1,210 @@
+package subscription
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	v1 "github.com/Axway/agent-sdk/pkg/apic/apiserver/models/api/v1"
+	management "github.com/Axway/agent-sdk/pkg/apic/apiserver/models/management/v1alpha1"
+	prov "github.com/Axway/agent-sdk/pkg/apic/provisioning"
+	"github.com/Axway/agent-sdk/pkg/util"
+	hc "github.com/Axway/agent-sdk/pkg/util/healthcheck"
+	"github.com/Axway/agents-webmethods/pkg/common"
+	"github.com/Axway/agents-webmethods/pkg/config"
+	"github.com/Axway/agents-webmethods/pkg/webmethods"
+	"github.com/sirupsen/logrus"
+)
+
+// expirationDateLayouts are the formats in which webMethods reports the expiration date of an api key
+var expirationDateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05.000Z0700",
+	"2006-01-02 15:04:05 MST",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// parseExpirationDate parses the expiration date of an api key, either formatted or as epoch milliseconds
+func parseExpirationDate(value string) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, false
+	}
+	if millis, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.UnixMilli(millis), true
+	}
+	for _, layout := range expirationDateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// formatExpirationInterval formats a duration as a webMethods expiration interval, i.e. "30d 12h 5m"
+func formatExpirationInterval(d time.Duration) string {
+	d = d.Round(time.Minute)
+	days := d / (24 * time.Hour)
+	d -= days * 24 * time.Hour
+	hours := d / time.Hour
+	d -= hours * time.Hour
+	minutes := d / time.Minute
+
+	parts := []string{}
+	if days > 0 {
+		parts = append(parts, fmt.Sprintf("%dd", days))
+	}
+	if hours > 0 {
+		parts = append(parts, fmt.Sprintf("%dh", hours))
+	}
+	if minutes > 0 || len(parts) == 0 {
+		parts = append(parts, fmt.Sprintf("%dm", minutes))
+	}
+	return strings.Join(parts, " ")
+}
+
+// apiKeyExpiration returns the expiration date of the api key of the application, when it has one
+func apiKeyExpiration(application webmethods.Application) (time.Time, bool) {
+	return parseExpirationDate(application.AccessTokens.ApiAccessKeyCredentials.ExpirationDate)
+}
+
+// apiKeyCredential builds the api key credential of the application, carrying the key expiration date
+func apiKeyCredential(application webmethods.Application) prov.Credential {
+	builder := prov.NewCredentialBuilder()
+	if expiration, ok := apiKeyExpiration(application); ok {
+		builder.SetExpirationTime(expiration)
+	}
+	return builder.SetAPIKey(application.AccessTokens.ApiAccessKeyCredentials.ApiAccessKey)
+}
+
+// apiKeyID identifies an api key in the credential details without exposing it
+func apiKeyID(key string) string {
+	if key == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// credentialAPIKey returns the api key of the credential
+func credentialAPIKey(credential prov.Credential) string {
+	key, _ := credential.GetData()[prov.APIKey].(string)
+	return key
+}
+
+type managedApplicationCache interface {
+	GetManagedApplicationByName(name string) *v1.ResourceInstance
+}
+
+type credentialClient interface {
+	GetResources(ri v1.Interface) ([]v1.Interface, error)
+	UpdateResourceInstance(ri v1.Interface) (*v1.ResourceInstance, error)
+}
+
+// ExpiryMonitor implements the jobs.Job interface. Warns about the api key credentials that are about to expire and,
+// when auto rotation is enabled, asks Central to rotate them so the new key is provisioned through CredentialUpdate.
+// Oauth client credentials are not checked, only the tokens issued for them expire.
+type ExpiryMonitor struct {
+	client     webmethods.Client
+	cache      managedApplicationCache
+	central    credentialClient
+	envName    string
+	warning    time.Duration
+	autoRotate bool
+	log        logrus.FieldLogger
+}
+
+// NewExpiryMonitor creates the credential expiry job
+func NewExpiryMonitor(client webmethods.Client, cache managedApplicationCache, central credentialClient, cfg *config.WebMethodConfig, envName string, log logrus.FieldLogger) *ExpiryMonitor {
+	return &ExpiryMonitor{
+		client:     client,
+		cache:      cache,
+		central:    central,
+		envName:    envName,
+		warning:    cfg.ExpiryWarning,
+		autoRotate: cfg.AutoRotate,
+		log:        log.WithField("component", "expiry-monitor"),
+	}
+}
+
+// Ready determines if the job is ready to run.
+func (m *ExpiryMonitor) Ready() bool {
+	return m.client.Healthcheck("health").Result == hc.OK
+}
+
+// Status Performs a health check for this job before it is executed.
+func (m *ExpiryMonitor) Status() error {
+	status := m.client.Healthcheck("health")
+	if status.Result != hc.OK {
+		return errors.New(status.Details)
+	}
+	return nil
+}
+
+// Execute called by the sdk on each interval.
+func (m *ExpiryMonitor) Execute() error {
+	m.log.Debug("checking credential expiry")
+	credentials, err := m.central.GetResources(management.NewCredential("", m.envName))
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, iface := range credentials {
+		ri, err := iface.AsInstance()
+		if err != nil {
+			continue
+		}
+		credential := &management.Credential{}
+		if err := credential.FromInstance(ri); err != nil {
+			continue
+		}
+		m.checkCredential(credential, now)
+	}
+	return nil
+}
+
+// checkCredential warns about an active api key credential expiring within the warning period and requests its rotation
+func (m *ExpiryMonitor) checkCredential(credential *management.Credential, now time.Time) {
+	if credential.Spec.CredentialRequestDefinition != prov.APIKeyCRD || !isProvisioned(credential.Status) {
+		return
+	}
+	if credential.Spec.State.Name != v1.Active || credential.Spec.State.Rotate {
+		return
+	}
+	ri := m.cache.GetManagedApplicationByName(credential.Spec.ManagedApplication)
+	if ri == nil {
+		return
+	}
+	appID, _ := util.GetAgentDetailsValue(ri, common.AttrAppID)
+	if appID == "" {
+		return
+	}
+	logger := m.log.WithField("credential", credential.Name).WithField("appID", appID)
+
+	applicationResponse, err := m.client.GetApplication(appID)
+	if err != nil || len(applicationResponse.Applications) == 0 {
+		logger.WithError(err).Error("unable to get application from Webmethods")
+		return
+	}
+	expiration, ok := apiKeyExpiration(applicationResponse.Applications[0])
+	if !ok || expiration.Sub(now) > m.warning {
+		return
+	}
+	logger = logger.WithField("expiration", expiration.Format(time.RFC3339))
+	if !m.autoRotate {
+		logger.Warn("credential is about to expire")
+		return
+	}
+
+	credential.Spec.State.Rotate = true
+	if _, err := m.central.UpdateResourceInstance(credential); err != nil {
+		logger.WithError(err).Error("unable to request rotation of the credential")
+		return
+	}
+	logger.Info("credential is about to expire, requested rotation")
+}
@@ -0,0 +1,147 @@
+package subscription
+
+import (
+	"testing"
+	"time"
+
+	v1 "github.com/Axway/agent-sdk/pkg/apic/apiserver/models/api/v1"
+	management "github.com/Axway/agent-sdk/pkg/apic/apiserver/models/management/v1alpha1"
+	prov "github.com/Axway/agent-sdk/pkg/apic/provisioning"
+	"github.com/Axway/agent-sdk/pkg/apic/provisioning/mock"
+	"github.com/Axway/agents-webmethods/pkg/common"
+	"github.com/Axway/agents-webmethods/pkg/config"
+	"github.com/Axway/agents-webmethods/pkg/webmethods"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeCredentialClient struct {
+	credentials []v1.Interface
+	updated     []*management.Credential
+}
+
+func (c *fakeCredentialClient) GetResources(ri v1.Interface) ([]v1.Interface, error) {
+	return c.credentials, nil
+}
+
+func (c *fakeCredentialClient) UpdateResourceInstance(ri v1.Interface) (*v1.ResourceInstance, error) {
+	c.updated = append(c.updated, ri.(*management.Credential))
+	return ri.AsInstance()
+}
+
+type fakeManagedApplicationCache map[string]*v1.ResourceInstance
+
+func (c fakeManagedApplicationCache) GetManagedApplicationByName(name string) *v1.ResourceInstance {
+	return c[name]
+}
+
+func newCredential(name, managedApp string) *management.Credential {
+	credential := management.NewCredential(name, "env")
+	credential.Spec.CredentialRequestDefinition = prov.APIKeyCRD
+	credential.Spec.ManagedApplication = managedApp
+	credential.Spec.State.Name = v1.Active
+	credential.Status = &v1.ResourceStatus{Level: prov.Success.String()}
+	return credential
+}
+
+func newExpiringApplication(id string, expiration time.Time) webmethods.Application {
+	app := webmethods.Application{Id: id, Name: id}
+	app.AccessTokens.ApiAccessKeyCredentials.ApiAccessKey = id + "-key"
+	app.AccessTokens.ApiAccessKeyCredentials.ExpirationDate = expiration.UTC().Format(time.RFC3339)
+	return app
+}
+
+func TestParseExpirationDate(t *testing.T) {
+	tests := map[string]struct {
+		value    string
+		expected time.Time
+		ok       bool
+	}{
+		"empty":        {value: "", ok: false},
+		"rfc3339":      {value: "2024-05-01T10:00:00Z", expected: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC), ok: true},
+		"date time":    {value: "2024-05-01 10:00:00", expected: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC), ok: true},
+		"epoch millis": {value: "1714557600000", expected: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC), ok: true},
+		"invalid":      {value: "never", ok: false},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			expiration, ok := parseExpirationDate(tc.value)
+			assert.Equal(t, tc.ok, ok)
+			if tc.ok {
+				assert.True(t, tc.expected.Equal(expiration))
+			}
+		})
+	}
+}
+
+func TestFormatExpirationInterval(t *testing.T) {
+	assert.Equal(t, "30d", formatExpirationInterval(30*24*time.Hour))
+	assert.Equal(t, "1d 2h 30m", formatExpirationInterval(26*time.Hour+30*time.Minute))
+	assert.Equal(t, "0m", formatExpirationInterval(10*time.Second))
+}
+
+func TestApplicationRequestProvisionSetsAPIKeyLifetime(t *testing.T) {
+	gateway := newFakeGateway()
+	p := newTestProvisioner(t, gateway)
+	p.cfg.APIKeyLifetime = 30 * 24 * time.Hour
+
+	status := p.ApplicationRequestProvision(mock.MockApplicationRequest{AppName: "consumer"})
+
+	assert.Equal(t, prov.Success, status.GetStatus())
+	assert.Equal(t, "30d", gateway.applications["app-1"].AccessTokens.ApiAccessKeyCredentials.ExpirationInterval)
+}
+
+func TestCredentialProvisionSetsExpiration(t *testing.T) {
+	gateway := newFakeGateway()
+	expiration := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	gateway.addApplication(newExpiringApplication("app", expiration))
+	p := newTestProvisioner(t, gateway)
+
+	status, credential := p.CredentialProvision(mock.MockCredentialRequest{
+		AppName:     "consumer",
+		AppDetails:  map[string]string{common.AttrAppID: "app"},
+		CredDefName: prov.APIKeyCRD,
+		CredData:    map[string]interface{}{CorsField: []interface{}{}},
+	})
+
+	assert.Equal(t, prov.Success, status.GetStatus())
+	assert.Equal(t, "app-key", credential.GetData()[prov.APIKey])
+	assert.True(t, expiration.Equal(credential.GetExpirationTime()))
+}
+
+func TestExpiryMonitor(t *testing.T) {
+	tests := map[string]struct {
+		autoRotate bool
+		rotated    []string
+	}{
+		"warn only":   {autoRotate: false, rotated: []string{}},
+		"auto rotate": {autoRotate: true, rotated: []string{"expiring"}},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			gateway := newFakeGateway()
+			gateway.addApplication(newExpiringApplication("soon", time.Now().Add(time.Hour)))
+			gateway.addApplication(newExpiringApplication("later", time.Now().Add(30*24*time.Hour)))
+			cache := fakeManagedApplicationCache{
+				"soon-app":  newManagedApplicationInstance("soon-app", "soon"),
+				"later-app": newManagedApplicationInstance("later-app", "later"),
+			}
+			central := &fakeCredentialClient{
+				credentials: []v1.Interface{newCredential("expiring", "soon-app"), newCredential("valid", "later-app")},
+			}
+			mc := &webmethods.MockClient{SendFunc: gateway.send}
+			client, err := webmethods.NewClient(&config.WebMethodConfig{}, mc)
+			assert.Nil(t, err)
+			cfg := &config.WebMethodConfig{ExpiryWarning: 24 * time.Hour, AutoRotate: tc.autoRotate}
+			monitor := NewExpiryMonitor(client, cache, central, cfg, "env", logrus.New())
+
+			assert.Nil(t, monitor.Execute())
+			rotated := []string{}
+			for _, credential := range central.updated {
+				assert.True(t, credential.Spec.State.Rotate)
+				rotated = append(rotated, credential.Name)
+			}
+			assert.Equal(t, tc.rotated, rotated)
+		})
+	}
+}
@@ -0,0 +1,202 @@
+package subscription
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	prov "github.com/Axway/agent-sdk/pkg/apic/provisioning"
+	"github.com/Axway/agents-webmethods/pkg/webmethods"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	// AllowedIPsField -
+	AllowedIPsField = "allowedIPs"
+	// AllowedHostsField -
+	AllowedHostsField = "allowedHosts"
+	// PlanField - access request field holding the name of the webMethods plan
+	PlanField = "plan"
+
+	// IPAddressRangeIdentifier - webMethods application identifier key for caller IP addresses
+	IPAddressRangeIdentifier = "ipAddressRange"
+	// HostnameIdentifier - webMethods application identifier key for caller hostnames
+	HostnameIdentifier = "hostname"
+
+	identifierSeparator = ","
+)
+
+// AccessRequestSchema builds the access request schema asking for the application identifiers and, when plans are
+// given, the webMethods plan of the access
+func AccessRequestSchema(plans []string) prov.SchemaBuilder {
+	schema := prov.NewSchemaBuilder().
+		AddProperty(
+			prov.NewSchemaPropertyBuilder().
+				SetName(AllowedIPsField).
+				SetLabel("Allowed IP Addresses").
+				SetDescription("Caller IP addresses or CIDR ranges identifying the application").
+				IsArray().
+				AddItem(
+					prov.NewSchemaPropertyBuilder().
+						SetName("IP").
+						IsString())).
+		AddProperty(
+			prov.NewSchemaPropertyBuilder().
+				SetName(AllowedHostsField).
+				SetLabel("Allowed Hostnames").
+				SetDescription("Caller hostnames identifying the application").
+				IsArray().
+				AddItem(
+					prov.NewSchemaPropertyBuilder().
+						SetName("Hostname").
+						IsString()))
+	if len(plans) > 0 {
+		schema.AddProperty(
+			prov.NewSchemaPropertyBuilder().
+				SetName(PlanField).
+				SetLabel("Plan").
+				SetDescription("Webmethods plan applying rate limits and quotas to the access, none for unlimited access").
+				IsString().
+				SetEnumValues(plans))
+	}
+	return schema
+}
+
+type accessMetaData struct {
+	allowedIPs   []string
+	allowedHosts []string
+	plan         string
+}
+
+// getAccessProvData reads the application identifiers from the access request data
+func getAccessProvData(accessData map[string]interface{}) (accessMetaData, error) {
+	accessMetaData := accessMetaData{
+		allowedIPs:   []string{},
+		allowedHosts: []string{},
+	}
+
+	if data, ok := accessData[AllowedIPsField]; ok && data != nil {
+		for _, d := range data.([]interface{}) {
+			ip := strings.TrimSpace(d.(string))
+			if net.ParseIP(ip) == nil {
+				if _, _, err := net.ParseCIDR(ip); err != nil {
+					return accessMetaData, fmt.Errorf("invalid IP address or CIDR %s", ip)
+				}
+			}
+			accessMetaData.allowedIPs = append(accessMetaData.allowedIPs, ip)
+		}
+	}
+	if data, ok := accessData[AllowedHostsField]; ok && data != nil {
+		for _, d := range data.([]interface{}) {
+			host := strings.TrimSpace(d.(string))
+			if host == "" {
+				continue
+			}
+			accessMetaData.allowedHosts = append(accessMetaData.allowedHosts, host)
+		}
+	}
+	if data, ok := accessData[PlanField]; ok && data != nil {
+		accessMetaData.plan = strings.TrimSpace(data.(string))
+	}
+	return accessMetaData, nil
+}
+
+// addIdentifierValues merges values into the application identifier with the given key and returns the values that were not already present
+func addIdentifierValues(application *webmethods.Application, key string, values []string) []string {
+	if len(values) == 0 {
+		return nil
+	}
+	idx := findIdentifier(application.Identifiers, key)
+	if idx == -1 {
+		application.Identifiers = append(application.Identifiers, webmethods.ApplicationIdentifier{
+			Key:   key,
+			Name:  key,
+			Value: []string{},
+		})
+		idx = len(application.Identifiers) - 1
+	}
+	identifier := &application.Identifiers[idx]
+	added := []string{}
+	for _, value := range values {
+		if !slices.Contains(identifier.Value, value) {
+			identifier.Value = append(identifier.Value, value)
+			added = append(added, value)
+		}
+	}
+	return added
+}
+
+// removeIdentifierValues removes values from the application identifier with the given key, dropping the identifier once it is empty
+func removeIdentifierValues(application *webmethods.Application, key string, values []string) bool {
+	idx := findIdentifier(application.Identifiers, key)
+	if idx == -1 || len(values) == 0 {
+		return false
+	}
+	identifier := &application.Identifiers[idx]
+	remaining := []string{}
+	for _, value := range identifier.Value {
+		if !slices.Contains(values, value) {
+			remaining = append(remaining, value)
+		}
+	}
+	if len(remaining) == len(identifier.Value) {
+		return false
+	}
+	if len(remaining) == 0 {
+		application.Identifiers = append(application.Identifiers[:idx], application.Identifiers[idx+1:]...)
+		return true
+	}
+	identifier.Value = remaining
+	return true
+}
+
+// appendShared appends to the added values the requested values that are already recorded
+func appendShared(added, requested, shared []string) []string {
+	for _, value := range requested {
+		if slices.Contains(shared, value) && !slices.Contains(added, value) {
+			added = append(added, value)
+		}
+	}
+	return added
+}
+
+// appendMissing appends the values that are not already in values
+func appendMissing(values, added []string) []string {
+	for _, value := range added {
+		if !slices.Contains(values, value) {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// without returns the values that are not in excluded
+func without(values, excluded []string) []string {
+	remaining := []string{}
+	for _, value := range values {
+		if !slices.Contains(excluded, value) {
+			remaining = append(remaining, value)
+		}
+	}
+	return remaining
+}
+
+func findIdentifier(identifiers []webmethods.ApplicationIdentifier, key string) int {
+	for i, identifier := range identifiers {
+		if identifier.Key == key {
+			return i
+		}
+	}
+	return -1
+}
+
+func joinIdentifierValues(values []string) string {
+	return strings.Join(values, identifierSeparator)
+}
+
+func splitIdentifierValues(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, identifierSeparator)
+}
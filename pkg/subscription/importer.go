@@ -0,0 +1,315 @@
+package subscription
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	v1 "github.com/Axway/agent-sdk/pkg/apic/apiserver/models/api/v1"
+	management "github.com/Axway/agent-sdk/pkg/apic/apiserver/models/management/v1alpha1"
+	defs "github.com/Axway/agent-sdk/pkg/apic/definitions"
+	prov "github.com/Axway/agent-sdk/pkg/apic/provisioning"
+	"github.com/Axway/agent-sdk/pkg/util"
+	hc "github.com/Axway/agent-sdk/pkg/util/healthcheck"
+	"github.com/Axway/agents-webmethods/pkg/common"
+	"github.com/Axway/agents-webmethods/pkg/webmethods"
+	"github.com/sirupsen/logrus"
+)
+
+const importedMessage = "Imported from Webmethods"
+
+type importCache interface {
+	GetManagedApplicationCacheKeys() []string
+	GetManagedApplication(id string) *v1.ResourceInstance
+	ListAccessRequests() []*v1.ResourceInstance
+	GetAPIServiceInstanceKeys() []string
+	GetAPIServiceInstanceByID(id string) (*v1.ResourceInstance, error)
+}
+
+type importClient interface {
+	CreateResourceInstance(ri v1.Interface) (*v1.ResourceInstance, error)
+	CreateSubResource(rm v1.ResourceMeta, subs map[string]interface{}) error
+	DeleteResourceInstance(ri v1.Interface) error
+}
+
+// Importer implements the jobs.Job interface. Creates a managed application in Central for each webMethods application
+// that was not created through the marketplace, and an access request for each of its APIs discovered by the agent, so
+// the traffic of the existing consumers is attributed to them.
+type Importer struct {
+	client       webmethods.Client
+	cacheManager importCache
+	central      importClient
+	environment  string
+	log          logrus.FieldLogger
+}
+
+// NewImporter creates the application import job
+func NewImporter(client webmethods.Client, cacheManager importCache, central importClient, environment string, log logrus.FieldLogger) *Importer {
+	return &Importer{
+		client:       client,
+		cacheManager: cacheManager,
+		central:      central,
+		environment:  environment,
+		log:          log.WithField("component", "importer"),
+	}
+}
+
+// Ready determines if the job is ready to run.
+func (i *Importer) Ready() bool {
+	return i.client.Healthcheck("health").Result == hc.OK
+}
+
+// Status Performs a health check for this job before it is executed.
+func (i *Importer) Status() error {
+	status := i.client.Healthcheck("health")
+	if status.Result != hc.OK {
+		return errors.New(status.Details)
+	}
+	return nil
+}
+
+// Execute called by the sdk on each interval.
+func (i *Importer) Execute() error {
+	i.log.Debug("importing Webmethods applications")
+	applicationResponse, err := i.client.ListApplications()
+	if err != nil {
+		return err
+	}
+
+	managedApps, imported := i.managedApplications()
+	instances := i.serviceInstances()
+	accesses := i.accessRequests()
+	for _, application := range applicationResponse.Applications {
+		logger := i.log.WithField("appName", application.Name).WithField("appID", application.Id)
+		name, found := managedApps[application.Id]
+		if found && !imported[application.Id] {
+			// provisioned through the marketplace, the access requests are managed by the consumers
+			continue
+		}
+		if !found {
+			if strings.HasPrefix(application.Description, applicationDescriptionPrefix) {
+				// created by the agent for a managed application that no longer exists, reported by the reconciler
+				continue
+			}
+			name, err = i.importApplication(application)
+			if err != nil {
+				logger.WithError(err).Error("unable to import application into Central")
+				continue
+			}
+			logger.WithField("managedApplication", name).Info("imported application into Central")
+		}
+
+		for _, apiID := range application.ConsumingAPIs {
+			for _, instance := range instances[apiID] {
+				if accesses[accessKey(name, instance)] {
+					continue
+				}
+				if err := i.importAccess(name, instance, application.Id); err != nil {
+					logger.WithField("apiID", apiID).WithError(err).Error("unable to import API access into Central")
+					continue
+				}
+				accesses[accessKey(name, instance)] = true
+			}
+		}
+	}
+	return nil
+}
+
+// managedApplications returns the names of the managed applications by webMethods application id, and the ids of the
+// applications that were imported. Only the agent details are trusted, the consumers can edit the attributes.
+func (i *Importer) managedApplications() (map[string]string, map[string]bool) {
+	names := map[string]string{}
+	imported := map[string]bool{}
+	for _, key := range i.cacheManager.GetManagedApplicationCacheKeys() {
+		ri := i.cacheManager.GetManagedApplication(key)
+		if ri == nil {
+			continue
+		}
+		appID, _ := util.GetAgentDetailsValue(ri, common.AttrAppID)
+		if appID == "" {
+			continue
+		}
+		names[appID] = ri.Name
+		if isImported(ri) {
+			imported[appID] = true
+			app := &management.ManagedApplication{}
+			if err := app.FromInstance(ri); err == nil {
+				i.repairStatus(ri, app.Status)
+			}
+		}
+	}
+	return names, imported
+}
+
+// serviceInstances returns the names of the service instances by webMethods API id
+func (i *Importer) serviceInstances() map[string][]string {
+	instances := map[string][]string{}
+	for _, key := range i.cacheManager.GetAPIServiceInstanceKeys() {
+		ri, err := i.cacheManager.GetAPIServiceInstanceByID(key)
+		if err != nil || ri == nil {
+			continue
+		}
+		if apiID, _ := util.GetAgentDetailsValue(ri, common.AttrAPIID); apiID != "" {
+			instances[apiID] = append(instances[apiID], ri.Name)
+		}
+	}
+	return instances
+}
+
+// accessRequests returns the managed application and service instance pairs having an access request
+func (i *Importer) accessRequests() map[string]bool {
+	accesses := map[string]bool{}
+	for _, ri := range i.cacheManager.ListAccessRequests() {
+		ar := &management.AccessRequest{}
+		if err := ar.FromInstance(ri); err != nil {
+			continue
+		}
+		accesses[accessKey(ar.Spec.ManagedApplication, ar.Spec.ApiServiceInstance)] = true
+		if isImported(ri) {
+			i.repairStatus(ri, ar.Status)
+		}
+	}
+	return accesses
+}
+
+func accessKey(managedApp, instance string) string {
+	return managedApp + "/" + instance
+}
+
+// importApplication creates the managed application of a webMethods application and returns its name
+func (i *Importer) importApplication(application webmethods.Application) (string, error) {
+	name := importedName(application)
+	managedApp := management.NewManagedApplication(name, i.environment)
+	managedApp.Title = application.Name
+	managedApp.Attributes = importedAttributes(application.Id)
+	ri, err := i.central.CreateResourceInstance(managedApp)
+	if err != nil {
+		return "", err
+	}
+	return name, i.markProvisioned(ri, importedDetails(application.Id))
+}
+
+// importAccess creates the access request of a managed application to a service instance
+func (i *Importer) importAccess(managedApp, instance, appID string) error {
+	ar := management.NewAccessRequest(common.CentralName(managedApp+"-"+instance), i.environment)
+	ar.Title = managedApp + " - " + instance
+	ar.Spec.ManagedApplication = managedApp
+	ar.Spec.ApiServiceInstance = instance
+	ar.Attributes = importedAttributes(appID)
+	ri, err := i.central.CreateResourceInstance(ar)
+	if err != nil {
+		return err
+	}
+	return i.markProvisioned(ri, importedDetails(appID))
+}
+
+// importedName returns the name of the managed application imported for a webMethods application
+func importedName(application webmethods.Application) string {
+	return common.CentralName(application.Name) + "-" + shortID(application.Id)
+}
+
+// importedBy reports whether the webMethods application is the one imported as the managed application
+func importedBy(application webmethods.Application, managedApp string) bool {
+	return strings.HasSuffix(managedApp, "-"+shortID(application.Id))
+}
+
+// importedAttributes tags the resources created by the importer until they are marked provisioned. The attributes can
+// be edited by the consumers, they only stop the provisioner from provisioning the resources in the meantime.
+func importedAttributes(appID string) map[string]string {
+	return map[string]string{
+		common.AttrImported: "true",
+		common.AttrAppID:    appID,
+	}
+}
+
+// importedDetails returns the agent details marking a resource created by the importer
+func importedDetails(appID string) map[string]interface{} {
+	return map[string]interface{}{
+		common.AttrAppID:    appID,
+		common.AttrImported: "true",
+	}
+}
+
+// isImported reports whether the agent details mark the resource as created by the importer
+func isImported(ri *v1.ResourceInstance) bool {
+	flag, _ := util.GetAgentDetailsValue(ri, common.AttrImported)
+	return flag == "true"
+}
+
+// importPending reports whether the resource claims to be created by the importer but was not marked provisioned yet
+func importPending(attributes map[string]string) bool {
+	return attributes[common.AttrImported] == "true"
+}
+
+// importedApplication returns the webMethods application of a managed application imported by the agent, verifying
+// that the application still exists and is the one imported as the managed application
+func (p provisioner) importedApplication(req prov.ApplicationRequest) (string, error) {
+	appName := req.GetManagedApplicationName()
+	if req.GetApplicationDetailsValue(common.AttrImported) != "true" {
+		if app := p.metadata.managedApplication(appName); app != nil && importPending(app.Attributes) {
+			return "", errors.New("The application is being imported from Webmethods")
+		}
+		return "", nil
+	}
+	appID := req.GetApplicationDetailsValue(common.AttrAppID)
+	application, err := getApplication(p.client, appID)
+	if err != nil {
+		return "", err
+	}
+	if !importedBy(*application, appName) {
+		return "", fmt.Errorf("Webmethods application %s was not imported as %s", appID, appName)
+	}
+	return appID, nil
+}
+
+// importedAccess returns the webMethods application of an access request imported by the agent, verifying that it is
+// the application of the managed application
+func (p provisioner) importedAccess(req prov.AccessRequest) (string, error) {
+	if req.GetAccessRequestDetailsValue(common.AttrImported) != "true" {
+		if p.accessRequests == nil {
+			return "", nil
+		}
+		for _, ri := range p.accessRequests.GetAccessRequestsByApp(req.GetApplicationName()) {
+			if ri != nil && ri.Metadata.ID == req.GetID() && importPending(ri.Attributes) {
+				return "", errors.New("The access is being imported from Webmethods")
+			}
+		}
+		return "", nil
+	}
+	appID := req.GetAccessRequestDetailsValue(common.AttrAppID)
+	if appID == "" || appID != req.GetApplicationDetailsValue(common.AttrAppID) {
+		return "", fmt.Errorf("Webmethods application %s is not the application of %s", appID, req.GetApplicationName())
+	}
+	if _, err := getApplication(p.client, appID); err != nil {
+		return "", err
+	}
+	return appID, nil
+}
+
+// markProvisioned sets the agent details and a successful status so the resource is not provisioned again. The
+// resource is removed when it can not be marked, it would not be trusted as imported and is imported again instead.
+func (i *Importer) markProvisioned(ri *v1.ResourceInstance, details map[string]interface{}) error {
+	status := prov.NewStatusReason(prov.NewRequestStatusBuilder().SetMessage(importedMessage).Success())
+	err := i.central.CreateSubResource(ri.ResourceMeta, map[string]interface{}{
+		defs.XAgentDetails: details,
+		"status":           status,
+	})
+	if err != nil {
+		if deleteErr := i.central.DeleteResourceInstance(ri); deleteErr != nil {
+			i.log.WithField("name", ri.Name).WithError(deleteErr).Error("unable to remove the resource that could not be marked imported")
+		}
+	}
+	return err
+}
+
+// repairStatus marks an imported resource provisioned again when the provisioner handled it before it was marked
+func (i *Importer) repairStatus(ri *v1.ResourceInstance, status *v1.ResourceStatus) {
+	if status != nil && status.Level == prov.Success.String() {
+		return
+	}
+	appID, _ := util.GetAgentDetailsValue(ri, common.AttrAppID)
+	status = prov.NewStatusReason(prov.NewRequestStatusBuilder().SetMessage(importedMessage).Success())
+	if err := i.central.CreateSubResource(ri.ResourceMeta, map[string]interface{}{"status": status}); err != nil {
+		i.log.WithField("name", ri.Name).WithField("appID", appID).WithError(err).Error("unable to mark the imported resource provisioned")
+	}
+}
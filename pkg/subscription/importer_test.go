@@ -0,0 +1,287 @@
+package subscription
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	coreapi "github.com/Axway/agent-sdk/pkg/api"
+	v1 "github.com/Axway/agent-sdk/pkg/apic/apiserver/models/api/v1"
+	management "github.com/Axway/agent-sdk/pkg/apic/apiserver/models/management/v1alpha1"
+	defs "github.com/Axway/agent-sdk/pkg/apic/definitions"
+	prov "github.com/Axway/agent-sdk/pkg/apic/provisioning"
+	"github.com/Axway/agent-sdk/pkg/apic/provisioning/mock"
+	"github.com/Axway/agents-webmethods/pkg/common"
+	"github.com/Axway/agents-webmethods/pkg/config"
+	"github.com/Axway/agents-webmethods/pkg/webmethods"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func (m *fakeCacheManager) GetAPIServiceInstanceKeys() []string {
+	keys := []string{}
+	for key := range m.instances {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (m *fakeCacheManager) GetAPIServiceInstanceByID(id string) (*v1.ResourceInstance, error) {
+	return m.instances[id], nil
+}
+
+type fakeImportClient struct {
+	created  map[string]*v1.ResourceInstance
+	details  map[string]map[string]interface{}
+	statuses map[string]*v1.ResourceStatus
+	deleted  []string
+	// failMark fails marking the resources provisioned
+	failMark bool
+}
+
+func newFakeImportClient() *fakeImportClient {
+	return &fakeImportClient{
+		created:  map[string]*v1.ResourceInstance{},
+		details:  map[string]map[string]interface{}{},
+		statuses: map[string]*v1.ResourceStatus{},
+	}
+}
+
+func (c *fakeImportClient) CreateResourceInstance(ri v1.Interface) (*v1.ResourceInstance, error) {
+	instance, err := ri.AsInstance()
+	if err != nil {
+		return nil, err
+	}
+	c.created[instance.Name] = instance
+	return instance, nil
+}
+
+func (c *fakeImportClient) CreateSubResource(rm v1.ResourceMeta, subs map[string]interface{}) error {
+	if c.failMark {
+		return errors.New("unavailable")
+	}
+	if details, ok := subs[defs.XAgentDetails]; ok {
+		c.details[rm.Name] = details.(map[string]interface{})
+	}
+	c.statuses[rm.Name] = subs["status"].(*v1.ResourceStatus)
+	return nil
+}
+
+func (c *fakeImportClient) DeleteResourceInstance(ri v1.Interface) error {
+	c.deleted = append(c.deleted, ri.GetName())
+	delete(c.created, ri.GetName())
+	return nil
+}
+
+func newTestImporter(t *testing.T, gateway *fakeGateway, cm *fakeCacheManager) (*Importer, *fakeImportClient) {
+	mc := &webmethods.MockClient{SendFunc: gateway.send}
+	client, err := webmethods.NewClient(&config.WebMethodConfig{}, mc)
+	assert.Nil(t, err)
+	central := newFakeImportClient()
+	return NewImporter(client, cm, central, "env", logrus.New()), central
+}
+
+func TestImportCreatesManagedApplicationAndAccessRequests(t *testing.T) {
+	gateway := newFakeGateway()
+	gateway.addApplication(webmethods.Application{Id: "1234-5678-90ab", Name: "Legacy Consumer", ConsumingAPIs: []string{"api-1", "api-2"}})
+	cm := &fakeCacheManager{
+		managedApps: map[string]*v1.ResourceInstance{},
+		instances: map[string]*v1.ResourceInstance{
+			"petstore": newServiceInstance("petstore", "api-1"),
+		},
+	}
+	i, central := newTestImporter(t, gateway, cm)
+
+	assert.Nil(t, i.Execute())
+	app := central.created["legacy-consumer-12345678"]
+	assert.NotNil(t, app)
+	assert.Equal(t, "Legacy Consumer", app.Title)
+	assert.Equal(t, "1234-5678-90ab", central.details[app.Name][common.AttrAppID])
+	assert.Equal(t, "true", central.details[app.Name][common.AttrImported])
+	assert.Equal(t, prov.Success.String(), central.statuses[app.Name].Level)
+	assert.Equal(t, importedAttributes("1234-5678-90ab"), app.Attributes)
+
+	// only the API discovered by the agent gets an access request
+	assert.Len(t, central.created, 2)
+	ri := central.created["legacy-consumer-12345678-petstore"]
+	assert.NotNil(t, ri)
+	ar := &management.AccessRequest{}
+	assert.Nil(t, ar.FromInstance(ri))
+	assert.Equal(t, app.Name, ar.Spec.ManagedApplication)
+	assert.Equal(t, "petstore", ar.Spec.ApiServiceInstance)
+	assert.Equal(t, "1234-5678-90ab", central.details[ar.Name][common.AttrAppID])
+	assert.Equal(t, "true", central.details[ar.Name][common.AttrImported])
+	assert.Equal(t, prov.Success.String(), central.statuses[ar.Name].Level)
+	assert.Equal(t, importedAttributes("1234-5678-90ab"), ar.Attributes)
+}
+
+func TestImportSkipsApplicationsKnownToCentral(t *testing.T) {
+	gateway := newFakeGateway()
+	gateway.addApplication(webmethods.Application{Id: "provisioned", Name: "consumer", ConsumingAPIs: []string{"api-1"}})
+	gateway.addApplication(webmethods.Application{Id: "orphan", Name: "orphan", Description: applicationDescriptionPrefix + "orphan"})
+	cm := &fakeCacheManager{
+		managedApps: map[string]*v1.ResourceInstance{
+			"consumer": newManagedApplicationInstance("consumer", "provisioned"),
+		},
+		instances: map[string]*v1.ResourceInstance{
+			"petstore": newServiceInstance("petstore", "api-1"),
+		},
+	}
+	i, central := newTestImporter(t, gateway, cm)
+
+	assert.Nil(t, i.Execute())
+	assert.Empty(t, central.created)
+}
+
+func TestImportAddsNewAccessesOfImportedApplications(t *testing.T) {
+	gateway := newFakeGateway()
+	gateway.addApplication(webmethods.Application{Id: "app", Name: "legacy", ConsumingAPIs: []string{"api-1", "api-2"}})
+	imported := management.NewManagedApplication("legacy-app", "env")
+	imported.SetSubResource(defs.XAgentDetails, map[string]interface{}{common.AttrAppID: "app", common.AttrImported: "true"})
+	importedInstance, _ := imported.AsInstance()
+	existing := management.NewAccessRequest("legacy-app-petstore", "env")
+	existing.Spec.ManagedApplication = "legacy-app"
+	existing.Spec.ApiServiceInstance = "petstore"
+	existingInstance, _ := existing.AsInstance()
+	cm := &fakeCacheManager{
+		managedApps:    map[string]*v1.ResourceInstance{"legacy-app": importedInstance},
+		accessRequests: []*v1.ResourceInstance{existingInstance},
+		instances: map[string]*v1.ResourceInstance{
+			"petstore": newServiceInstance("petstore", "api-1"),
+			"orders":   newServiceInstance("orders", "api-2"),
+		},
+	}
+	i, central := newTestImporter(t, gateway, cm)
+
+	assert.Nil(t, i.Execute())
+	assert.Len(t, central.created, 1)
+	assert.NotNil(t, central.created["legacy-app-orders"])
+}
+
+func TestImportRemovesResourceThatCouldNotBeMarked(t *testing.T) {
+	gateway := newFakeGateway()
+	gateway.addApplication(webmethods.Application{Id: "app", Name: "legacy", ConsumingAPIs: []string{"api-1"}})
+	cm := &fakeCacheManager{
+		managedApps: map[string]*v1.ResourceInstance{},
+		instances: map[string]*v1.ResourceInstance{
+			"petstore": newServiceInstance("petstore", "api-1"),
+		},
+	}
+	i, central := newTestImporter(t, gateway, cm)
+	central.failMark = true
+
+	assert.Nil(t, i.Execute())
+	assert.Equal(t, []string{"legacy-app"}, central.deleted)
+	assert.Empty(t, central.created)
+}
+
+func TestImportDoesNotTrustAttributes(t *testing.T) {
+	gateway := newFakeGateway()
+	gateway.addApplication(webmethods.Application{Id: "app", Name: "legacy", ConsumingAPIs: []string{"api-1"}})
+	// a consumer claims the webMethods application through the attributes of its managed application
+	forged := management.NewManagedApplication("mine", "env")
+	forged.Attributes = importedAttributes("app")
+	forgedInstance, _ := forged.AsInstance()
+	cm := &fakeCacheManager{
+		managedApps: map[string]*v1.ResourceInstance{"mine": forgedInstance},
+		instances: map[string]*v1.ResourceInstance{
+			"petstore": newServiceInstance("petstore", "api-1"),
+		},
+	}
+	i, central := newTestImporter(t, gateway, cm)
+
+	assert.Nil(t, i.Execute())
+	assert.Len(t, central.created, 2)
+	assert.NotNil(t, central.created["legacy-app"])
+	assert.NotNil(t, central.created["legacy-app-petstore"])
+}
+
+func TestImportRepairsStatusOfImportedApplication(t *testing.T) {
+	gateway := newFakeGateway()
+	gateway.addApplication(webmethods.Application{Id: "app", Name: "legacy"})
+	// the provisioner handled the managed application before it was marked provisioned
+	imported := management.NewManagedApplication("legacy-app", "env")
+	imported.SetSubResource(defs.XAgentDetails, map[string]interface{}{common.AttrAppID: "app", common.AttrImported: "true"})
+	imported.Status = &v1.ResourceStatus{Level: prov.Error.String()}
+	importedInstance, _ := imported.AsInstance()
+	cm := &fakeCacheManager{managedApps: map[string]*v1.ResourceInstance{"legacy-app": importedInstance}}
+	i, central := newTestImporter(t, gateway, cm)
+
+	assert.Nil(t, i.Execute())
+	assert.Empty(t, central.created)
+	assert.Equal(t, prov.Success.String(), central.statuses["legacy-app"].Level)
+}
+
+func TestProvisionerSkipsImportedResources(t *testing.T) {
+	gateway := newFakeGateway()
+	gateway.addApplication(webmethods.Application{Id: "app", Name: "legacy", ConsumingAPIs: []string{"api-1"}})
+	p := newTestProvisioner(t, gateway)
+	imported := map[string]string{common.AttrAppID: "app", common.AttrImported: "true"}
+
+	appReq := mock.MockApplicationRequest{AppName: "legacy-app", Details: imported}
+	status := p.ApplicationRequestProvision(appReq)
+	assert.Equal(t, prov.Success, status.GetStatus())
+	assert.Equal(t, "app", status.GetProperties()[common.AttrAppID])
+	assert.Equal(t, "true", status.GetProperties()[common.AttrImported])
+
+	accessReq := mock.MockAccessRequest{
+		ID:              "ar1",
+		AppName:         "legacy-app",
+		AppDetails:      map[string]string{common.AttrAppID: "app"},
+		Details:         imported,
+		InstanceDetails: map[string]interface{}{common.AttrAPIID: "api-1"},
+	}
+	status, _ = p.AccessRequestProvision(accessReq)
+	assert.Equal(t, prov.Success, status.GetStatus())
+	assert.Equal(t, "app", status.GetProperties()[common.AttrAppID])
+	assert.Len(t, gateway.applications, 1)
+
+	// the application and its accesses existed before the import, they are kept
+	assert.Equal(t, prov.Success, p.AccessRequestDeprovision(accessReq).GetStatus())
+	assert.Equal(t, prov.Success, p.ApplicationRequestDeprovision(appReq).GetStatus())
+	assert.Contains(t, gateway.applications, "app")
+	assert.Equal(t, []string{"api-1"}, gateway.applications["app"].ConsumingAPIs)
+	for _, call := range gateway.calls {
+		assert.True(t, strings.HasPrefix(call, coreapi.GET+" "), call)
+	}
+}
+
+func TestProvisionerVerifiesImportedResources(t *testing.T) {
+	gateway := newFakeGateway()
+	gateway.addApplication(webmethods.Application{Id: "app", Name: "legacy"})
+	app := management.NewManagedApplication("mine", "env")
+	app.Attributes = importedAttributes("app")
+	accessRequests := fakeAccessRequestCache{}
+	accessRequests.add("mine", "ar1", nil)
+	accessRequests["mine"][0].Attributes = importedAttributes("app")
+	p := newTestProvisioner(t, gateway)
+	WithApplicationMetadata(newFakeApplicationCache(app), nil)(p)
+	WithAccessRequestCache(accessRequests)(p)
+
+	// the attributes are not trusted, the resources are not provisioned until the importer marks them
+	status := p.ApplicationRequestProvision(mock.MockApplicationRequest{AppName: "mine"})
+	assert.Equal(t, prov.Error, status.GetStatus())
+	status, _ = p.AccessRequestProvision(mock.MockAccessRequest{
+		ID:              "ar1",
+		AppName:         "mine",
+		InstanceDetails: map[string]interface{}{common.AttrAPIID: "api-1"},
+	})
+	assert.Equal(t, prov.Error, status.GetStatus())
+	assert.Empty(t, gateway.calls)
+
+	// the webMethods application was not imported as this managed application
+	status = p.ApplicationRequestProvision(mock.MockApplicationRequest{
+		AppName: "mine",
+		Details: map[string]string{common.AttrAppID: "app", common.AttrImported: "true"},
+	})
+	assert.Equal(t, prov.Error, status.GetStatus())
+	status, _ = p.AccessRequestProvision(mock.MockAccessRequest{
+		ID:              "ar2",
+		AppName:         "mine",
+		AppDetails:      map[string]string{common.AttrAppID: "other"},
+		Details:         map[string]string{common.AttrAppID: "app", common.AttrImported: "true"},
+		InstanceDetails: map[string]interface{}{common.AttrAPIID: "api-1"},
+	})
+	assert.Equal(t, prov.Error, status.GetStatus())
+	assert.Len(t, gateway.applications, 1)
+}
@@ -0,0 +1,103 @@
+package subscription
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/Axway/agent-sdk/pkg/util/log"
+	"github.com/Axway/agents-webmethods/pkg/webmethods"
+)
+
+// applicationUpdateAttempts is the number of times a change is applied when the application keeps being modified
+const applicationUpdateAttempts = 3
+
+var (
+	errApplicationNotFound = errors.New("Webmethods application not found")
+	errApplicationConflict = errors.New("Webmethods application was modified concurrently")
+)
+
+// sharedLocks serializes the changes made by the provisioner, the reconciler and the application update handler to the
+// webMethods applications of each managed application
+var sharedLocks = newApplicationLocks()
+
+// applicationLocks serializes the provisioning requests of each managed application
+type applicationLocks struct {
+	mutex sync.Mutex
+	locks map[string]*applicationLock
+}
+
+type applicationLock struct {
+	sync.Mutex
+	users int
+}
+
+func newApplicationLocks() *applicationLocks {
+	return &applicationLocks{locks: map[string]*applicationLock{}}
+}
+
+// lock waits until no other request holds the application and returns the function releasing it
+func (l *applicationLocks) lock(name string) func() {
+	l.mutex.Lock()
+	lock, ok := l.locks[name]
+	if !ok {
+		lock = &applicationLock{}
+		l.locks[name] = lock
+	}
+	lock.users++
+	l.mutex.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+		lock.users--
+		if lock.users == 0 {
+			delete(l.locks, name)
+		}
+	}
+}
+
+// getApplication returns the webMethods application, errApplicationNotFound when it does not exist
+func getApplication(client webmethods.Client, webmethodsApplicationId string) (*webmethods.Application, error) {
+	applicationsResponse, err := client.GetApplication(webmethodsApplicationId)
+	if err != nil {
+		return nil, errors.New("Unable to get application from Webmethods")
+	}
+	if len(applicationsResponse.Applications) == 0 {
+		return nil, errApplicationNotFound
+	}
+	return &applicationsResponse.Applications[0], nil
+}
+
+// updateApplication applies the change to the current application and saves it when the change returns true. The
+// gateway has no conditional update, so the application is only saved when its last modification did not change since
+// it was read, and read again after it was saved to reapply the change when another client overwrote it in the
+// meantime. The change must return false once it is applied.
+func updateApplication(client webmethods.Client, webmethodsApplicationId string, change func(application *webmethods.Application) bool) (*webmethods.Application, error) {
+	for attempt := 1; ; attempt++ {
+		application, err := getApplication(client, webmethodsApplicationId)
+		if err != nil {
+			return nil, err
+		}
+		if !change(application) {
+			return application, nil
+		}
+		if attempt > applicationUpdateAttempts {
+			return nil, errApplicationConflict
+		}
+		if attempt > 1 {
+			log.Warnf("Application %s was modified while updating it, retrying", application.Name)
+		}
+		current, err := getApplication(client, webmethodsApplicationId)
+		if err != nil {
+			return nil, err
+		}
+		if current.LastModified != application.LastModified {
+			continue
+		}
+		if _, err := client.UpdateApplication(application); err != nil {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package subscription
+
+import (
+	"context"
+	"strings"
+
+	"github.com/Axway/agent-sdk/pkg/agent/handler"
+	v1 "github.com/Axway/agent-sdk/pkg/apic/apiserver/models/api/v1"
+	management "github.com/Axway/agent-sdk/pkg/apic/apiserver/models/management/v1alpha1"
+	defs "github.com/Axway/agent-sdk/pkg/apic/definitions"
+	"github.com/Axway/agent-sdk/pkg/util"
+	"github.com/Axway/agent-sdk/pkg/watchmanager/proto"
+	"github.com/Axway/agents-webmethods/pkg/common"
+	"github.com/Axway/agents-webmethods/pkg/webmethods"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	// DescriptionAttribute - managed application attribute holding the description of the webMethods application
+	DescriptionAttribute = "description"
+	// ContactEmailsAttribute - managed application attribute holding comma separated contact emails
+	ContactEmailsAttribute = "contactEmails"
+	// SiteURLsAttribute - managed application attribute holding comma separated site urls
+	SiteURLsAttribute = "siteURLs"
+)
+
+type applicationCache interface {
+	GetManagedApplicationByName(name string) *v1.ResourceInstance
+	GetTeamByID(id string) *defs.PlatformTeam
+}
+
+type userClient interface {
+	GetUserEmailAddress(id string) (string, error)
+}
+
+// applicationMetadata is the part of a webMethods application that is taken from the Central managed application
+type applicationMetadata struct {
+	description   string
+	owner         string
+	contactEmails []string
+	siteURLs      []string
+}
+
+// apply sets the metadata on the application and returns true when the application changed
+func (m applicationMetadata) apply(application *webmethods.Application) bool {
+	changed := application.Description != m.description ||
+		application.Owner != m.owner ||
+		!slices.Equal(application.ContactEmails, m.contactEmails) ||
+		!slices.Equal(application.SiteURLs, m.siteURLs)
+	application.Description = m.description
+	application.Owner = m.owner
+	application.ContactEmails = m.contactEmails
+	application.SiteURLs = m.siteURLs
+	return changed
+}
+
+// metadataReader reads the application metadata from Central managed applications
+type metadataReader struct {
+	cache applicationCache
+	users userClient
+	log   logrus.FieldLogger
+}
+
+// managedApplication returns the managed application with the given name, nil when it is not known
+func (r *metadataReader) managedApplication(name string) *management.ManagedApplication {
+	if r == nil || r.cache == nil {
+		return nil
+	}
+	ri := r.cache.GetManagedApplicationByName(name)
+	if ri == nil {
+		return nil
+	}
+	app := &management.ManagedApplication{}
+	if err := app.FromInstance(ri); err != nil {
+		return nil
+	}
+	return app
+}
+
+// forName returns the metadata of the managed application with the given name. Only the name based description is
+// returned when the managed application is not known.
+func (r *metadataReader) forName(name string) applicationMetadata {
+	if app := r.managedApplication(name); app != nil {
+		return r.read(app)
+	}
+	return applicationMetadata{
+		description:   applicationDescriptionPrefix + name,
+		contactEmails: []string{},
+		siteURLs:      []string{},
+	}
+}
+
+// read builds the metadata of the managed application
+func (r *metadataReader) read(app *management.ManagedApplication) applicationMetadata {
+	description := app.Attributes[DescriptionAttribute]
+	if description == "" {
+		description = app.Title
+	}
+	if description == "" {
+		description = app.Name
+	}
+	metadata := applicationMetadata{
+		description:   applicationDescriptionPrefix + description,
+		owner:         r.teamName(app.Owner),
+		contactEmails: splitAttribute(app.Attributes[ContactEmailsAttribute]),
+		siteURLs:      splitAttribute(app.Attributes[SiteURLsAttribute]),
+	}
+	if email := r.creatorEmail(app); email != "" && !slices.Contains(metadata.contactEmails, email) {
+		metadata.contactEmails = append(metadata.contactEmails, email)
+	}
+	return metadata
+}
+
+func (r *metadataReader) teamName(owner *v1.Owner) string {
+	if r == nil || owner == nil || owner.Type != v1.TeamOwner || owner.ID == "" || r.cache == nil {
+		return ""
+	}
+	if team := r.cache.GetTeamByID(owner.ID); team != nil {
+		return team.Name
+	}
+	return ""
+}
+
+func (r *metadataReader) creatorEmail(app *management.ManagedApplication) string {
+	userID := app.Marketplace.Resource.Metadata.CreateUserId
+	if userID == "" {
+		userID = app.Metadata.Audit.CreateUserID
+	}
+	if userID == "" || r.users == nil {
+		return ""
+	}
+	email, err := r.users.GetUserEmailAddress(userID)
+	if err != nil {
+		r.log.WithError(err).WithField("userID", userID).Warn("unable to get the email address of the application creator")
+		return ""
+	}
+	return email
+}
+
+func splitAttribute(value string) []string {
+	values := []string{}
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// ApplicationUpdateHandler implements the handler.Handler interface. Pushes the metadata of a provisioned managed
+// application to its webMethods application whenever the managed application is modified in Central.
+type ApplicationUpdateHandler struct {
+	client   webmethods.Client
+	metadata *metadataReader
+	locks    *applicationLocks
+	log      logrus.FieldLogger
+}
+
+// NewApplicationUpdateHandler creates the handler for managed application updates
+func NewApplicationUpdateHandler(client webmethods.Client, cache applicationCache, users userClient, log logrus.FieldLogger) *ApplicationUpdateHandler {
+	log = log.WithField("component", "application-update")
+	return &ApplicationUpdateHandler{
+		client:   client,
+		metadata: &metadataReader{cache: cache, users: users, log: log},
+		locks:    sharedLocks,
+		log:      log,
+	}
+}
+
+// Handle receives the managed application events
+func (h *ApplicationUpdateHandler) Handle(ctx context.Context, _ *proto.EventMeta, resource *v1.ResourceInstance) error {
+	if resource == nil || resource.Kind != management.ManagedApplicationGVK().Kind || handler.GetActionFromContext(ctx) != proto.Event_UPDATED {
+		return nil
+	}
+	app := &management.ManagedApplication{}
+	if err := app.FromInstance(resource); err != nil || !isProvisioned(app.Status) || app.Metadata.State == v1.ResourceDeleting {
+		return nil
+	}
+	appID, _ := util.GetAgentDetailsValue(app, common.AttrAppID)
+	if appID == "" {
+		return nil
+	}
+	logger := h.log.WithField("managedApplication", app.Name).WithField("appID", appID)
+	defer h.locks.lock(app.Name)()
+
+	metadata := h.metadata.read(app)
+	changed := false
+	_, err := updateApplication(h.client, appID, func(application *webmethods.Application) bool {
+		if !metadata.apply(application) {
+			return false
+		}
+		changed = true
+		return true
+	})
+	if err != nil {
+		logger.WithError(err).Error("unable to update application metadata on Webmethods")
+		return nil
+	}
+	if changed {
+		logger.Info("updated application metadata")
+	}
+	return nil
+}
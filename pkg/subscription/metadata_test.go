@@ -0,0 +1,125 @@
+package subscription
+
+import (
+	"testing"
+
+	"github.com/Axway/agent-sdk/pkg/agent/handler"
+	v1 "github.com/Axway/agent-sdk/pkg/apic/apiserver/models/api/v1"
+	management "github.com/Axway/agent-sdk/pkg/apic/apiserver/models/management/v1alpha1"
+	defs "github.com/Axway/agent-sdk/pkg/apic/definitions"
+	prov "github.com/Axway/agent-sdk/pkg/apic/provisioning"
+	"github.com/Axway/agent-sdk/pkg/apic/provisioning/mock"
+	"github.com/Axway/agent-sdk/pkg/util"
+	"github.com/Axway/agent-sdk/pkg/watchmanager/proto"
+	"github.com/Axway/agents-webmethods/pkg/common"
+	"github.com/Axway/agents-webmethods/pkg/config"
+	"github.com/Axway/agents-webmethods/pkg/webmethods"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeApplicationCache struct {
+	apps  map[string]*v1.ResourceInstance
+	teams map[string]*defs.PlatformTeam
+}
+
+func (c *fakeApplicationCache) GetManagedApplicationByName(name string) *v1.ResourceInstance {
+	return c.apps[name]
+}
+
+func (c *fakeApplicationCache) GetTeamByID(id string) *defs.PlatformTeam {
+	return c.teams[id]
+}
+
+type fakeUserClient map[string]string
+
+func (c fakeUserClient) GetUserEmailAddress(id string) (string, error) {
+	return c[id], nil
+}
+
+func newDescribedManagedApplication(name, appID string) *management.ManagedApplication {
+	app := management.NewManagedApplication(name, "env")
+	app.Title = "Consumer Portal"
+	app.Attributes = map[string]string{
+		ContactEmailsAttribute: "ops@example.com, dev@example.com",
+		SiteURLsAttribute:      "https://portal.example.com",
+	}
+	app.Owner = &v1.Owner{Type: v1.TeamOwner, ID: "team-id"}
+	app.Metadata.Audit.CreateUserID = "user-id"
+	app.Status = &v1.ResourceStatus{Level: prov.Success.String()}
+	if appID != "" {
+		util.SetAgentDetails(app, map[string]interface{}{common.AttrAppID: appID})
+	}
+	return app
+}
+
+func newFakeApplicationCache(apps ...*management.ManagedApplication) *fakeApplicationCache {
+	cache := &fakeApplicationCache{
+		apps:  map[string]*v1.ResourceInstance{},
+		teams: map[string]*defs.PlatformTeam{"team-id": {ID: "team-id", Name: "Consumers"}},
+	}
+	for _, app := range apps {
+		cache.apps[app.Name], _ = app.AsInstance()
+	}
+	return cache
+}
+
+func TestApplicationRequestProvisionSetsMetadata(t *testing.T) {
+	gateway := newFakeGateway()
+	mc := &webmethods.MockClient{SendFunc: gateway.send}
+	client, err := webmethods.NewClient(&config.WebMethodConfig{}, mc)
+	assert.Nil(t, err)
+	cache := newFakeApplicationCache(newDescribedManagedApplication("consumer", ""))
+	users := fakeUserClient{"user-id": "owner@example.com"}
+	p := NewProvisioner(client, &config.WebMethodConfig{}, logrus.New(), WithApplicationMetadata(cache, users))
+
+	status := p.ApplicationRequestProvision(mock.MockApplicationRequest{AppName: "consumer"})
+
+	assert.Equal(t, prov.Success, status.GetStatus())
+	application := gateway.applications["app-1"]
+	assert.Equal(t, "consumer", application.Name)
+	assert.Equal(t, applicationDescriptionPrefix+"Consumer Portal", application.Description)
+	assert.Equal(t, "Consumers", application.Owner)
+	assert.Equal(t, []string{"ops@example.com", "dev@example.com", "owner@example.com"}, application.ContactEmails)
+	assert.Equal(t, []string{"https://portal.example.com"}, application.SiteURLs)
+}
+
+func TestApplicationRequestProvisionWithoutMetadata(t *testing.T) {
+	gateway := newFakeGateway()
+	p := newTestProvisioner(t, gateway)
+
+	status := p.ApplicationRequestProvision(mock.MockApplicationRequest{AppName: "consumer"})
+
+	assert.Equal(t, prov.Success, status.GetStatus())
+	assert.Equal(t, applicationDescriptionPrefix+"consumer", gateway.applications["app-1"].Description)
+}
+
+func TestApplicationUpdateHandler(t *testing.T) {
+	gateway := newFakeGateway()
+	gateway.addApplication(webmethods.Application{Id: "app", Name: "consumer", Description: applicationDescriptionPrefix + "consumer"})
+	mc := &webmethods.MockClient{SendFunc: gateway.send}
+	client, err := webmethods.NewClient(&config.WebMethodConfig{}, mc)
+	assert.Nil(t, err)
+	h := NewApplicationUpdateHandler(client, newFakeApplicationCache(), fakeUserClient{}, logrus.New())
+
+	app := newDescribedManagedApplication("consumer", "app")
+	app.Attributes[DescriptionAttribute] = "Portal for consumers"
+	ri, _ := app.AsInstance()
+
+	// sub resource updates are ignored
+	ctx := handler.NewEventContext(proto.Event_SUBRESOURCEUPDATED, nil, ri.Kind, ri.Name)
+	assert.Nil(t, h.Handle(ctx, nil, ri))
+	assert.Equal(t, applicationDescriptionPrefix+"consumer", gateway.applications["app"].Description)
+
+	ctx = handler.NewEventContext(proto.Event_UPDATED, nil, ri.Kind, ri.Name)
+	assert.Nil(t, h.Handle(ctx, nil, ri))
+	application := gateway.applications["app"]
+	assert.Equal(t, applicationDescriptionPrefix+"Portal for consumers", application.Description)
+	assert.Equal(t, "Consumers", application.Owner)
+	assert.Equal(t, []string{"ops@example.com", "dev@example.com"}, application.ContactEmails)
+
+	// nothing changed, no update sent
+	calls := len(gateway.calls)
+	assert.Nil(t, h.Handle(ctx, nil, ri))
+	assert.Len(t, gateway.calls, calls+1)
+}
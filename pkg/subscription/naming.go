@@ -0,0 +1,73 @@
+package subscription
+
+import (
+	"bytes"
+	"strings"
+	"text/template"
+)
+
+const (
+	// DefaultApplicationNameTemplate names the webMethods application after the managed application
+	DefaultApplicationNameTemplate = "{{.App}}"
+
+	idSuffixLength = 8
+)
+
+// applicationNameData is the data available to the application name template
+type applicationNameData struct {
+	// Team is the name of the team owning the managed application
+	Team string
+	// App is the name of the managed application
+	App string
+	// Environment is the name of the Central environment of the agent
+	Environment string
+	// ID is a short suffix taken from the id of the managed application, or of the access request for dedicated applications
+	ID string
+}
+
+// applicationNaming builds the names of the webMethods applications created by the provisioner
+type applicationNaming struct {
+	tmpl        *template.Template
+	environment string
+}
+
+func newApplicationNaming(text, environment string) (*applicationNaming, error) {
+	if text == "" {
+		text = DefaultApplicationNameTemplate
+	}
+	tmpl, err := template.New("applicationName").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return &applicationNaming{tmpl: tmpl, environment: environment}, nil
+}
+
+// name returns the webMethods application name, falling back to the managed application name when the template fails
+func (n *applicationNaming) name(app, team, id string) string {
+	if n == nil {
+		return app
+	}
+	data := applicationNameData{
+		Team:        team,
+		App:         app,
+		Environment: n.environment,
+		ID:          shortID(id),
+	}
+	buf := &bytes.Buffer{}
+	if err := n.tmpl.Execute(buf, data); err != nil {
+		return app
+	}
+	name := strings.TrimSpace(buf.String())
+	if name == "" {
+		return app
+	}
+	return name
+}
+
+func shortID(id string) string {
+	id = strings.ReplaceAll(id, "-", "")
+	if len(id) > idSuffixLength {
+		return id[:idSuffixLength]
+	}
+	return id
+}
@@ -0,0 +1,115 @@
+package subscription
+
+import (
+	"testing"
+
+	prov "github.com/Axway/agent-sdk/pkg/apic/provisioning"
+	"github.com/Axway/agent-sdk/pkg/apic/provisioning/mock"
+	"github.com/Axway/agents-webmethods/pkg/common"
+	"github.com/Axway/agents-webmethods/pkg/config"
+	"github.com/Axway/agents-webmethods/pkg/webmethods"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplicationNaming(t *testing.T) {
+	tests := map[string]struct {
+		template string
+		expected string
+	}{
+		"default":       {template: "", expected: "consumer"},
+		"all fields":    {template: "{{.Team}}-{{.App}}-{{.Environment}}-{{.ID}}", expected: "Consumers-consumer-env-0f3a9c21"},
+		"unknown field": {template: "{{.Unknown}}", expected: "consumer"},
+		"empty result":  {template: "{{if false}}x{{end}}", expected: "consumer"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			naming, err := newApplicationNaming(tc.template, "env")
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, naming.name("consumer", "Consumers", "0f3a9c21-77aa-4e0c-9d2b-5f6e7a8b9c0d"))
+		})
+	}
+
+	_, err := newApplicationNaming("{{.App", "env")
+	assert.NotNil(t, err)
+}
+
+func newNamingProvisioner(t *testing.T, gateway *fakeGateway, cfg *config.WebMethodConfig) *provisioner {
+	mc := &webmethods.MockClient{SendFunc: gateway.send}
+	client, err := webmethods.NewClient(&config.WebMethodConfig{}, mc)
+	assert.Nil(t, err)
+	return NewProvisioner(client, cfg, logrus.New(), WithEnvironment("env")).(*provisioner)
+}
+
+func TestApplicationRequestProvisionUsesNameTemplate(t *testing.T) {
+	gateway := newFakeGateway()
+	gateway.addApplication(webmethods.Application{Id: "admin", Name: "consumer"})
+	p := newNamingProvisioner(t, gateway, &config.WebMethodConfig{AppNameTemplate: "{{.Team}}-{{.App}}-{{.Environment}}"})
+
+	status := p.ApplicationRequestProvision(mock.MockApplicationRequest{AppName: "consumer", TeamName: "Consumers"})
+
+	assert.Equal(t, prov.Success, status.GetStatus())
+	assert.Equal(t, "app-1", status.GetProperties()[common.AttrAppID])
+	assert.Equal(t, "Consumers-consumer-env", gateway.applications["app-1"].Name)
+}
+
+func TestAccessRequestProvisionNamesApplicationLikeApplicationRequest(t *testing.T) {
+	app := newDescribedManagedApplication("consumer", "")
+	app.Metadata.ID = "0f3a9c21-77aa"
+	cfg := &config.WebMethodConfig{AppNameTemplate: "{{.Team}}-{{.App}}-{{.ID}}"}
+
+	gateway := newFakeGateway()
+	p := newNamingProvisioner(t, gateway, cfg)
+	WithApplicationMetadata(newFakeApplicationCache(app), nil)(p)
+	status := p.ApplicationRequestProvision(mock.MockApplicationRequest{ID: app.Metadata.ID, AppName: "consumer", TeamName: "Consumers"})
+	assert.Equal(t, prov.Success, status.GetStatus())
+
+	// the managed application has no webMethods application yet when its first access request is provisioned
+	arGateway := newFakeGateway()
+	p = newNamingProvisioner(t, arGateway, cfg)
+	WithApplicationMetadata(newFakeApplicationCache(app), nil)(p)
+	status, _ = p.AccessRequestProvision(mock.MockAccessRequest{
+		AppName:         "consumer",
+		InstanceDetails: map[string]interface{}{common.AttrAPIID: "api-1"},
+	})
+	assert.Equal(t, prov.Success, status.GetStatus())
+
+	assert.Equal(t, "Consumers-consumer-0f3a9c21", gateway.applications["app-1"].Name)
+	assert.Equal(t, gateway.applications["app-1"].Name, arGateway.applications["app-1"].Name)
+}
+
+func TestAccessRequestProvisionDedicatedApplication(t *testing.T) {
+	gateway := newFakeGateway()
+	gateway.addApplication(webmethods.Application{Id: "shared", Name: "consumer"})
+	p := newNamingProvisioner(t, gateway, &config.WebMethodConfig{AppPerAccessRequest: true})
+
+	appIDs := []string{}
+	for _, id := range []string{"11111111-aaaa", "22222222-bbbb"} {
+		status, data := p.AccessRequestProvision(mock.MockAccessRequest{
+			ID:              id,
+			AppName:         "consumer",
+			AppDetails:      map[string]string{common.AttrAppID: "shared"},
+			InstanceDetails: map[string]interface{}{common.AttrAPIID: "api-1"},
+		})
+		assert.Equal(t, prov.Success, status.GetStatus())
+		appID := status.GetProperties()[common.AttrAppID]
+		assert.NotEqual(t, "shared", appID)
+		assert.Equal(t, "true", status.GetProperties()[common.AttrDedicatedApp])
+		assert.Equal(t, appID, data.GetData()[ApplicationIDField])
+		assert.Equal(t, appID+"-key", data.GetData()[prov.APIKey])
+		assert.Equal(t, []string{"api-1"}, gateway.applications[appID].ConsumingAPIs)
+		appIDs = append(appIDs, appID)
+	}
+	assert.Equal(t, "consumer-11111111", gateway.applications[appIDs[0]].Name)
+	assert.Equal(t, "consumer-22222222", gateway.applications[appIDs[1]].Name)
+	assert.Empty(t, gateway.applications["shared"].ConsumingAPIs)
+
+	status := p.AccessRequestDeprovision(mock.MockAccessRequest{
+		AppName:         "consumer",
+		Details:         map[string]string{common.AttrAppID: appIDs[0], common.AttrDedicatedApp: "true"},
+		InstanceDetails: map[string]interface{}{common.AttrAPIID: "api-1"},
+	})
+	assert.Equal(t, prov.Success, status.GetStatus())
+	assert.NotContains(t, gateway.applications, appIDs[0])
+	assert.Contains(t, gateway.applications, appIDs[1])
+}
@@ -0,0 +1,133 @@
+package subscription
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	v1 "github.com/Axway/agent-sdk/pkg/apic/apiserver/models/api/v1"
+	"github.com/Axway/agents-webmethods/pkg/common"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	// GrantTypesField - oauth credential field holding the grant types allowed for the client
+	GrantTypesField = "grantTypes"
+)
+
+// defaultGrantTypes are allowed for the oauth clients when the credential request does not select any
+var defaultGrantTypes = []string{
+	"authorization_code",
+	"password",
+	"client_credentials",
+	"refresh_token",
+	"implicit",
+}
+
+type credentialDefinitionCache interface {
+	ListCredentialRequestDefinitions() []*v1.ResourceInstance
+	DeleteCredentialRequestDefinition(id string) error
+}
+
+type definitionClient interface {
+	DeleteResourceInstance(ri v1.Interface) error
+}
+
+// OAuth2CredentialTypes returns the oauth credential request definition names of the authorization server aliases, in
+// the same order. The name only depends on the alias so reordering the aliases does not rename the definitions.
+func OAuth2CredentialTypes(aliases []string) []string {
+	types := []string{}
+	for _, alias := range aliases {
+		types = append(types, OAuth2AuthType+"-"+common.CentralName(alias))
+	}
+	return types
+}
+
+// RemoveOAuth2CredentialTypes deletes the oauth credential request definitions of the authorization server aliases
+// that are no longer configured, so the APIs published again do not offer them. The oauth2 definition registered
+// before multiple aliases were supported is kept for the credentials created with it.
+func RemoveOAuth2CredentialTypes(cache credentialDefinitionCache, client definitionClient, aliases []string, log logrus.FieldLogger) {
+	configured := OAuth2CredentialTypes(aliases)
+	for _, crd := range cache.ListCredentialRequestDefinitions() {
+		if !strings.HasPrefix(crd.Name, OAuth2AuthType+"-") || slices.Contains(configured, crd.Name) {
+			continue
+		}
+		logger := log.WithField("credentialRequestDefinition", crd.Name)
+		if err := client.DeleteResourceInstance(crd); err != nil {
+			logger.WithError(err).Warn("unable to remove the oauth credential request definition of a removed authorization server")
+			continue
+		}
+		if err := cache.DeleteCredentialRequestDefinition(crd.Metadata.ID); err != nil {
+			logger.WithError(err).Debug("the oauth credential request definition was not cached")
+		}
+		logger.Info("removed the oauth credential request definition of a removed authorization server")
+	}
+}
+
+// OAuth2GrantTypes returns the grant types offered for the clients of an authorization server, all the grant types
+// handled by the agent when the server does not report the ones it supports
+func OAuth2GrantTypes(supported []string) []string {
+	if len(supported) == 0 {
+		return defaultGrantTypes
+	}
+	return supported
+}
+
+// OauthServers tracks the authorization server aliases registered as oauth credential request definitions. The
+// registrations are replaced when the agent config changes.
+type OauthServers struct {
+	mutex   sync.RWMutex
+	aliases map[string]string
+}
+
+// NewOauthServers creates an empty set of oauth credential request definitions
+func NewOauthServers() *OauthServers {
+	return &OauthServers{aliases: map[string]string{}}
+}
+
+// Set replaces the registered oauth credential request definitions, keyed by name with their authorization server alias
+func (s *OauthServers) Set(aliases map[string]string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.aliases = map[string]string{}
+	for credentialType, alias := range aliases {
+		s.aliases[credentialType] = alias
+	}
+}
+
+// CredentialTypes returns the names of the registered oauth credential request definitions
+func (s *OauthServers) CredentialTypes() []string {
+	if s == nil {
+		return []string{}
+	}
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	types := []string{}
+	for credentialType := range s.aliases {
+		types = append(types, credentialType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// alias returns the authorization server alias of the oauth credential request definition
+func (s *OauthServers) alias(credentialType string) (string, bool) {
+	if s == nil {
+		return "", false
+	}
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	alias, ok := s.aliases[credentialType]
+	return alias, ok
+}
+
+// credentialKind returns OAuth2AuthType for the oauth credential request definitions, named oauth2 or oauth2-<alias>,
+// the credential type otherwise. The kind does not depend on the registered aliases so the credentials of a removed
+// authorization server can still be updated and deprovisioned.
+func credentialKind(credentialType string) string {
+	if credentialType == OAuth2AuthType || strings.HasPrefix(credentialType, OAuth2AuthType+"-") {
+		return OAuth2AuthType
+	}
+	return credentialType
+}
@@ -0,0 +1,166 @@
+package subscription
+
+import (
+	"testing"
+
+	v1 "github.com/Axway/agent-sdk/pkg/apic/apiserver/models/api/v1"
+	prov "github.com/Axway/agent-sdk/pkg/apic/provisioning"
+	"github.com/Axway/agent-sdk/pkg/apic/provisioning/mock"
+	"github.com/Axway/agents-webmethods/pkg/common"
+	"github.com/Axway/agents-webmethods/pkg/config"
+	"github.com/Axway/agents-webmethods/pkg/webmethods"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOAuth2CredentialTypes(t *testing.T) {
+	assert.Equal(t, []string{}, OAuth2CredentialTypes(nil))
+	assert.Equal(t,
+		[]string{"oauth2-local", "oauth2-okta", "oauth2-keycloak-internal"},
+		OAuth2CredentialTypes([]string{"local", "Okta", "Keycloak Internal"}))
+}
+
+func newTestOauthServers() *OauthServers {
+	servers := NewOauthServers()
+	servers.Set(map[string]string{"oauth2-local": "local", "oauth2-okta": "okta"})
+	return servers
+}
+
+func TestOauthServers(t *testing.T) {
+	servers := newTestOauthServers()
+	assert.Equal(t, []string{"oauth2-local", "oauth2-okta"}, servers.CredentialTypes())
+
+	servers.Set(map[string]string{})
+	assert.Equal(t, []string{}, servers.CredentialTypes())
+	_, ok := servers.alias("oauth2-okta")
+	assert.False(t, ok)
+
+	var none *OauthServers
+	assert.Equal(t, []string{}, none.CredentialTypes())
+}
+
+func TestCredentialProvisionUsesCredentialDefinitionAlias(t *testing.T) {
+	gateway := newFakeGateway()
+	gateway.addApplication(webmethods.Application{Id: "app", Name: "consumer"})
+	mc := &webmethods.MockClient{SendFunc: gateway.send}
+	client, err := webmethods.NewClient(&config.WebMethodConfig{}, mc)
+	assert.Nil(t, err)
+	p := NewProvisioner(client, &config.WebMethodConfig{}, logrus.New(), WithOauthServers(newTestOauthServers()))
+
+	status, credential := p.CredentialProvision(mock.MockCredentialRequest{
+		AppName:     "consumer",
+		AppDetails:  map[string]string{common.AttrAppID: "app"},
+		CredDefName: "oauth2-okta",
+		CredData: map[string]interface{}{
+			OauthServerField: "local",
+			GrantTypesField:  []interface{}{"client_credentials"},
+		},
+	})
+
+	assert.Equal(t, prov.Success, status.GetStatus())
+	assert.NotNil(t, credential)
+	strategy := gateway.strategies["strategy-1"]
+	assert.Equal(t, "okta", strategy.AuthServerAlias)
+	assert.Equal(t, "okta", strategy.DcrConfig.AuthServer)
+	assert.Equal(t, []string{"client_credentials"}, strategy.DcrConfig.AllowedGrantTypes)
+	assert.Equal(t, []string{"strategy-1"}, gateway.applications["app"].AuthStrategyIds)
+}
+
+func TestCredentialUpdateHandlesAliasCredentialDefinition(t *testing.T) {
+	gateway := newFakeGateway()
+	gateway.strategies["strategy"] = &webmethods.Strategy{
+		Id:                 "strategy",
+		ClientRegistration: webmethods.ClientRegistration{ClientId: "client", Enabled: true},
+	}
+	gateway.addApplication(webmethods.Application{Id: "app", Name: "consumer", AuthStrategyIds: []string{"strategy"}})
+	mc := &webmethods.MockClient{SendFunc: gateway.send}
+	client, err := webmethods.NewClient(&config.WebMethodConfig{}, mc)
+	assert.Nil(t, err)
+	p := NewProvisioner(client, &config.WebMethodConfig{}, logrus.New(), WithOauthServers(newTestOauthServers()))
+
+	status, _ := p.CredentialUpdate(mock.MockCredentialRequest{
+		AppName:     "consumer",
+		AppDetails:  map[string]string{common.AttrAppID: "app"},
+		CredDefName: "oauth2-okta",
+		Action:      prov.Suspend,
+	})
+
+	assert.Equal(t, prov.Success, status.GetStatus())
+	assert.False(t, gateway.strategies["strategy"].ClientRegistration.Enabled)
+}
+
+func TestCredentialsOfRemovedAliasAreStillManaged(t *testing.T) {
+	gateway := newFakeGateway()
+	gateway.strategies["strategy"] = &webmethods.Strategy{
+		Id:                 "strategy",
+		ClientRegistration: webmethods.ClientRegistration{ClientId: "client", Enabled: true},
+	}
+	gateway.addApplication(webmethods.Application{Id: "app", Name: "consumer", AuthStrategyIds: []string{"strategy"}})
+	mc := &webmethods.MockClient{SendFunc: gateway.send}
+	client, err := webmethods.NewClient(&config.WebMethodConfig{}, mc)
+	assert.Nil(t, err)
+	// the okta authorization server is no longer configured
+	servers := NewOauthServers()
+	servers.Set(map[string]string{"oauth2-local": "local"})
+	p := NewProvisioner(client, &config.WebMethodConfig{}, logrus.New(), WithOauthServers(servers))
+	req := mock.MockCredentialRequest{
+		AppName:     "consumer",
+		AppDetails:  map[string]string{common.AttrAppID: "app"},
+		Details:     map[string]string{common.AttrAppID: "app", common.AttrStrategyID: "strategy"},
+		CredDefName: "oauth2-okta",
+		Action:      prov.Suspend,
+	}
+
+	status, _ := p.CredentialUpdate(req)
+	assert.Equal(t, prov.Success, status.GetStatus())
+	assert.False(t, gateway.strategies["strategy"].ClientRegistration.Enabled)
+
+	assert.Equal(t, prov.Success, p.CredentialDeprovision(req).GetStatus())
+	assert.Empty(t, gateway.strategies)
+	assert.Empty(t, gateway.applications["app"].AuthStrategyIds)
+}
+
+func TestCredentialKind(t *testing.T) {
+	assert.Equal(t, OAuth2AuthType, credentialKind(OAuth2AuthType))
+	assert.Equal(t, OAuth2AuthType, credentialKind("oauth2-okta"))
+	assert.Equal(t, prov.APIKeyCRD, credentialKind(prov.APIKeyCRD))
+	assert.Equal(t, "oauth2okta", credentialKind("oauth2okta"))
+}
+
+type fakeDefinitions struct {
+	definitions map[string]*v1.ResourceInstance
+	deleted     []string
+}
+
+func (f *fakeDefinitions) ListCredentialRequestDefinitions() []*v1.ResourceInstance {
+	definitions := []*v1.ResourceInstance{}
+	for _, definition := range f.definitions {
+		definitions = append(definitions, definition)
+	}
+	return definitions
+}
+
+func (f *fakeDefinitions) DeleteCredentialRequestDefinition(id string) error {
+	delete(f.definitions, id)
+	return nil
+}
+
+func (f *fakeDefinitions) DeleteResourceInstance(ri v1.Interface) error {
+	f.deleted = append(f.deleted, ri.GetName())
+	return nil
+}
+
+func TestRemoveOAuth2CredentialTypes(t *testing.T) {
+	definitions := &fakeDefinitions{definitions: map[string]*v1.ResourceInstance{}}
+	for _, name := range []string{OAuth2AuthType, "oauth2-okta", "oauth2-old", prov.APIKeyCRD} {
+		definition := &v1.ResourceInstance{}
+		definition.Name = name
+		definition.Metadata.ID = name
+		definitions.definitions[name] = definition
+	}
+
+	RemoveOAuth2CredentialTypes(definitions, definitions, []string{"Okta"}, logrus.New())
+	assert.Equal(t, []string{"oauth2-old"}, definitions.deleted)
+	assert.Len(t, definitions.definitions, 3)
+	assert.NotContains(t, definitions.definitions, "oauth2-old")
+}
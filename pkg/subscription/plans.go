@@ -0,0 +1,101 @@
+package subscription
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Axway/agents-webmethods/pkg/webmethods"
+	"golang.org/x/exp/slices"
+)
+
+// planSubscription is the subscription of an application to a webMethods package plan
+type planSubscription struct {
+	id        string
+	packageId string
+	planId    string
+}
+
+// findPackagePlan returns the ids of the active package exposing the API and of its plan with the given name
+func (p provisioner) findPackagePlan(apiID, planName string) (string, string, error) {
+	planResponse, err := p.client.ListPlans()
+	if err != nil {
+		return "", "", errors.New("Unable to list plans from Webmethods")
+	}
+	planIds := []string{}
+	for _, plan := range planResponse.Plans {
+		if plan.Name == planName || plan.Id == planName {
+			planIds = append(planIds, plan.Id)
+		}
+	}
+	if len(planIds) == 0 {
+		return "", "", fmt.Errorf("plan %s not found on Webmethods", planName)
+	}
+
+	packageResponse, err := p.client.ListPackages()
+	if err != nil {
+		return "", "", errors.New("Unable to list packages from Webmethods")
+	}
+	for _, pkg := range packageResponse.Packages {
+		if !pkg.Active || !slices.Contains(pkg.Apis, apiID) {
+			continue
+		}
+		for _, planId := range planIds {
+			if slices.Contains(pkg.Plans, planId) {
+				return pkg.Id, planId, nil
+			}
+		}
+	}
+	return "", "", fmt.Errorf("no active Webmethods package offers plan %s for the API", planName)
+}
+
+// subscribePlan subscribes the application to the plan, or moves the current subscription to it on upgrades and downgrades
+func (p provisioner) subscribePlan(rb *rollback, applicationId, apiID, planName string, current planSubscription) (planSubscription, error) {
+	packageId, planId, err := p.findPackagePlan(apiID, planName)
+	if err != nil {
+		return planSubscription{}, err
+	}
+	subscription := planSubscription{id: current.id, packageId: packageId, planId: planId}
+
+	if current.id == "" {
+		subscriptionResponse, err := p.client.CreateSubscription(&webmethods.Subscription{
+			ApplicationId: applicationId,
+			PackageId:     packageId,
+			PlanId:        planId,
+		})
+		if err != nil {
+			return planSubscription{}, errors.New("Unable to subscribe the Webmethods Application to the plan")
+		}
+		subscription.id = subscriptionResponse.Subscription.Id
+		rb.add("create subscription", func() error {
+			return p.client.DeleteSubscription(subscription.id)
+		})
+		return subscription, nil
+	}
+
+	if current.packageId == packageId && current.planId == planId {
+		return subscription, nil
+	}
+	p.log.
+		WithField("fromPlan", current.planId).
+		WithField("toPlan", planId).
+		Info("changing plan of the subscription")
+	_, err = p.client.UpdateSubscription(&webmethods.Subscription{
+		Id:            current.id,
+		ApplicationId: applicationId,
+		PackageId:     packageId,
+		PlanId:        planId,
+	})
+	if err != nil {
+		return planSubscription{}, errors.New("Unable to change the plan of the Webmethods subscription")
+	}
+	rb.add("update subscription", func() error {
+		_, err := p.client.UpdateSubscription(&webmethods.Subscription{
+			Id:            current.id,
+			ApplicationId: applicationId,
+			PackageId:     current.packageId,
+			PlanId:        current.planId,
+		})
+		return err
+	})
+	return subscription, nil
+}
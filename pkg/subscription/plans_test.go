@@ -0,0 +1,109 @@
+package subscription
+
+import (
+	"testing"
+
+	coreapi "github.com/Axway/agent-sdk/pkg/api"
+	prov "github.com/Axway/agent-sdk/pkg/apic/provisioning"
+	"github.com/Axway/agent-sdk/pkg/apic/provisioning/mock"
+	"github.com/Axway/agents-webmethods/pkg/common"
+	"github.com/Axway/agents-webmethods/pkg/webmethods"
+	"github.com/stretchr/testify/assert"
+)
+
+func newPlanGateway() *fakeGateway {
+	gateway := newFakeGateway()
+	gateway.addApplication(webmethods.Application{Id: "app", Name: "consumer"})
+	gateway.plans = []webmethods.Plan{
+		{Id: "gold-id", Name: "Gold"},
+		{Id: "silver-id", Name: "Silver"},
+	}
+	gateway.packages = []webmethods.Package{
+		{Id: "inactive", Active: false, Apis: []string{"api-1"}, Plans: []string{"gold-id", "silver-id"}},
+		{Id: "petstore", Active: true, Apis: []string{"api-1"}, Plans: []string{"gold-id", "silver-id"}},
+	}
+	return gateway
+}
+
+func newPlanAccessRequest(plan string, details map[string]string) mock.MockAccessRequest {
+	req := mock.MockAccessRequest{
+		AppName:         "consumer",
+		AppDetails:      map[string]string{common.AttrAppID: "app"},
+		Details:         details,
+		InstanceDetails: map[string]interface{}{common.AttrAPIID: "api-1"},
+	}
+	if plan != "" {
+		req.AccessRequestData = map[string]interface{}{PlanField: plan}
+	}
+	return req
+}
+
+func TestAccessRequestProvisionPlanLifecycle(t *testing.T) {
+	gateway := newPlanGateway()
+	p := newTestProvisioner(t, gateway)
+
+	// subscribe through the package plan
+	status, _ := p.AccessRequestProvision(newPlanAccessRequest("Gold", nil))
+	assert.Equal(t, prov.Success, status.GetStatus())
+	details := status.GetProperties()
+	subscriptionID := details[common.AttrSubscriptionID]
+	assert.Equal(t, &webmethods.Subscription{Id: subscriptionID, ApplicationId: "app", PackageId: "petstore", PlanId: "gold-id"}, gateway.subscriptions[subscriptionID])
+	assert.Empty(t, gateway.applications["app"].ConsumingAPIs)
+
+	// downgrade keeps the subscription and changes its plan
+	status, _ = p.AccessRequestProvision(newPlanAccessRequest("Silver", details))
+	assert.Equal(t, prov.Success, status.GetStatus())
+	details = status.GetProperties()
+	assert.Equal(t, subscriptionID, details[common.AttrSubscriptionID])
+	assert.Len(t, gateway.subscriptions, 1)
+	assert.Equal(t, "silver-id", gateway.subscriptions[subscriptionID].PlanId)
+
+	// removing the plan associates the API directly
+	status, _ = p.AccessRequestProvision(newPlanAccessRequest("", details))
+	assert.Equal(t, prov.Success, status.GetStatus())
+	assert.Empty(t, status.GetProperties()[common.AttrSubscriptionID])
+	assert.Empty(t, gateway.subscriptions)
+	assert.Equal(t, []string{"api-1"}, gateway.applications["app"].ConsumingAPIs)
+}
+
+func TestAccessRequestDeprovisionDeletesPlanSubscription(t *testing.T) {
+	gateway := newPlanGateway()
+	p := newTestProvisioner(t, gateway)
+	status, _ := p.AccessRequestProvision(newPlanAccessRequest("Gold", nil))
+	assert.Equal(t, prov.Success, status.GetStatus())
+
+	status = p.AccessRequestDeprovision(newPlanAccessRequest("Gold", status.GetProperties()))
+
+	assert.Equal(t, prov.Success, status.GetStatus())
+	assert.Empty(t, gateway.subscriptions)
+}
+
+func TestAccessRequestProvisionUnknownPlan(t *testing.T) {
+	gateway := newPlanGateway()
+	p := newTestProvisioner(t, gateway)
+
+	status, _ := p.AccessRequestProvision(newPlanAccessRequest("Platinum", nil))
+
+	assert.Equal(t, prov.Error, status.GetStatus())
+	assert.Empty(t, gateway.subscriptions)
+	assert.NotContains(t, gateway.calls, coreapi.POST+" "+subscriptionPath)
+}
+
+func TestAccessRequestProvisionRollsBackPlanChange(t *testing.T) {
+	gateway := newPlanGateway()
+	gateway.subscriptions["subscription"] = &webmethods.Subscription{Id: "subscription", ApplicationId: "app", PackageId: "petstore", PlanId: "gold-id"}
+	gateway.failOn(coreapi.PUT, applicationsPath+"/app")
+	p := newTestProvisioner(t, gateway)
+	details := map[string]string{
+		common.AttrSubscriptionID: "subscription",
+		common.AttrPackageID:      "petstore",
+		common.AttrPlanID:         "gold-id",
+	}
+	req := newPlanAccessRequest("Silver", details)
+	req.AccessRequestData[AllowedIPsField] = []interface{}{"10.0.0.1"}
+
+	status, _ := p.AccessRequestProvision(req)
+
+	assert.Equal(t, prov.Error, status.GetStatus())
+	assert.Equal(t, "gold-id", gateway.subscriptions["subscription"].PlanId)
+}
@@ -3,13 +3,19 @@ package subscription
 import (
 	"errors"
 	"fmt"
+	"strings"
 
+	v1 "github.com/Axway/agent-sdk/pkg/apic/apiserver/models/api/v1"
+	management "github.com/Axway/agent-sdk/pkg/apic/apiserver/models/management/v1alpha1"
 	prov "github.com/Axway/agent-sdk/pkg/apic/provisioning"
 	"github.com/Axway/agent-sdk/pkg/util"
 	"github.com/Axway/agent-sdk/pkg/util/log"
+	"github.com/Axway/agents-webmethods/pkg/audit"
 	"github.com/Axway/agents-webmethods/pkg/common"
+	"github.com/Axway/agents-webmethods/pkg/config"
 	"github.com/Axway/agents-webmethods/pkg/webmethods"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
 )
 
 const (
@@ -26,25 +32,115 @@ const (
 	ClientTypeField = "clientType"
 	AudienceField   = "audience"
 	OauthScopes     = "oauthScopes"
+
+	// ApplicationIDField - access data field holding the id of a dedicated webMethods application
+	ApplicationIDField = "applicationId"
+
+	// applicationDescriptionPrefix marks the webMethods applications created by the agent
+	applicationDescriptionPrefix = "Amplify "
 )
 
 type provisioner struct {
-	client webmethods.Client
-	log    logrus.FieldLogger
+	client      webmethods.Client
+	cfg         *config.WebMethodConfig
+	metadata    *metadataReader
+	naming      *applicationNaming
+	environment string
+	// oauthServers maps the oauth credential request definitions to their authorization server alias
+	oauthServers *OauthServers
+	// locks serializes the requests of each managed application
+	locks *applicationLocks
+	// accessRequests gives the identifier values recorded by the other access requests of a managed application
+	accessRequests accessRequestCache
+	// credentials lists the Central credentials sharing the api key of a managed application
+	credentials credentialLister
+	audit       audit.Sink
+	log         logrus.FieldLogger
+}
+
+type accessRequestCache interface {
+	GetAccessRequestsByApp(managedAppName string) []*v1.ResourceInstance
+}
+
+type credentialLister interface {
+	GetResources(ri v1.Interface) ([]v1.Interface, error)
+}
+
+// ProvisionerOption configures optional provisioner behavior
+type ProvisionerOption func(p *provisioner)
+
+// WithApplicationMetadata populates the webMethods applications created by the provisioner with the description,
+// owner team and contact details of the Central managed applications
+func WithApplicationMetadata(cache applicationCache, users userClient) ProvisionerOption {
+	return func(p *provisioner) {
+		p.metadata = &metadataReader{cache: cache, users: users, log: p.log}
+	}
+}
+
+// WithAccessRequestCache keeps the application identifiers shared by several access requests of a managed application
+// until the last of them is deprovisioned
+func WithAccessRequestCache(cache accessRequestCache) ProvisionerOption {
+	return func(p *provisioner) {
+		p.accessRequests = cache
+	}
+}
+
+// WithCredentialClient keeps the api key of an application while other credentials of its managed application still
+// use it, the key is neither removed nor rotated for one of them
+func WithCredentialClient(central credentialLister) ProvisionerOption {
+	return func(p *provisioner) {
+		p.credentials = central
+	}
+}
+
+// WithEnvironment sets the Central environment name available to the application name template
+func WithEnvironment(environment string) ProvisionerOption {
+	return func(p *provisioner) {
+		p.environment = environment
+	}
+}
+
+// WithOauthServers sets the authorization server aliases targeted by the oauth credential request definitions
+func WithOauthServers(servers *OauthServers) ProvisionerOption {
+	return func(p *provisioner) {
+		p.oauthServers = servers
+	}
+}
+
+// WithAuditSink records every provisioning action in the audit sink
+func WithAuditSink(sink audit.Sink) ProvisionerOption {
+	return func(p *provisioner) {
+		p.audit = sink
+	}
 }
 
 // NewProvisioner creates a type to implement the SDK Provisioning methods for handling subscriptions
-func NewProvisioner(client webmethods.Client, log logrus.FieldLogger) prov.Provisioning {
-	return &provisioner{
+func NewProvisioner(client webmethods.Client, cfg *config.WebMethodConfig, log logrus.FieldLogger, opts ...ProvisionerOption) prov.Provisioning {
+	p := &provisioner{
 		client: client,
+		cfg:    cfg,
+		locks:  sharedLocks,
 		log:    log.WithField("component", "mp-provisioner"),
 	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	naming, err := newApplicationNaming(cfg.AppNameTemplate, p.environment)
+	if err != nil {
+		p.log.WithError(err).Error("invalid application name template, using the managed application names")
+	}
+	p.naming = naming
+	if p.audit != nil {
+		return auditedProvisioner{Provisioning: p, sink: p.audit, log: p.log}
+	}
+	return p
 }
 
 // AccessRequestDeprovision deletes a contract
 func (p provisioner) AccessRequestDeprovision(req prov.AccessRequest) prov.RequestStatus {
 	p.log.Info("deprovisioning access request")
 	rs := prov.NewRequestStatusBuilder()
+	defer p.locks.lock(req.GetApplicationName())()
 	instDetails := req.GetInstanceDetails()
 
 	apiID := util.ToString(instDetails[common.AttrAPIID])
@@ -59,11 +155,43 @@ func (p provisioner) AccessRequestDeprovision(req prov.AccessRequest) prov.Reque
 		return p.failed(rs, notFound(common.AttrAppID))
 	}
 
-	err := p.client.UnsubscribeApplication(webmethodsApplicationId, apiID)
+	if req.GetAccessRequestDetailsValue(common.AttrImported) == "true" {
+		// the access existed on webMethods before it was imported, it is not removed with its access request
+		p.log.
+			WithField("api", apiID).
+			WithField("app", req.GetApplicationName()).
+			Info("kept imported access")
+		return rs.Success()
+	}
+
+	if req.GetAccessRequestDetailsValue(common.AttrDedicatedApp) == "true" {
+		// the application was created for this access request only
+		err := p.deleteApplication(webmethodsApplicationId)
+		if err != nil {
+			return p.failed(rs, err)
+		}
+		p.log.
+			WithField("api", apiID).
+			WithField("app", req.GetApplicationName()).
+			Info("removed access and dedicated application")
+		return rs.Success()
+	}
+
+	var err error
+	if subscriptionId := req.GetAccessRequestDetailsValue(common.AttrSubscriptionID); subscriptionId != "" {
+		err = p.client.DeleteSubscription(subscriptionId)
+	} else {
+		err = p.client.UnsubscribeApplication(webmethodsApplicationId, apiID)
+	}
 	if err != nil {
 		return p.failed(rs, errors.New("Error removing API from Webmethods Application"))
 	}
 
+	err = p.removeIdentifiers(webmethodsApplicationId, req)
+	if err != nil {
+		return p.failed(rs, err)
+	}
+
 	p.log.
 		WithField("api", apiID).
 		WithField("app", req.GetApplicationName()).
@@ -75,6 +203,17 @@ func (p provisioner) AccessRequestDeprovision(req prov.AccessRequest) prov.Reque
 func (p provisioner) AccessRequestProvision(req prov.AccessRequest) (prov.RequestStatus, prov.AccessData) {
 	p.log.Info("provisioning access request")
 	rs := prov.NewRequestStatusBuilder()
+	defer p.locks.lock(req.GetApplicationName())()
+	appID, err := p.importedAccess(req)
+	if err != nil {
+		return p.failed(rs, err), nil
+	}
+	if appID != "" {
+		// the access already exists on webMethods, the importer marks it provisioned
+		rs.AddProperty(common.AttrAppID, appID)
+		rs.AddProperty(common.AttrImported, "true")
+		return rs.Success(), nil
+	}
 	instDetails := req.GetInstanceDetails()
 
 	apiID := util.ToString(instDetails[common.AttrAPIID])
@@ -82,64 +221,147 @@ func (p provisioner) AccessRequestProvision(req prov.AccessRequest) (prov.Reques
 		return p.failed(rs, notFound(common.AttrAPIID)), nil
 	}
 
+	accessData, err := getAccessProvData(req.GetAccessRequestData())
+	if err != nil {
+		return p.failed(rs, err), nil
+	}
+
+	rb := newRollback(p.log)
 	webmethodsApplicationId := req.GetApplicationDetailsValue(common.AttrAppID)
 	log.Infof("webmethodsApplicationId : %s", webmethodsApplicationId)
-	if webmethodsApplicationId == "" {
-		// Using the existing application
+	dedicated := p.cfg.AppPerAccessRequest
+	if dedicated || webmethodsApplicationId == "" {
 		appName := req.GetApplicationName()
-		var err error
-		webmethodsApplicationId, err = createApplication(appName, p)
+		name := p.applicationName(appName, "", "")
+		if dedicated {
+			name = dedicatedApplicationName(name, req.GetID())
+		}
+		var created bool
+		webmethodsApplicationId, created, err = createApplication(name, appName, p)
 		if err != nil {
 			return p.failed(rs, errors.New("Error creating webmethods application")), nil
 		}
+		if created {
+			applicationId := webmethodsApplicationId
+			rb.add("create application", func() error {
+				return p.client.DeleteApplication(applicationId)
+			})
+		}
 	}
 
-	webmethodsApplication, err := p.client.GetApplication(webmethodsApplicationId)
-	if err != nil || len(webmethodsApplication.Applications) == 0 {
+	_, err = getApplication(p.client, webmethodsApplicationId)
+	if err != nil {
+		rb.run()
 		return p.failed(rs, errors.New("Unable to get Webmethods Application")), nil
 	}
 
-	apiIds := []string{apiID}
-	applicationApiSubscription := webmethods.ApplicationApiSubscription{
-		ApiIDs: apiIds,
+	current := planSubscription{
+		id:        req.GetAccessRequestDetailsValue(common.AttrSubscriptionID),
+		packageId: req.GetAccessRequestDetailsValue(common.AttrPackageID),
+		planId:    req.GetAccessRequestDetailsValue(common.AttrPlanID),
 	}
+	var subscription planSubscription
+	if accessData.plan != "" {
+		// the API is consumed through the package, subscribing to the plan applies its rate limits and quotas
+		subscription, err = p.subscribePlan(rb, webmethodsApplicationId, apiID, accessData.plan, current)
+		if err != nil {
+			rb.run()
+			return p.failed(rs, err), nil
+		}
+	} else {
+		apiIds := []string{apiID}
+		applicationApiSubscription := webmethods.ApplicationApiSubscription{
+			ApiIDs: apiIds,
+		}
 
-	err = p.client.SubscribeApplication(webmethodsApplicationId, &applicationApiSubscription)
+		err = p.client.SubscribeApplication(webmethodsApplicationId, &applicationApiSubscription)
+		if err != nil {
+			rb.run()
+			return p.failed(rs, errors.New("Error assocating API to Webmethods Application")), nil
+		}
+		rb.add("subscribe application", func() error {
+			return p.client.UnsubscribeApplication(webmethodsApplicationId, apiID)
+		})
+	}
+
+	var addedIPs, addedHosts []string
+	application, err := updateApplication(p.client, webmethodsApplicationId, func(application *webmethods.Application) bool {
+		ips := addIdentifierValues(application, IPAddressRangeIdentifier, accessData.allowedIPs)
+		hosts := addIdentifierValues(application, HostnameIdentifier, accessData.allowedHosts)
+		// the values are added again when the application was overwritten, they were still added by this request
+		addedIPs = appendMissing(addedIPs, ips)
+		addedHosts = appendMissing(addedHosts, hosts)
+		if len(ips) > 0 || len(hosts) > 0 {
+			log.Infof("Updating application identifiers for the application %s", application.Name)
+		}
+		return len(ips) > 0 || len(hosts) > 0
+	})
 	if err != nil {
-		return p.failed(rs, errors.New("Error assocating API to Webmethods Application")), nil
+		rb.run()
+		return p.failed(rs, errors.New("Unable to update Webmethods Application identifiers")), nil
+	}
+	// the requested values already recorded by this or another access request stay recorded, a value is removed
+	// from the application with the last access request recording it
+	sharedIPs, sharedHosts := p.sharedIdentifierValues(req, webmethodsApplicationId)
+	sharedIPs = append(sharedIPs, splitIdentifierValues(req.GetAccessRequestDetailsValue(common.AttrAllowedIPs))...)
+	sharedHosts = append(sharedHosts, splitIdentifierValues(req.GetAccessRequestDetailsValue(common.AttrAllowedHosts))...)
+	addedIPs = appendShared(addedIPs, accessData.allowedIPs, sharedIPs)
+	addedHosts = appendShared(addedHosts, accessData.allowedHosts, sharedHosts)
+	if current.id != "" && subscription.id == "" {
+		// the plan was removed from the access request, the API is now directly associated
+		err = p.client.DeleteSubscription(current.id)
+		if err != nil {
+			rb.run()
+			return p.failed(rs, errors.New("Unable to remove the Webmethods plan subscription")), nil
+		}
 	}
 	// process access request create
 	rs.AddProperty(common.AttrAppID, webmethodsApplicationId)
+	rs.AddProperty(common.AttrSubscriptionID, subscription.id)
+	rs.AddProperty(common.AttrPackageID, subscription.packageId)
+	rs.AddProperty(common.AttrPlanID, subscription.planId)
+	rs.AddProperty(common.AttrAllowedIPs, joinIdentifierValues(addedIPs))
+	rs.AddProperty(common.AttrAllowedHosts, joinIdentifierValues(addedHosts))
+	var data prov.AccessData
+	if dedicated {
+		// the credentials of the managed application do not apply to the dedicated application, return its own key
+		rs.AddProperty(common.AttrDedicatedApp, "true")
+		data = prov.NewAccessDataBuilder().SetData(map[string]interface{}{
+			ApplicationIDField: webmethodsApplicationId,
+			prov.APIKey:        application.AccessTokens.ApiAccessKeyCredentials.ApiAccessKey,
+		})
+	}
 	p.log.
 		WithField("api", apiID).
 		WithField("app", req.GetApplicationName()).
 		Info("granted access")
-	return rs.Success(), nil
+	return rs.Success(), data
 }
 
 // ApplicationRequestDeprovision deletes an app
 func (p provisioner) ApplicationRequestDeprovision(req prov.ApplicationRequest) prov.RequestStatus {
 	p.log.Info("deprovisioning application")
 	rs := prov.NewRequestStatusBuilder()
+	defer p.locks.lock(req.GetManagedApplicationName())()
 
 	appID := req.GetApplicationDetailsValue(common.AppID)
 	webmethodsApplicationId := req.GetApplicationDetailsValue(common.AttrAppID)
 	if webmethodsApplicationId == "" {
 		return p.failed(rs, notFound(common.AttrAppID))
 	}
-	applicationResponse, err := p.client.GetApplication(webmethodsApplicationId)
-	if err != nil {
-		return p.failed(rs, errors.New("Error calling webmethods"))
-	}
-	if len(applicationResponse.Applications) == 0 {
-		log.Warnf("Application with id %s is already deleted", webmethodsApplicationId)
+	if req.GetApplicationDetailsValue(common.AttrImported) == "true" {
+		// the application existed on webMethods before it was imported, only the accesses added by the agent are
+		// removed, with their access requests
+		p.log.
+			WithField("appName", req.GetManagedApplicationName()).
+			WithField("appID", appID).
+			Info("kept imported application")
 		return rs.Success()
 	}
-	err = p.client.DeleteApplication(webmethodsApplicationId)
+	err := p.deleteApplication(webmethodsApplicationId)
 	if err != nil {
-		return p.failed(rs, errors.New("Error Deleting Webmethods application"))
+		return p.failed(rs, err)
 	}
-	log.Infof("Application with Id %s deleted successfully on webmethods", webmethodsApplicationId)
 	p.log.
 		WithField("appName", req.GetManagedApplicationName()).
 		WithField("appID", appID).
@@ -151,13 +373,25 @@ func (p provisioner) ApplicationRequestDeprovision(req prov.ApplicationRequest)
 func (p provisioner) ApplicationRequestProvision(req prov.ApplicationRequest) prov.RequestStatus {
 	p.log.Info("provisioning application")
 	rs := prov.NewRequestStatusBuilder()
+	defer p.locks.lock(req.GetManagedApplicationName())()
 
 	appName := req.GetManagedApplicationName()
 	if appName == "" {
 		return p.failed(rs, notFound("managed application name"))
 	}
+	appID, err := p.importedApplication(req)
+	if err != nil {
+		return p.failed(rs, err)
+	}
+	if appID != "" {
+		// the application already exists on webMethods, the importer marks it provisioned
+		rs.AddProperty(common.AttrAppID, appID)
+		rs.AddProperty(common.AttrImported, "true")
+		return rs.Success()
+	}
 
-	applicationId, err := createApplication(appName, p)
+	name := p.applicationName(appName, req.GetTeamName(), req.GetID())
+	applicationId, _, err := createApplication(name, appName, p)
 	if err != nil {
 		return p.failed(rs, errors.New("Error creating application"))
 	}
@@ -175,6 +409,7 @@ func (p provisioner) CredentialDeprovision(req prov.CredentialRequest) prov.Requ
 	msg := "credentials will be removed when the subscription is deleted"
 	p.log.Info(msg)
 	rs := prov.NewRequestStatusBuilder()
+	defer p.locks.lock(req.GetApplicationName())()
 	log.Infof("Credential Type %s", req.GetCredentialType())
 	// process credential delete
 	webmethodsApplicationId := req.GetCredentialDetailsValue(common.AttrAppID)
@@ -184,39 +419,100 @@ func (p provisioner) CredentialDeprovision(req prov.CredentialRequest) prov.Requ
 		return p.failed(rs, notFound(common.AttrAppID))
 	}
 
-	switch req.GetCredentialType() {
+	var err error
+	switch credentialKind(req.GetCredentialType()) {
 	case prov.APIKeyCRD:
-		err := p.client.DeleteApplicationAccessTokens(webmethodsApplicationId)
-		if err != nil {
-			return p.failed(rs, errors.New("Unable to clear application credentials from Webmethods"))
-		}
+		err = p.removeAPIKey(req, webmethodsApplicationId, req.GetCredentialDetailsValue(common.AttrAPIKeyID))
 	case OAuth2AuthType:
 		log.Info("Removing oauth credential")
+		err = p.removeStrategy(webmethodsApplicationId, req.GetCredentialDetailsValue(common.AttrStrategyID))
+	default:
+		err = fmt.Errorf("unsupported credential type %s", req.GetCredentialType())
+	}
+	if err != nil {
+		return p.failed(rs, err)
+	}
+	return rs.Success()
+}
+
+// removeAPIKey deletes the api key of the application, unless it was replaced since the credential was provisioned or
+// other credentials of the managed application still use it
+func (p provisioner) removeAPIKey(req prov.CredentialRequest, webmethodsApplicationId, keyID string) error {
+	shared, err := p.apiKeyShared(req, keyID)
+	if err != nil {
+		return errors.New("Unable to get the credentials of the application from Central")
+	}
+	if shared {
+		log.Infof("Api key of application %s is used by other credentials, keeping it", req.GetApplicationName())
+		return nil
+	}
+	if keyID != "" {
 		applicationsResponse, err := p.client.GetApplication(webmethodsApplicationId)
-		if len(applicationsResponse.Applications) == 0 {
-			log.Warnf("Unable to find webmethods application with Id %s", webmethodsApplicationId)
-			return rs.Success()
-		}
 		if err != nil {
-			return p.failed(rs, errors.New("Unable to get application from Webmethods"))
+			return errors.New("Unable to get application from Webmethods")
 		}
-		if len(applicationsResponse.Applications[0].AuthStrategyIds) == 0 {
-			log.Warnf("Oauth Credential already cleaned up for application %s", applicationsResponse.Applications[0].Name)
-			return rs.Success()
+		if len(applicationsResponse.Applications) == 0 {
+			log.Warnf("Unable to find webmethods application with Id %s", webmethodsApplicationId)
+			return nil
 		}
-		strategyId := applicationsResponse.Applications[0].AuthStrategyIds[0]
-		err = p.client.DeleteStrategy(strategyId)
-		if err != nil {
-			return p.failed(rs, errors.New("Unable to delete Oauth2 strategy from Webmethods"))
+		if apiKeyID(applicationsResponse.Applications[0].AccessTokens.ApiAccessKeyCredentials.ApiAccessKey) != keyID {
+			log.Warnf("Api key of the credential was replaced on application %s, keeping the current api key", applicationsResponse.Applications[0].Name)
+			return nil
 		}
 	}
-	return rs.Success()
+	err = p.client.DeleteApplicationAccessTokens(webmethodsApplicationId)
+	if err != nil {
+		return errors.New("Unable to clear application credentials from Webmethods")
+	}
+	return nil
+}
+
+// removeStrategy deletes the oauth strategy of the credential and detaches it from the application. Credentials
+// provisioned before their strategy was recorded remove the first strategy of the application.
+func (p provisioner) removeStrategy(webmethodsApplicationId, strategyId string) error {
+	applicationsResponse, err := p.client.GetApplication(webmethodsApplicationId)
+	if err != nil {
+		return errors.New("Unable to get application from Webmethods")
+	}
+	if len(applicationsResponse.Applications) == 0 {
+		log.Warnf("Unable to find webmethods application with Id %s", webmethodsApplicationId)
+		return nil
+	}
+	application := applicationsResponse.Applications[0]
+	if strategyId == "" && len(application.AuthStrategyIds) > 0 {
+		strategyId = application.AuthStrategyIds[0]
+	}
+	if !slices.Contains(application.AuthStrategyIds, strategyId) {
+		log.Warnf("Oauth Credential already cleaned up for application %s", application.Name)
+		return nil
+	}
+
+	err = p.client.DeleteStrategy(strategyId)
+	if err != nil {
+		return errors.New("Unable to delete Oauth2 strategy from Webmethods")
+	}
+	_, err = updateApplication(p.client, webmethodsApplicationId, func(application *webmethods.Application) bool {
+		remaining := []string{}
+		for _, id := range application.AuthStrategyIds {
+			if id != strategyId {
+				remaining = append(remaining, id)
+			}
+		}
+		changed := len(remaining) != len(application.AuthStrategyIds)
+		application.AuthStrategyIds = remaining
+		return changed
+	})
+	if err != nil && !errors.Is(err, errApplicationNotFound) {
+		return errors.New("Unable to update Webmethods Application strategies")
+	}
+	return nil
 }
 
 // CredentialProvision retrieves the credentials from an app
 func (p provisioner) CredentialProvision(req prov.CredentialRequest) (prov.RequestStatus, prov.Credential) {
 	p.log.Info("provisioning credentials")
 	rs := prov.NewRequestStatusBuilder()
+	defer p.locks.lock(req.GetApplicationName())()
 
 	appName := req.GetApplicationName()
 	if appName == "" {
@@ -230,33 +526,46 @@ func (p provisioner) CredentialProvision(req prov.CredentialRequest) (prov.Reque
 	}
 
 	log.Infof("Credential Type %s", req.GetCredentialType())
-	applicationsResponse, err := p.client.GetApplication(webmethodsApplicationId)
-	if err != nil || len(applicationsResponse.Applications) == 0 {
+	application, err := getApplication(p.client, webmethodsApplicationId)
+	if err != nil {
 		return p.failed(rs, errors.New("Unable to get application from Webmethods")), nil
 	}
 	var credential prov.Credential
 	provData := getCredProvData(req.GetCredentialData())
+	if alias, ok := p.oauthServers.alias(req.GetCredentialType()); ok {
+		provData.oauthServerName = alias
+	}
 
-	switch req.GetCredentialType() {
+	switch credentialKind(req.GetCredentialType()) {
 	case prov.APIKeyCRD:
-		application := applicationsResponse.Applications[0]
 		if len(provData.cors) > 0 {
 			log.Infof("Update javascript origins for the application %s", application.Name)
 			// Updating java script origins
-			application.JsOrigins = append(application.JsOrigins, provData.cors...)
-			applicationUpdateResponse, err := p.client.UpdateApplication(&application)
+			application, err = updateApplication(p.client, webmethodsApplicationId, func(application *webmethods.Application) bool {
+				changed := false
+				for _, origin := range provData.cors {
+					if !slices.Contains(application.JsOrigins, origin) {
+						application.JsOrigins = append(application.JsOrigins, origin)
+						changed = true
+					}
+				}
+				return changed
+			})
 			if err != nil {
 				return p.failed(rs, errors.New("Unable to to update Java Script Origins")), nil
 			}
-			credential = prov.NewCredentialBuilder().SetAPIKey(applicationUpdateResponse.AccessTokens.ApiAccessKeyCredentials.ApiAccessKey)
-		} else {
-			credential = prov.NewCredentialBuilder().SetAPIKey(application.AccessTokens.ApiAccessKeyCredentials.ApiAccessKey)
 		}
+		credential = apiKeyCredential(*application)
+		rs.AddProperty(common.AttrAPIKeyID, apiKeyID(credentialAPIKey(credential)))
 	case OAuth2AuthType:
-		credential, err = createOrGetOauthCredential(applicationsResponse.Applications[0], provData, p)
+		var strategyId string
+		credential, strategyId, err = p.createOrGetOauthCredential(*application, provData, req.GetID())
 		if err != nil {
 			return p.failed(rs, err), nil
 		}
+		rs.AddProperty(common.AttrStrategyID, strategyId)
+	default:
+		return p.failed(rs, fmt.Errorf("unsupported credential type %s", req.GetCredentialType())), nil
 	}
 	rs.AddProperty(common.AttrAppID, webmethodsApplicationId)
 	p.log.Info("created credentials")
@@ -266,6 +575,7 @@ func (p provisioner) CredentialProvision(req prov.CredentialRequest) (prov.Reque
 func (p provisioner) CredentialUpdate(req prov.CredentialRequest) (prov.RequestStatus, prov.Credential) {
 	p.log.Info("updating credential for app %s", req.GetApplicationName())
 	rs := prov.NewRequestStatusBuilder()
+	defer p.locks.lock(req.GetApplicationName())()
 	appName := req.GetApplicationName()
 	if appName == "" {
 		return p.failed(rs, notFound("appName")), nil
@@ -276,32 +586,214 @@ func (p provisioner) CredentialUpdate(req prov.CredentialRequest) (prov.RequestS
 	}
 
 	var credential prov.Credential
+	var err error
+
+	credentialType := credentialKind(req.GetCredentialType())
+	credentialID := req.GetCredentialDetailsValue(common.AttrAPIKeyID)
+	if credentialType == OAuth2AuthType {
+		credentialID, err = p.getStrategyId(webmethodsApplicationId, req.GetCredentialDetailsValue(common.AttrStrategyID))
+		if err != nil {
+			return p.failed(rs, err), nil
+		}
+	}
 
-	switch req.GetCredentialType() {
+	switch req.GetCredentialAction() {
+	case prov.Suspend, prov.Expire:
+		credential, err = p.setCredentialSuspended(credentialType, webmethodsApplicationId, credentialID, true)
+	case prov.Enable:
+		credential, err = p.setCredentialSuspended(credentialType, webmethodsApplicationId, credentialID, false)
+	default:
+		if credentialType == prov.APIKeyCRD {
+			var shared bool
+			shared, err = p.apiKeyShared(req, credentialID)
+			if err == nil && shared {
+				err = errors.New("Api key is used by other credentials of the application and can not be rotated")
+			}
+			if err != nil {
+				return p.failed(rs, err), nil
+			}
+		}
+		credential, err = p.rotateCredential(credentialType, webmethodsApplicationId, credentialID)
+	}
+	if err != nil {
+		return p.failed(rs, err), nil
+	}
+	switch credentialType {
 	case prov.APIKeyCRD:
+		rs.AddProperty(common.AttrAPIKeyID, apiKeyID(credentialAPIKey(credential)))
+	case OAuth2AuthType:
+		rs.AddProperty(common.AttrStrategyID, credentialID)
+	}
+	p.log.Infof("%s credentials for app %s", strings.ToLower(req.GetCredentialAction().String()), req.GetApplicationName())
+	return rs.Success(), credential
+}
+
+// rotateCredential creates a new api key for the application or client secret for the strategy of the credential. The
+// api key is only rotated while it is still the one of the credential.
+func (p provisioner) rotateCredential(credentialType, webmethodsApplicationId, credentialID string) (prov.Credential, error) {
+	switch credentialType {
+	case prov.APIKeyCRD:
+		if credentialID != "" {
+			applicationsResponse, err := p.client.GetApplication(webmethodsApplicationId)
+			if err != nil || len(applicationsResponse.Applications) == 0 {
+				return nil, errors.New("Unable to get application from Webmethods")
+			}
+			if apiKeyID(applicationsResponse.Applications[0].AccessTokens.ApiAccessKeyCredentials.ApiAccessKey) != credentialID {
+				return nil, errors.New("Api key of the credential was replaced on Webmethods")
+			}
+		}
 		err := p.client.RotateApplicationApikey(webmethodsApplicationId)
 		if err != nil {
-			return p.failed(rs, errors.New("Unable to Rotate Webmethods Application APIkey")), nil
+			return nil, errors.New("Unable to Rotate Webmethods Application APIkey")
 		}
 		applicationsResponse, err := p.client.GetApplication(webmethodsApplicationId)
-		if err != nil {
-			return p.failed(rs, errors.New("Unable to get application from Webmethods")), nil
+		if err != nil || len(applicationsResponse.Applications) == 0 {
+			return nil, errors.New("Unable to get application from Webmethods")
 		}
-		credential = prov.NewCredentialBuilder().SetAPIKey(applicationsResponse.Applications[0].AccessTokens.ApiAccessKeyCredentials.ApiAccessKey)
+		return apiKeyCredential(applicationsResponse.Applications[0]), nil
 	case OAuth2AuthType:
-		applicationsResponse, err := p.client.GetApplication(webmethodsApplicationId)
+		strategyResponse, err := p.client.RefereshOauth2Credential(credentialID)
 		if err != nil {
-			return p.failed(rs, errors.New("Unable to get application from Webmethods")), nil
+			return nil, errors.New("Unable to get strategy from Webmethods")
 		}
-		strategyId := applicationsResponse.Applications[0].AuthStrategyIds[0]
-		strategyResponse, err := p.client.RefereshOauth2Credential(strategyId)
+		return prov.NewCredentialBuilder().SetOAuthIDAndSecret(strategyResponse.Strategy.ClientRegistration.ClientId, strategyResponse.Strategy.ClientRegistration.ClientSecret), nil
+	}
+	return nil, fmt.Errorf("unsupported credential type %s", credentialType)
+}
+
+// apiKeyShared reports whether other credentials of the managed application, which are not being deleted, use the api
+// key of the credential. Credentials provisioned before their api key was recorded are assumed to use it.
+func (p provisioner) apiKeyShared(req prov.CredentialRequest, keyID string) (bool, error) {
+	if p.credentials == nil {
+		return false, nil
+	}
+	resources, err := p.credentials.GetResources(management.NewCredential("", p.environment))
+	if err != nil {
+		return false, err
+	}
+	for _, resource := range resources {
+		ri, err := resource.AsInstance()
 		if err != nil {
-			return p.failed(rs, errors.New("Unable to get strategy from Webmethods")), nil
+			continue
+		}
+		credential := &management.Credential{}
+		if err := credential.FromInstance(ri); err != nil {
+			continue
+		}
+		if credential.Name == req.GetName() || credential.Metadata.State == v1.ResourceDeleting ||
+			credential.Spec.ManagedApplication != req.GetApplicationName() ||
+			credentialKind(credential.Spec.CredentialRequestDefinition) != prov.APIKeyCRD {
+			continue
+		}
+		id, _ := util.GetAgentDetailsValue(ri, common.AttrAPIKeyID)
+		if keyID == "" || id == "" || id == keyID {
+			return true, nil
 		}
-		credential = prov.NewCredentialBuilder().SetOAuthIDAndSecret(strategyResponse.Strategy.ClientRegistration.ClientId, strategyResponse.Strategy.ClientRegistration.ClientSecret)
 	}
-	p.log.Infof("updated credentials for app %s", req.GetApplicationName())
-	return rs.Success(), credential
+	return false, nil
+}
+
+// setCredentialSuspended suspends or enables the credential without changing it. Api keys are suspended with the
+// application, oauth credentials by disabling the client registration of the strategy.
+func (p provisioner) setCredentialSuspended(credentialType, webmethodsApplicationId, credentialID string, suspended bool) (prov.Credential, error) {
+	switch credentialType {
+	case prov.APIKeyCRD:
+		var err error
+		if suspended {
+			err = p.client.SuspendApplication(webmethodsApplicationId)
+		} else {
+			err = p.client.EnableApplication(webmethodsApplicationId)
+		}
+		if err != nil {
+			return nil, errors.New("Unable to update Webmethods Application suspension")
+		}
+		applicationsResponse, err := p.client.GetApplication(webmethodsApplicationId)
+		if err != nil || len(applicationsResponse.Applications) == 0 {
+			return nil, errors.New("Unable to get application from Webmethods")
+		}
+		return apiKeyCredential(applicationsResponse.Applications[0]), nil
+	case OAuth2AuthType:
+		strategyResponse, err := p.client.GetStrategy(credentialID)
+		if err != nil {
+			return nil, errors.New("Unable to get strategy from Webmethods")
+		}
+		strategy := strategyResponse.Strategy
+		if strategy.ClientRegistration.Enabled == suspended {
+			strategy.ClientRegistration.Enabled = !suspended
+			strategyResponse, err = p.client.UpdateStrategy(&strategy)
+			if err != nil {
+				return nil, errors.New("Unable to update Oauth2 strategy on Webmethods")
+			}
+		}
+		return prov.NewCredentialBuilder().SetOAuthIDAndSecret(strategyResponse.Strategy.ClientRegistration.ClientId, strategyResponse.Strategy.ClientRegistration.ClientSecret), nil
+	}
+	return nil, fmt.Errorf("unsupported credential type %s", credentialType)
+}
+
+// getStrategyId returns the oauth strategy of the credential. Credentials provisioned before their strategy was
+// recorded use the first strategy of the application.
+func (p provisioner) getStrategyId(webmethodsApplicationId, strategyId string) (string, error) {
+	if strategyId != "" {
+		return strategyId, nil
+	}
+	applicationsResponse, err := p.client.GetApplication(webmethodsApplicationId)
+	if err != nil || len(applicationsResponse.Applications) == 0 {
+		return "", errors.New("Unable to get application from Webmethods")
+	}
+	if len(applicationsResponse.Applications[0].AuthStrategyIds) == 0 {
+		return "", errors.New("Oauth2 strategy not found for the Webmethods application")
+	}
+	return applicationsResponse.Applications[0].AuthStrategyIds[0], nil
+}
+
+// removeIdentifiers removes the application identifiers that were added by the access request
+func (p provisioner) removeIdentifiers(webmethodsApplicationId string, req prov.AccessRequest) error {
+	// the values still needed by another access request of the managed application are kept
+	sharedIPs, sharedHosts := p.sharedIdentifierValues(req, webmethodsApplicationId)
+	ips := without(splitIdentifierValues(req.GetAccessRequestDetailsValue(common.AttrAllowedIPs)), sharedIPs)
+	hosts := without(splitIdentifierValues(req.GetAccessRequestDetailsValue(common.AttrAllowedHosts)), sharedHosts)
+	if len(ips) == 0 && len(hosts) == 0 {
+		return nil
+	}
+
+	_, err := updateApplication(p.client, webmethodsApplicationId, func(application *webmethods.Application) bool {
+		removedIPs := removeIdentifierValues(application, IPAddressRangeIdentifier, ips)
+		removedHosts := removeIdentifierValues(application, HostnameIdentifier, hosts)
+		if removedIPs || removedHosts {
+			log.Infof("Removing application identifiers from the application %s", application.Name)
+		}
+		return removedIPs || removedHosts
+	})
+	if errors.Is(err, errApplicationNotFound) {
+		log.Warnf("Application with id %s is already deleted", webmethodsApplicationId)
+		return nil
+	}
+	if err != nil {
+		return errors.New("Unable to update Webmethods Application identifiers")
+	}
+	return nil
+}
+
+// sharedIdentifierValues returns the identifier values recorded by the other access requests of the managed
+// application on the same webMethods application
+func (p provisioner) sharedIdentifierValues(req prov.AccessRequest, webmethodsApplicationId string) ([]string, []string) {
+	if p.accessRequests == nil {
+		return nil, nil
+	}
+	var ips, hosts []string
+	for _, ri := range p.accessRequests.GetAccessRequestsByApp(req.GetApplicationName()) {
+		if ri == nil || ri.Metadata.ID == req.GetID() {
+			continue
+		}
+		if appID, _ := util.GetAgentDetailsValue(ri, common.AttrAppID); appID != webmethodsApplicationId {
+			continue
+		}
+		recordedIPs, _ := util.GetAgentDetailsValue(ri, common.AttrAllowedIPs)
+		recordedHosts, _ := util.GetAgentDetailsValue(ri, common.AttrAllowedHosts)
+		ips = append(ips, splitIdentifierValues(recordedIPs)...)
+		hosts = append(hosts, splitIdentifierValues(recordedHosts)...)
+	}
+	return ips, hosts
 }
 
 func (p provisioner) failed(rs prov.RequestStatusBuilder, err error) prov.RequestStatus {
@@ -342,6 +834,12 @@ func getCredProvData(credData map[string]interface{}) credentialMetaData {
 	if data, ok := credData[OauthServerField]; ok && data != nil {
 		credMetaData.oauthServerName = data.(string)
 	}
+	// grant types field
+	if data, ok := credData[GrantTypesField]; ok && data != nil {
+		for _, g := range data.([]interface{}) {
+			credMetaData.grantTypes = append(credMetaData.grantTypes, g.(string))
+		}
+	}
 	// credential type field
 	if data, ok := credData[ApplicationTypeField]; ok && data != nil {
 		credMetaData.appType = data.(string)
@@ -359,21 +857,28 @@ type credentialMetaData struct {
 	cors            []string
 	redirectURLs    []string
 	oauthServerName string
+	grantTypes      []string
 	appType         string
 	audience        string
 }
 
-func createOrGetOauthCredential(application webmethods.Application, provData credentialMetaData, p provisioner) (prov.Credential, error) {
-	var strategyResponse *webmethods.StrategyResponse
-	var err error
-	if len(application.AuthStrategyIds) == 0 {
-		log.Infof("Creating new Oauth Strategy named %s", application.Name)
+// createOrGetOauthCredential creates a dedicated oauth strategy for the credential and adds it to the application. The
+// strategy already created for the credential is returned when the provisioning is retried.
+func (p provisioner) createOrGetOauthCredential(application webmethods.Application, provData credentialMetaData, credentialID string) (prov.Credential, string, error) {
+	name := application.Name
+	if credentialID != "" {
+		name = application.Name + "-" + shortID(credentialID)
+	}
+	strategyResponse := p.findStrategy(application, name)
+	if strategyResponse == nil {
+		var err error
+		log.Infof("Creating new Oauth Strategy named %s", name)
+		grantTypes := provData.grantTypes
+		if len(grantTypes) == 0 {
+			grantTypes = defaultGrantTypes
+		}
 		dcrconfig := webmethods.DcrConfig{
-			AllowedGrantTypes: []string{"authorization_code",
-				"password",
-				"client_credentials",
-				"refresh_token",
-				"implicit"},
+			AllowedGrantTypes:  grantTypes,
 			RedirectUris:       provData.redirectURLs,
 			AuthServer:         provData.oauthServerName,
 			ApplicationType:    "web",
@@ -383,7 +888,7 @@ func createOrGetOauthCredential(application webmethods.Application, provData cre
 			PkceType:           "USE_GLOBAL_SETTING",
 		}
 		strategy := &webmethods.Strategy{
-			Name:            application.Name,
+			Name:            name,
 			Description:     application.Name,
 			AuthServerAlias: provData.oauthServerName,
 			Audience:        provData.audience,
@@ -397,49 +902,118 @@ func createOrGetOauthCredential(application webmethods.Application, provData cre
 
 		strategyResponse, err = p.client.CreateOauth2Strategy(strategy)
 		if err != nil {
-			return nil, errors.New("Unable to get application from Webmethods")
+			return nil, "", errors.New("Unable to get application from Webmethods")
 		}
-
-		application.AuthStrategyIds = []string{strategyResponse.Strategy.Id}
-		applicationsResponse, err := p.client.UpdateApplication(&application)
+		rb := newRollback(p.log)
+		strategyId := strategyResponse.Strategy.Id
+		rb.add("create strategy", func() error {
+			return p.client.DeleteStrategy(strategyId)
+		})
+
+		_, err = updateApplication(p.client, application.Id, func(application *webmethods.Application) bool {
+			if slices.Contains(application.AuthStrategyIds, strategyId) {
+				return false
+			}
+			application.AuthStrategyIds = append(application.AuthStrategyIds, strategyId)
+			return true
+		})
 		if err != nil {
-			return nil, errors.New("Unable to get update  Webmethods applicaiton")
-		}
-		if applicationsResponse == nil {
-			return nil, errors.New("Unable to get update  Webmethods applicaiton")
+			rb.run()
+			return nil, "", errors.New("Unable to get update  Webmethods applicaiton")
 		}
 	} else {
-		strategyId := application.AuthStrategyIds[0]
-		log.Infof("Using existing Oauth Strategy named %s with id %s", application.Name, strategyId)
-		strategyResponse, err = p.client.GetStrategy(strategyId)
+		log.Infof("Using existing Oauth Strategy named %s with id %s", name, strategyResponse.Strategy.Id)
+	}
+	credential := prov.NewCredentialBuilder().SetOAuthIDAndSecret(strategyResponse.Strategy.ClientRegistration.ClientId, strategyResponse.Strategy.ClientRegistration.ClientSecret)
+	return credential, strategyResponse.Strategy.Id, nil
+}
+
+// findStrategy returns the strategy of the application with the given name, nil when there is none
+func (p provisioner) findStrategy(application webmethods.Application, name string) *webmethods.StrategyResponse {
+	for _, strategyId := range application.AuthStrategyIds {
+		strategyResponse, err := p.client.GetStrategy(strategyId)
 		if err != nil {
-			return nil, errors.New("Unable to get strategy from Webmethods")
+			log.Warnf("Unable to get strategy %s from Webmethods: %s", strategyId, err)
+			continue
+		}
+		if strategyResponse.Strategy.Name == name {
+			return strategyResponse
 		}
 	}
-	credential := prov.NewCredentialBuilder().SetOAuthIDAndSecret(strategyResponse.Strategy.ClientRegistration.ClientId, strategyResponse.Strategy.ClientRegistration.ClientSecret)
-	return credential, nil
+	return nil
+}
+
+// applicationName returns the webMethods application name of the managed application. The team and id not given
+// with the request are read from the managed application so all the requests of an application name it the same way.
+func (p provisioner) applicationName(appName, team, id string) string {
+	if team == "" || id == "" {
+		if app := p.metadata.managedApplication(appName); app != nil {
+			if team == "" {
+				team = p.metadata.teamName(app.Owner)
+			}
+			if id == "" {
+				id = app.Metadata.ID
+			}
+		}
+	}
+	return p.naming.name(appName, team, id)
+}
+
+// dedicatedApplicationName makes the application name unique to the access request
+func dedicatedApplicationName(name, accessRequestID string) string {
+	suffix := shortID(accessRequestID)
+	if suffix == "" || strings.Contains(name, suffix) {
+		return name
+	}
+	return name + "-" + suffix
+}
+
+// deleteApplication deletes the webMethods application, an already deleted application is not an error
+func (p provisioner) deleteApplication(webmethodsApplicationId string) error {
+	applicationResponse, err := p.client.GetApplication(webmethodsApplicationId)
+	if err != nil {
+		return errors.New("Error calling webmethods")
+	}
+	if len(applicationResponse.Applications) == 0 {
+		log.Warnf("Application with id %s is already deleted", webmethodsApplicationId)
+		return nil
+	}
+	err = p.client.DeleteApplication(webmethodsApplicationId)
+	if err != nil {
+		return errors.New("Error Deleting Webmethods application")
+	}
+	log.Infof("Application with Id %s deleted successfully on webmethods", webmethodsApplicationId)
+	return nil
 }
 
-func createApplication(appName string, p provisioner) (string, error) {
-	searchAppResponse, err := p.client.FindApplicationByName(appName)
+// createApplication returns the id of the webMethods application with the given name, creating it when it does not exist.
+// The metadata of the new application is taken from the managed application. The returned flag is true when the
+// application was created by this call.
+func createApplication(name, managedAppName string, p provisioner) (string, bool, error) {
+	searchAppResponse, err := p.client.FindApplicationByName(name)
 	if err != nil {
-		return "", errors.New("Error contacting webmethods")
+		return "", false, errors.New("Error contacting webmethods")
 	}
 	var applicationId string
+	created := false
 	if len(searchAppResponse.SearchApplication) == 0 {
-		log.Infof("Creating new application with name %s", appName)
+		log.Infof("Creating new application with name %s", name)
 		var application webmethods.Application
-		application.Name = appName
+		application.Name = name
 		application.Version = "1.0"
-		application.Description = "Amplify " + appName
+		p.metadata.forName(managedAppName).apply(&application)
+		if p.cfg.APIKeyLifetime > 0 {
+			application.AccessTokens.ApiAccessKeyCredentials.ExpirationInterval = formatExpirationInterval(p.cfg.APIKeyLifetime)
+		}
 		createdApplication, err := p.client.CreateApplication(&application)
 		if err != nil {
-			return "", errors.New("Error creating application")
+			return "", false, errors.New("Error creating application")
 		}
 		applicationId = createdApplication.Id
+		created = true
 	} else {
 		log.Infof("Using the exsting application with Id %s", searchAppResponse.SearchApplication[0].ApplicationID)
 		applicationId = searchAppResponse.SearchApplication[0].ApplicationID
 	}
-	return applicationId, nil
+	return applicationId, created, nil
 }
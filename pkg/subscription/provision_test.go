@@ -0,0 +1,768 @@
+package subscription
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	coreapi "github.com/Axway/agent-sdk/pkg/api"
+	v1 "github.com/Axway/agent-sdk/pkg/apic/apiserver/models/api/v1"
+	management "github.com/Axway/agent-sdk/pkg/apic/apiserver/models/management/v1alpha1"
+	prov "github.com/Axway/agent-sdk/pkg/apic/provisioning"
+	"github.com/Axway/agent-sdk/pkg/apic/provisioning/mock"
+	"github.com/Axway/agent-sdk/pkg/util"
+	"github.com/Axway/agents-webmethods/pkg/common"
+	"github.com/Axway/agents-webmethods/pkg/config"
+	"github.com/Axway/agents-webmethods/pkg/webmethods"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	applicationsPath = "/rest/apigateway/applications"
+	strategiesPath   = "/rest/apigateway/strategies"
+	packagesPath     = "/rest/apigateway/packages"
+	plansPath        = "/rest/apigateway/plans"
+	subscriptionPath = "/rest/apigateway/subscriptions"
+	searchPath       = "/rest/apigateway/search"
+)
+
+// fakeGateway keeps webMethods applications and strategies in memory and serves the REST calls made by the client
+type fakeGateway struct {
+	mutex         sync.Mutex
+	applications  map[string]*webmethods.Application
+	strategies    map[string]*webmethods.Strategy
+	packages      []webmethods.Package
+	plans         []webmethods.Plan
+	subscriptions map[string]*webmethods.Subscription
+	// fail holds "METHOD path-prefix" entries for which the gateway returns a connection error
+	fail  map[string]bool
+	calls []string
+	seq   int
+	// revision is the last modification stamp given to an updated application
+	revision int
+	// onRead is called with the application id before an application is read
+	onRead func(id string)
+}
+
+func newFakeGateway() *fakeGateway {
+	return &fakeGateway{
+		applications:  map[string]*webmethods.Application{},
+		strategies:    map[string]*webmethods.Strategy{},
+		subscriptions: map[string]*webmethods.Subscription{},
+		fail:          map[string]bool{},
+	}
+}
+
+func (f *fakeGateway) failOn(method, path string) {
+	f.fail[method+" "+path] = true
+}
+
+func (f *fakeGateway) addApplication(app webmethods.Application) {
+	f.applications[app.Id] = &app
+}
+
+func (f *fakeGateway) nextID(prefix string) string {
+	f.seq++
+	return fmt.Sprintf("%s-%d", prefix, f.seq)
+}
+
+func (f *fakeGateway) send(request coreapi.Request) (*coreapi.Response, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	call := request.Method + " " + request.URL
+	f.calls = append(f.calls, call)
+	for key := range f.fail {
+		if strings.HasPrefix(call, key) {
+			return nil, errors.New("connection refused")
+		}
+	}
+
+	segments := strings.Split(strings.TrimPrefix(request.URL, applicationsPath), "/")
+	switch {
+	case request.URL == searchPath:
+		search := &webmethods.Search{}
+		json.Unmarshal(request.Body, search)
+		found := []webmethods.SearchApplication{}
+		for _, app := range f.applications {
+			if len(search.Scope) > 0 && app.Name == search.Scope[0].Keyword {
+				found = append(found, webmethods.SearchApplication{ApplicationID: app.Id, Name: app.Name})
+			}
+		}
+		return f.respond(http.StatusOK, webmethods.SearchApplicationResponse{SearchApplication: found})
+	case request.URL == applicationsPath && request.Method == coreapi.GET:
+		apps := []webmethods.Application{}
+		for _, app := range f.applications {
+			apps = append(apps, *app)
+		}
+		return f.respond(http.StatusOK, webmethods.ApplicationResponse{Applications: apps})
+	case request.URL == applicationsPath && request.Method == coreapi.POST:
+		app := &webmethods.Application{}
+		json.Unmarshal(request.Body, app)
+		app.Id = f.nextID("app")
+		app.AccessTokens.ApiAccessKeyCredentials.ApiAccessKey = app.Id + "-key"
+		f.applications[app.Id] = app
+		return f.respond(http.StatusCreated, app)
+	case strings.HasPrefix(request.URL, applicationsPath+"/"):
+		return f.application(request, segments[1], segments[2:])
+	case request.URL == strategiesPath && request.Method == coreapi.POST:
+		strategy := &webmethods.Strategy{}
+		json.Unmarshal(request.Body, strategy)
+		strategy.Id = f.nextID("strategy")
+		strategy.ClientRegistration.ClientId = strategy.Id + "-client"
+		strategy.ClientRegistration.ClientSecret = strategy.Id + "-secret"
+		f.strategies[strategy.Id] = strategy
+		return f.respond(http.StatusCreated, webmethods.StrategyResponse{Strategy: *strategy})
+	case request.URL == packagesPath:
+		return f.respond(http.StatusOK, webmethods.PackageResponse{Packages: f.packages})
+	case request.URL == plansPath:
+		return f.respond(http.StatusOK, webmethods.PlanResponse{Plans: f.plans})
+	case request.URL == subscriptionPath && request.Method == coreapi.POST:
+		subscription := &webmethods.Subscription{}
+		json.Unmarshal(request.Body, subscription)
+		subscription.Id = f.nextID("subscription")
+		f.subscriptions[subscription.Id] = subscription
+		return f.respond(http.StatusCreated, webmethods.SubscriptionResponse{Subscription: *subscription})
+	case strings.HasPrefix(request.URL, subscriptionPath+"/"):
+		id := strings.TrimPrefix(request.URL, subscriptionPath+"/")
+		if _, ok := f.subscriptions[id]; !ok {
+			return f.respond(http.StatusNotFound, nil)
+		}
+		if request.Method == coreapi.DELETE {
+			delete(f.subscriptions, id)
+			return f.respond(http.StatusNoContent, nil)
+		}
+		subscription := &webmethods.Subscription{}
+		json.Unmarshal(request.Body, subscription)
+		f.subscriptions[id] = subscription
+		return f.respond(http.StatusOK, webmethods.SubscriptionResponse{Subscription: *subscription})
+	case strings.HasPrefix(request.URL, strategiesPath+"/"):
+		id := strings.Split(strings.TrimPrefix(request.URL, strategiesPath+"/"), "/")[0]
+		strategy, ok := f.strategies[id]
+		if !ok {
+			return f.respond(http.StatusNotFound, nil)
+		}
+		if request.Method == coreapi.DELETE {
+			delete(f.strategies, id)
+			return f.respond(http.StatusNoContent, nil)
+		}
+		if request.Method == coreapi.PUT {
+			json.Unmarshal(request.Body, strategy)
+		}
+		return f.respond(http.StatusOK, webmethods.StrategyResponse{Strategy: *strategy})
+	}
+	return f.respond(http.StatusNotFound, nil)
+}
+
+func (f *fakeGateway) application(request coreapi.Request, id string, sub []string) (*coreapi.Response, error) {
+	app, ok := f.applications[id]
+	if len(sub) == 0 {
+		switch request.Method {
+		case coreapi.GET:
+			if f.onRead != nil {
+				f.onRead(id)
+			}
+			apps := []webmethods.Application{}
+			if ok {
+				apps = append(apps, *app)
+			}
+			return f.respond(http.StatusOK, webmethods.ApplicationResponse{Applications: apps})
+		case coreapi.PUT:
+			updated := &webmethods.Application{}
+			json.Unmarshal(request.Body, updated)
+			if ok {
+				// api associations are managed through the apis sub resource
+				updated.ConsumingAPIs = app.ConsumingAPIs
+			}
+			f.revision++
+			updated.LastModified = fmt.Sprint(f.revision)
+			f.applications[id] = updated
+			return f.respond(http.StatusOK, updated)
+		case coreapi.DELETE:
+			delete(f.applications, id)
+			return f.respond(http.StatusNoContent, nil)
+		}
+	}
+	if !ok {
+		return f.respond(http.StatusNotFound, nil)
+	}
+	if sub[0] == "apis" {
+		switch request.Method {
+		case coreapi.POST:
+			subscription := &webmethods.ApplicationApiSubscription{}
+			json.Unmarshal(request.Body, subscription)
+			app.ConsumingAPIs = append(app.ConsumingAPIs, subscription.ApiIDs...)
+			return f.respond(http.StatusCreated, nil)
+		case coreapi.DELETE:
+			remaining := []string{}
+			for _, apiID := range app.ConsumingAPIs {
+				if apiID != request.QueryParams["apiIDs"] {
+					remaining = append(remaining, apiID)
+				}
+			}
+			app.ConsumingAPIs = remaining
+			return f.respond(http.StatusNoContent, nil)
+		}
+	}
+	if sub[0] == "accessTokens" {
+		switch request.Method {
+		case coreapi.POST:
+			app.AccessTokens.ApiAccessKeyCredentials.ApiAccessKey = f.nextID(id + "-key")
+			return f.respond(http.StatusCreated, nil)
+		case coreapi.DELETE:
+			app.AccessTokens.ApiAccessKeyCredentials.ApiAccessKey = ""
+			return f.respond(http.StatusNoContent, nil)
+		}
+	}
+	return f.respond(http.StatusNotFound, nil)
+}
+
+// modify changes the application as another client saving it would
+func (f *fakeGateway) modify(id string, change func(application *webmethods.Application)) {
+	change(f.applications[id])
+	f.revision++
+	f.applications[id].LastModified = fmt.Sprint(f.revision)
+}
+
+// countCalls returns the number of requests sent with the method and url
+func (f *fakeGateway) countCalls(call string) int {
+	count := 0
+	for _, c := range f.calls {
+		if c == call {
+			count++
+		}
+	}
+	return count
+}
+
+func (f *fakeGateway) respond(code int, body interface{}) (*coreapi.Response, error) {
+	response := &coreapi.Response{Code: code}
+	if code == http.StatusNotFound {
+		body = map[string]string{"errorDetails": "not found"}
+	}
+	if body != nil {
+		response.Body, _ = json.Marshal(body)
+	}
+	return response, nil
+}
+
+func newTestProvisioner(t *testing.T, gateway *fakeGateway) *provisioner {
+	mc := &webmethods.MockClient{SendFunc: gateway.send}
+	client, err := webmethods.NewClient(&config.WebMethodConfig{}, mc)
+	assert.Nil(t, err)
+	return NewProvisioner(client, &config.WebMethodConfig{}, logrus.New()).(*provisioner)
+}
+
+func TestAccessRequestProvisionRollsBackCreatedApplication(t *testing.T) {
+	gateway := newFakeGateway()
+	gateway.failOn(coreapi.POST, applicationsPath+"/app-1/apis")
+	p := newTestProvisioner(t, gateway)
+
+	status, _ := p.AccessRequestProvision(mock.MockAccessRequest{
+		AppName:         "consumer",
+		InstanceDetails: map[string]interface{}{common.AttrAPIID: "api-1"},
+	})
+
+	assert.Equal(t, prov.Error, status.GetStatus())
+	assert.Empty(t, gateway.applications)
+	assert.Contains(t, gateway.calls, coreapi.DELETE+" "+applicationsPath+"/app-1")
+}
+
+func TestAccessRequestProvisionRollsBackSubscription(t *testing.T) {
+	gateway := newFakeGateway()
+	gateway.failOn(coreapi.PUT, applicationsPath+"/app-1")
+	p := newTestProvisioner(t, gateway)
+
+	status, _ := p.AccessRequestProvision(mock.MockAccessRequest{
+		AppName:           "consumer",
+		InstanceDetails:   map[string]interface{}{common.AttrAPIID: "api-1"},
+		AccessRequestData: map[string]interface{}{AllowedIPsField: []interface{}{"10.0.0.0/24"}},
+	})
+
+	assert.Equal(t, prov.Error, status.GetStatus())
+	assert.Empty(t, gateway.applications)
+	assert.Contains(t, gateway.calls, coreapi.DELETE+" "+applicationsPath+"/app-1/apis")
+	assert.Contains(t, gateway.calls, coreapi.DELETE+" "+applicationsPath+"/app-1")
+}
+
+func TestAccessRequestProvisionKeepsExistingApplication(t *testing.T) {
+	gateway := newFakeGateway()
+	gateway.addApplication(webmethods.Application{Id: "existing", Name: "consumer"})
+	gateway.failOn(coreapi.POST, applicationsPath+"/existing/apis")
+	p := newTestProvisioner(t, gateway)
+
+	status, _ := p.AccessRequestProvision(mock.MockAccessRequest{
+		AppName:         "consumer",
+		InstanceDetails: map[string]interface{}{common.AttrAPIID: "api-1"},
+	})
+
+	assert.Equal(t, prov.Error, status.GetStatus())
+	assert.Contains(t, gateway.applications, "existing")
+}
+
+func TestAccessRequestProvisionSuccess(t *testing.T) {
+	gateway := newFakeGateway()
+	p := newTestProvisioner(t, gateway)
+
+	status, _ := p.AccessRequestProvision(mock.MockAccessRequest{
+		AppName:           "consumer",
+		InstanceDetails:   map[string]interface{}{common.AttrAPIID: "api-1"},
+		AccessRequestData: map[string]interface{}{AllowedHostsField: []interface{}{"consumer.example.com"}},
+	})
+
+	assert.Equal(t, prov.Success, status.GetStatus())
+	assert.Equal(t, "app-1", status.GetProperties()[common.AttrAppID])
+	app := gateway.applications["app-1"]
+	assert.Equal(t, []string{"api-1"}, app.ConsumingAPIs)
+	assert.Equal(t, HostnameIdentifier, app.Identifiers[0].Key)
+	assert.Equal(t, []string{"consumer.example.com"}, app.Identifiers[0].Value)
+}
+
+// fakeAccessRequestCache holds the provisioned access requests of the managed applications
+type fakeAccessRequestCache map[string][]*v1.ResourceInstance
+
+func (c fakeAccessRequestCache) GetAccessRequestsByApp(managedAppName string) []*v1.ResourceInstance {
+	return c[managedAppName]
+}
+
+func (c fakeAccessRequestCache) add(appName, id string, details map[string]string) {
+	ar := management.NewAccessRequest(id, "env")
+	agentDetails := map[string]interface{}{}
+	for key, value := range details {
+		agentDetails[key] = value
+	}
+	util.SetAgentDetails(ar, agentDetails)
+	ri, _ := ar.AsInstance()
+	ri.Metadata.ID = id
+	c[appName] = append(c[appName], ri)
+}
+
+func TestAccessRequestIdentifiersSharedByAccessRequests(t *testing.T) {
+	gateway := newFakeGateway()
+	gateway.addApplication(webmethods.Application{Id: "app", Name: "consumer", Identifiers: []webmethods.ApplicationIdentifier{
+		{Key: IPAddressRangeIdentifier, Name: IPAddressRangeIdentifier, Value: []string{"10.0.0.9"}},
+	}})
+	p := newTestProvisioner(t, gateway)
+	cache := fakeAccessRequestCache{}
+	p.accessRequests = cache
+	request := func(id, ip string, details map[string]string) mock.MockAccessRequest {
+		return mock.MockAccessRequest{
+			ID:                id,
+			AppName:           "consumer",
+			AppDetails:        map[string]string{common.AttrAppID: "app"},
+			Details:           details,
+			InstanceDetails:   map[string]interface{}{common.AttrAPIID: "api-" + id},
+			AccessRequestData: map[string]interface{}{AllowedIPsField: []interface{}{ip}},
+		}
+	}
+
+	status, _ := p.AccessRequestProvision(request("ar1", "10.0.0.1", nil))
+	assert.Equal(t, prov.Success, status.GetStatus())
+	ar1 := status.GetProperties()
+	assert.Equal(t, "10.0.0.1", ar1[common.AttrAllowedIPs])
+	cache.add("consumer", "ar1", ar1)
+
+	// the value was added by the first access request, the second one records it as well
+	status, _ = p.AccessRequestProvision(request("ar2", "10.0.0.1", nil))
+	assert.Equal(t, prov.Success, status.GetStatus())
+	ar2 := status.GetProperties()
+	assert.Equal(t, "10.0.0.1", ar2[common.AttrAllowedIPs])
+	cache.add("consumer", "ar2", ar2)
+
+	// a value set on the application out of band is never recorded
+	status, _ = p.AccessRequestProvision(request("ar3", "10.0.0.9", nil))
+	assert.Equal(t, prov.Success, status.GetStatus())
+	assert.Equal(t, "", status.GetProperties()[common.AttrAllowedIPs])
+	assert.Equal(t, []string{"10.0.0.9", "10.0.0.1"}, gateway.applications["app"].Identifiers[0].Value)
+
+	status = p.AccessRequestDeprovision(request("ar1", "10.0.0.1", ar1))
+	assert.Equal(t, prov.Success, status.GetStatus())
+	assert.Equal(t, []string{"10.0.0.9", "10.0.0.1"}, gateway.applications["app"].Identifiers[0].Value)
+
+	cache["consumer"] = cache["consumer"][1:]
+	status = p.AccessRequestDeprovision(request("ar2", "10.0.0.1", ar2))
+	assert.Equal(t, prov.Success, status.GetStatus())
+	assert.Equal(t, []string{"10.0.0.9"}, gateway.applications["app"].Identifiers[0].Value)
+}
+
+func TestAccessRequestUpdateKeepsRecordedIdentifiers(t *testing.T) {
+	gateway := newFakeGateway()
+	gateway.addApplication(webmethods.Application{Id: "app", Name: "consumer"})
+	p := newTestProvisioner(t, gateway)
+	req := mock.MockAccessRequest{
+		ID:                "ar1",
+		AppName:           "consumer",
+		AppDetails:        map[string]string{common.AttrAppID: "app"},
+		InstanceDetails:   map[string]interface{}{common.AttrAPIID: "api-1"},
+		AccessRequestData: map[string]interface{}{AllowedHostsField: []interface{}{"a.example.com"}},
+	}
+	status, _ := p.AccessRequestProvision(req)
+	assert.Equal(t, "a.example.com", status.GetProperties()[common.AttrAllowedHosts])
+
+	req.Details = status.GetProperties()
+	req.AccessRequestData = map[string]interface{}{AllowedHostsField: []interface{}{"a.example.com", "b.example.com"}}
+	status, _ = p.AccessRequestProvision(req)
+	assert.Equal(t, prov.Success, status.GetStatus())
+	assert.Equal(t, "b.example.com,a.example.com", status.GetProperties()[common.AttrAllowedHosts])
+
+	req.Details = status.GetProperties()
+	status = p.AccessRequestDeprovision(req)
+	assert.Equal(t, prov.Success, status.GetStatus())
+	assert.Empty(t, gateway.applications["app"].Identifiers)
+}
+
+func TestCredentialProvisionRollsBackStrategy(t *testing.T) {
+	gateway := newFakeGateway()
+	gateway.addApplication(webmethods.Application{Id: "app", Name: "consumer"})
+	gateway.failOn(coreapi.PUT, applicationsPath+"/app")
+	p := newTestProvisioner(t, gateway)
+
+	status, _ := p.CredentialProvision(mock.MockCredentialRequest{
+		AppName:     "consumer",
+		AppDetails:  map[string]string{common.AttrAppID: "app"},
+		CredDefName: "oauth2-local",
+		CredData:    map[string]interface{}{OauthServerField: "local"},
+	})
+
+	assert.Equal(t, prov.Error, status.GetStatus())
+	assert.Empty(t, gateway.strategies)
+	assert.Contains(t, gateway.calls, coreapi.DELETE+" "+strategiesPath+"/strategy-1")
+}
+
+func TestCredentialRequestsFailForUnknownCredentialType(t *testing.T) {
+	gateway := newFakeGateway()
+	gateway.addApplication(webmethods.Application{Id: "app", Name: "consumer"})
+	p := newTestProvisioner(t, gateway)
+	req := mock.MockCredentialRequest{
+		AppName:     "consumer",
+		AppDetails:  map[string]string{common.AttrAppID: "app"},
+		Details:     map[string]string{common.AttrAppID: "app"},
+		CredDefName: "basic-auth",
+	}
+
+	status, credential := p.CredentialProvision(req)
+	assert.Equal(t, prov.Error, status.GetStatus())
+	assert.Nil(t, credential)
+	assert.Equal(t, prov.Error, p.CredentialDeprovision(req).GetStatus())
+	assert.Contains(t, gateway.applications, "app")
+}
+
+func TestCredentialUpdateSuspendsAndEnablesAPIKey(t *testing.T) {
+	gateway := newFakeGateway()
+	app := webmethods.Application{Id: "app", Name: "consumer"}
+	app.AccessTokens.ApiAccessKeyCredentials.ApiAccessKey = "key"
+	gateway.addApplication(app)
+	p := newTestProvisioner(t, gateway)
+	req := mock.MockCredentialRequest{
+		AppName:     "consumer",
+		AppDetails:  map[string]string{common.AttrAppID: "app"},
+		CredDefName: prov.APIKeyCRD,
+		Action:      prov.Suspend,
+	}
+
+	status, credential := p.CredentialUpdate(req)
+	assert.Equal(t, prov.Success, status.GetStatus())
+	assert.Equal(t, "key", credential.GetData()[prov.APIKey])
+	assert.True(t, gateway.applications["app"].IsSuspended)
+
+	req.Action = prov.Enable
+	status, credential = p.CredentialUpdate(req)
+	assert.Equal(t, prov.Success, status.GetStatus())
+	assert.Equal(t, "key", credential.GetData()[prov.APIKey])
+	assert.False(t, gateway.applications["app"].IsSuspended)
+}
+
+func TestCredentialUpdateSuspendsAndEnablesOAuth(t *testing.T) {
+	gateway := newFakeGateway()
+	gateway.strategies["strategy"] = &webmethods.Strategy{
+		Id: "strategy",
+		ClientRegistration: webmethods.ClientRegistration{
+			ClientId:     "client",
+			ClientSecret: "secret",
+			Enabled:      true,
+		},
+	}
+	gateway.addApplication(webmethods.Application{Id: "app", Name: "consumer", AuthStrategyIds: []string{"strategy"}})
+	p := newTestProvisioner(t, gateway)
+	req := mock.MockCredentialRequest{
+		AppName:     "consumer",
+		AppDetails:  map[string]string{common.AttrAppID: "app"},
+		CredDefName: "oauth2-local",
+		Action:      prov.Suspend,
+	}
+
+	status, credential := p.CredentialUpdate(req)
+	assert.Equal(t, prov.Success, status.GetStatus())
+	assert.Equal(t, "client", credential.GetData()[prov.OauthClientID])
+	assert.False(t, gateway.strategies["strategy"].ClientRegistration.Enabled)
+
+	req.Action = prov.Enable
+	status, _ = p.CredentialUpdate(req)
+	assert.Equal(t, prov.Success, status.GetStatus())
+	assert.True(t, gateway.strategies["strategy"].ClientRegistration.Enabled)
+	assert.Equal(t, "secret", gateway.strategies["strategy"].ClientRegistration.ClientSecret)
+}
+
+func TestCredentialProvisionCreatesStrategyPerCredential(t *testing.T) {
+	gateway := newFakeGateway()
+	gateway.addApplication(webmethods.Application{Id: "app", Name: "consumer"})
+	p := newTestProvisioner(t, gateway)
+	provision := func(id string) prov.RequestStatus {
+		status, _ := p.CredentialProvision(mock.MockCredentialRequest{
+			ID:          id,
+			AppName:     "consumer",
+			AppDetails:  map[string]string{common.AttrAppID: "app"},
+			CredDefName: "oauth2-local",
+			CredData:    map[string]interface{}{OauthServerField: "local"},
+		})
+		return status
+	}
+
+	status := provision("11111111-aaaa")
+	assert.Equal(t, prov.Success, status.GetStatus())
+	assert.Equal(t, "strategy-1", status.GetProperties()[common.AttrStrategyID])
+	status = provision("22222222-bbbb")
+	assert.Equal(t, prov.Success, status.GetStatus())
+	assert.Equal(t, "strategy-2", status.GetProperties()[common.AttrStrategyID])
+	assert.Equal(t, "consumer-22222222", gateway.strategies["strategy-2"].Name)
+	assert.Equal(t, []string{"strategy-1", "strategy-2"}, gateway.applications["app"].AuthStrategyIds)
+
+	// a retried provisioning reuses the strategy of the credential
+	status = provision("11111111-aaaa")
+	assert.Equal(t, "strategy-1", status.GetProperties()[common.AttrStrategyID])
+	assert.Len(t, gateway.strategies, 2)
+}
+
+func TestCredentialDeprovisionRemovesOnlyItsStrategy(t *testing.T) {
+	gateway := newFakeGateway()
+	gateway.strategies["first"] = &webmethods.Strategy{Id: "first"}
+	gateway.strategies["second"] = &webmethods.Strategy{Id: "second"}
+	gateway.addApplication(webmethods.Application{Id: "app", Name: "consumer", AuthStrategyIds: []string{"first", "second"}})
+	p := newTestProvisioner(t, gateway)
+
+	status := p.CredentialDeprovision(mock.MockCredentialRequest{
+		AppName:     "consumer",
+		CredDefName: "oauth2-local",
+		Details:     map[string]string{common.AttrAppID: "app", common.AttrStrategyID: "second"},
+	})
+
+	assert.Equal(t, prov.Success, status.GetStatus())
+	assert.Contains(t, gateway.strategies, "first")
+	assert.NotContains(t, gateway.strategies, "second")
+	assert.Equal(t, []string{"first"}, gateway.applications["app"].AuthStrategyIds)
+}
+
+func TestCredentialDeprovisionKeepsReplacedAPIKey(t *testing.T) {
+	gateway := newFakeGateway()
+	app := webmethods.Application{Id: "app", Name: "consumer"}
+	app.AccessTokens.ApiAccessKeyCredentials.ApiAccessKey = "current"
+	gateway.addApplication(app)
+	p := newTestProvisioner(t, gateway)
+	req := mock.MockCredentialRequest{
+		AppName:     "consumer",
+		CredDefName: prov.APIKeyCRD,
+		Details:     map[string]string{common.AttrAppID: "app", common.AttrAPIKeyID: apiKeyID("previous")},
+	}
+
+	status := p.CredentialDeprovision(req)
+	assert.Equal(t, prov.Success, status.GetStatus())
+	assert.Equal(t, "current", gateway.applications["app"].AccessTokens.ApiAccessKeyCredentials.ApiAccessKey)
+
+	req.Details[common.AttrAPIKeyID] = apiKeyID("current")
+	status = p.CredentialDeprovision(req)
+	assert.Equal(t, prov.Success, status.GetStatus())
+	assert.Empty(t, gateway.applications["app"].AccessTokens.ApiAccessKeyCredentials.ApiAccessKey)
+}
+
+func TestCredentialUpdateRotatesOnlyItsAPIKey(t *testing.T) {
+	gateway := newFakeGateway()
+	app := webmethods.Application{Id: "app", Name: "consumer"}
+	app.AccessTokens.ApiAccessKeyCredentials.ApiAccessKey = "current"
+	gateway.addApplication(app)
+	p := newTestProvisioner(t, gateway)
+	req := mock.MockCredentialRequest{
+		AppName:     "consumer",
+		AppDetails:  map[string]string{common.AttrAppID: "app"},
+		CredDefName: prov.APIKeyCRD,
+		Details:     map[string]string{common.AttrAPIKeyID: apiKeyID("previous")},
+		Action:      prov.Rotate,
+	}
+
+	status, _ := p.CredentialUpdate(req)
+	assert.Equal(t, prov.Error, status.GetStatus())
+	assert.Equal(t, "current", gateway.applications["app"].AccessTokens.ApiAccessKeyCredentials.ApiAccessKey)
+
+	req.Details[common.AttrAPIKeyID] = apiKeyID("current")
+	status, credential := p.CredentialUpdate(req)
+	assert.Equal(t, prov.Success, status.GetStatus())
+	key := gateway.applications["app"].AccessTokens.ApiAccessKeyCredentials.ApiAccessKey
+	assert.Equal(t, "app-key-1", key)
+	assert.Equal(t, key, credential.GetData()[prov.APIKey])
+	assert.Equal(t, apiKeyID(key), status.GetProperties()[common.AttrAPIKeyID])
+}
+
+func TestAPIKeySharedByTwoCredentials(t *testing.T) {
+	gateway := newFakeGateway()
+	app := webmethods.Application{Id: "app", Name: "consumer"}
+	app.AccessTokens.ApiAccessKeyCredentials.ApiAccessKey = "shared"
+	gateway.addApplication(app)
+	first := newCredential("first", "consumer")
+	util.SetAgentDetailsKey(first, common.AttrAPIKeyID, apiKeyID("shared"))
+	second := newCredential("second", "consumer")
+	util.SetAgentDetailsKey(second, common.AttrAPIKeyID, apiKeyID("shared"))
+	central := &fakeCredentialClient{credentials: []v1.Interface{first, second}}
+	p := newTestProvisioner(t, gateway)
+	WithCredentialClient(central)(p)
+	req := mock.MockCredentialRequest{
+		Name:        "first",
+		AppName:     "consumer",
+		AppDetails:  map[string]string{common.AttrAppID: "app"},
+		CredDefName: prov.APIKeyCRD,
+		Details:     map[string]string{common.AttrAppID: "app", common.AttrAPIKeyID: apiKeyID("shared")},
+		Action:      prov.Rotate,
+	}
+
+	// rotating or removing the key of one credential would break the other one
+	status, _ := p.CredentialUpdate(req)
+	assert.Equal(t, prov.Error, status.GetStatus())
+	assert.Equal(t, prov.Success, p.CredentialDeprovision(req).GetStatus())
+	assert.Equal(t, "shared", gateway.applications["app"].AccessTokens.ApiAccessKeyCredentials.ApiAccessKey)
+
+	// the key is removed with the last credential using it
+	first.Metadata.State = v1.ResourceDeleting
+	req.Name = "second"
+	assert.Equal(t, prov.Success, p.CredentialDeprovision(req).GetStatus())
+	assert.Empty(t, gateway.applications["app"].AccessTokens.ApiAccessKeyCredentials.ApiAccessKey)
+}
+
+func TestConcurrentCredentialProvisionKeepsAllUpdates(t *testing.T) {
+	gateway := newFakeGateway()
+	gateway.addApplication(webmethods.Application{Id: "app", Name: "consumer"})
+	p := newTestProvisioner(t, gateway)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		origin := fmt.Sprintf("https://%d.example.com", i)
+		go func() {
+			defer wg.Done()
+			status, _ := p.CredentialProvision(mock.MockCredentialRequest{
+				AppName:     "consumer",
+				AppDetails:  map[string]string{common.AttrAppID: "app"},
+				CredDefName: prov.APIKeyCRD,
+				CredData:    map[string]interface{}{CorsField: []interface{}{origin}},
+			})
+			assert.Equal(t, prov.Success, status.GetStatus())
+		}()
+		id := fmt.Sprintf("%d0000000", i)
+		go func() {
+			defer wg.Done()
+			status, _ := p.CredentialProvision(mock.MockCredentialRequest{
+				ID:          id,
+				AppName:     "consumer",
+				AppDetails:  map[string]string{common.AttrAppID: "app"},
+				CredDefName: "oauth2-local",
+				CredData:    map[string]interface{}{OauthServerField: "local"},
+			})
+			assert.Equal(t, prov.Success, status.GetStatus())
+		}()
+	}
+	wg.Wait()
+
+	app := gateway.applications["app"]
+	assert.Len(t, app.JsOrigins, 10)
+	assert.Len(t, app.AuthStrategyIds, 10)
+}
+
+// addOrigin adds the origin to the application, returning false once it is there
+func addOrigin(origin string) func(application *webmethods.Application) bool {
+	return func(application *webmethods.Application) bool {
+		if slices.Contains(application.JsOrigins, origin) {
+			return false
+		}
+		application.JsOrigins = append(application.JsOrigins, origin)
+		return true
+	}
+}
+
+func TestUpdateApplicationReappliesOverwrittenChange(t *testing.T) {
+	gateway := newFakeGateway()
+	gateway.addApplication(webmethods.Application{Id: "app", Name: "consumer"})
+	reads := 0
+	gateway.onRead = func(id string) {
+		reads++
+		if reads == 3 {
+			// another client saves the application it read before the update, dropping the change
+			gateway.modify(id, func(application *webmethods.Application) {
+				application.JsOrigins = []string{"https://other.example.com"}
+			})
+		}
+	}
+
+	application, err := updateApplication(newTestProvisioner(t, gateway).client, "app", addOrigin("https://consumer.example.com"))
+
+	assert.Nil(t, err)
+	expected := []string{"https://other.example.com", "https://consumer.example.com"}
+	assert.Equal(t, expected, application.JsOrigins)
+	assert.Equal(t, expected, gateway.applications["app"].JsOrigins)
+	assert.Equal(t, 5, reads)
+}
+
+func TestUpdateApplicationDoesNotOverwriteConcurrentChange(t *testing.T) {
+	gateway := newFakeGateway()
+	gateway.addApplication(webmethods.Application{Id: "app", Name: "consumer"})
+	reads := 0
+	gateway.onRead = func(id string) {
+		reads++
+		if reads == 2 {
+			// another client saves the application between the read and the update
+			gateway.modify(id, func(application *webmethods.Application) {
+				application.JsOrigins = []string{"https://other.example.com"}
+			})
+		}
+	}
+
+	application, err := updateApplication(newTestProvisioner(t, gateway).client, "app", addOrigin("https://consumer.example.com"))
+
+	assert.Nil(t, err)
+	expected := []string{"https://other.example.com", "https://consumer.example.com"}
+	assert.Equal(t, expected, application.JsOrigins)
+	assert.Equal(t, expected, gateway.applications["app"].JsOrigins)
+	assert.Equal(t, 1, gateway.countCalls(coreapi.PUT+" "+applicationsPath+"/app"))
+
+	// the application keeps being modified, it is never saved
+	gateway.onRead = func(id string) {
+		gateway.modify(id, func(application *webmethods.Application) {})
+	}
+	_, err = updateApplication(newTestProvisioner(t, gateway).client, "app", addOrigin("https://late.example.com"))
+	assert.Equal(t, errApplicationConflict, err)
+	assert.Equal(t, 1, gateway.countCalls(coreapi.PUT+" "+applicationsPath+"/app"))
+}
+
+func TestUpdateApplicationGivesUpOnConflicts(t *testing.T) {
+	gateway := newFakeGateway()
+	gateway.addApplication(webmethods.Application{Id: "app", Name: "consumer"})
+	gateway.onRead = func(id string) {
+		gateway.applications[id].JsOrigins = nil
+	}
+
+	_, err := updateApplication(newTestProvisioner(t, gateway).client, "app", addOrigin("https://consumer.example.com"))
+
+	assert.Equal(t, errApplicationConflict, err)
+	assert.Equal(t, applicationUpdateAttempts, gateway.countCalls(coreapi.PUT+" "+applicationsPath+"/app"))
+}
+
+func TestUpdateApplicationSkipsUnchangedApplication(t *testing.T) {
+	gateway := newFakeGateway()
+	gateway.addApplication(webmethods.Application{Id: "app", Name: "consumer", JsOrigins: []string{"https://consumer.example.com"}})
+
+	_, err := updateApplication(newTestProvisioner(t, gateway).client, "app", addOrigin("https://consumer.example.com"))
+
+	assert.Nil(t, err)
+	assert.NotContains(t, gateway.calls, coreapi.PUT+" "+applicationsPath+"/app")
+}
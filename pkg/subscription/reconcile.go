@@ -0,0 +1,241 @@
+package subscription
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	v1 "github.com/Axway/agent-sdk/pkg/apic/apiserver/models/api/v1"
+	management "github.com/Axway/agent-sdk/pkg/apic/apiserver/models/management/v1alpha1"
+	prov "github.com/Axway/agent-sdk/pkg/apic/provisioning"
+	"github.com/Axway/agent-sdk/pkg/util"
+	hc "github.com/Axway/agent-sdk/pkg/util/healthcheck"
+	"github.com/Axway/agents-webmethods/pkg/common"
+	"github.com/Axway/agents-webmethods/pkg/webmethods"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
+)
+
+type cacheManager interface {
+	GetManagedApplicationCacheKeys() []string
+	GetManagedApplication(id string) *v1.ResourceInstance
+	ListAccessRequests() []*v1.ResourceInstance
+	GetAPIServiceInstanceByName(name string) (*v1.ResourceInstance, error)
+}
+
+type statusClient interface {
+	CreateSubResource(rm v1.ResourceMeta, subs map[string]interface{}) error
+}
+
+// Reconciler implements the jobs.Job interface. Compares the managed applications and access requests provisioned by
+// the agent with the webMethods applications, repairs drift where possible and flags the rest with a failed status.
+type Reconciler struct {
+	client       webmethods.Client
+	cacheManager cacheManager
+	central      statusClient
+	locks        *applicationLocks
+	log          logrus.FieldLogger
+}
+
+// NewReconciler creates the reconciliation job
+func NewReconciler(client webmethods.Client, cacheManager cacheManager, central statusClient, log logrus.FieldLogger) *Reconciler {
+	return &Reconciler{
+		client:       client,
+		cacheManager: cacheManager,
+		central:      central,
+		locks:        sharedLocks,
+		log:          log.WithField("component", "reconciler"),
+	}
+}
+
+// Ready determines if the job is ready to run.
+func (r *Reconciler) Ready() bool {
+	return r.client.Healthcheck("health").Result == hc.OK
+}
+
+// Status Performs a health check for this job before it is executed.
+func (r *Reconciler) Status() error {
+	status := r.client.Healthcheck("health")
+	if status.Result != hc.OK {
+		return errors.New(status.Details)
+	}
+	return nil
+}
+
+// Execute called by the sdk on each interval.
+func (r *Reconciler) Execute() error {
+	r.log.Debug("reconciling managed applications")
+	referenced := map[string]bool{}
+	known := map[string]webmethods.Application{}
+	for _, key := range r.cacheManager.GetManagedApplicationCacheKeys() {
+		ri := r.cacheManager.GetManagedApplication(key)
+		if ri == nil {
+			continue
+		}
+		appID, application := r.reconcileManagedApplication(ri)
+		if appID != "" {
+			referenced[appID] = true
+		}
+		if application != nil {
+			known[appID] = *application
+		}
+	}
+
+	for _, ri := range r.cacheManager.ListAccessRequests() {
+		// dedicated applications are referenced by their access request
+		if appID := r.reconcileAccessRequest(ri, known); appID != "" {
+			referenced[appID] = true
+		}
+	}
+
+	r.reportOrphans(referenced)
+	return nil
+}
+
+// reconcileManagedApplication checks that the webMethods application of a managed application still exists.
+// Returns the webMethods application id referenced by the managed application and the application when it was found.
+func (r *Reconciler) reconcileManagedApplication(ri *v1.ResourceInstance) (string, *webmethods.Application) {
+	app := &management.ManagedApplication{}
+	if err := app.FromInstance(ri); err != nil {
+		return "", nil
+	}
+	appID, _ := util.GetAgentDetailsValue(app, common.AttrAppID)
+	if appID == "" || !isProvisioned(app.Status) {
+		return appID, nil
+	}
+	logger := r.log.WithField("managedApplication", app.Name).WithField("appID", appID)
+	defer r.locks.lock(app.Name)()
+
+	applicationResponse, err := r.client.GetApplication(appID)
+	if err != nil {
+		logger.WithError(err).Error("unable to get application from Webmethods")
+		return appID, nil
+	}
+	if len(applicationResponse.Applications) == 0 {
+		logger.Warn("application no longer exists on Webmethods")
+		r.flag(app.ResourceMeta, app.Status, fmt.Sprintf("Webmethods application %s no longer exists", appID))
+		return appID, nil
+	}
+
+	application := applicationResponse.Applications[0]
+	r.removeDanglingStrategies(&application, logger)
+	return appID, &application
+}
+
+// removeDanglingStrategies drops references to strategies that were deleted out of band so they get recreated on the next credential request
+func (r *Reconciler) removeDanglingStrategies(application *webmethods.Application, logger logrus.FieldLogger) {
+	dangling := []string{}
+	for _, strategyId := range application.AuthStrategyIds {
+		_, err := r.client.GetStrategy(strategyId)
+		switch {
+		case errors.Is(err, webmethods.ErrStrategyNotFound):
+			logger.WithField("strategyID", strategyId).Warn("strategy no longer exists on Webmethods")
+			dangling = append(dangling, strategyId)
+		case err != nil:
+			// only a strategy reported as not found is removed, it is checked again on the next run
+			logger.WithField("strategyID", strategyId).WithError(err).Error("unable to get strategy from Webmethods")
+		}
+	}
+	if len(dangling) == 0 {
+		return
+	}
+	updated, err := updateApplication(r.client, application.Id, func(application *webmethods.Application) bool {
+		strategyIds := without(application.AuthStrategyIds, dangling)
+		changed := len(strategyIds) != len(application.AuthStrategyIds)
+		application.AuthStrategyIds = strategyIds
+		return changed
+	})
+	if err != nil {
+		logger.WithError(err).Error("unable to remove deleted strategies from the application")
+		return
+	}
+	*application = *updated
+	logger.Info("removed deleted strategies from the application")
+}
+
+// reconcileAccessRequest checks that the API of an access request is still associated to its webMethods application.
+// Returns the webMethods application id referenced by the access request.
+func (r *Reconciler) reconcileAccessRequest(ri *v1.ResourceInstance, known map[string]webmethods.Application) string {
+	ar := &management.AccessRequest{}
+	if err := ar.FromInstance(ri); err != nil || !isProvisioned(ar.Status) {
+		return ""
+	}
+	appID, _ := util.GetAgentDetailsValue(ar, common.AttrAppID)
+	if appID == "" {
+		return ""
+	}
+	logger := r.log.WithField("accessRequest", ar.Name).WithField("appID", appID)
+
+	application, ok := known[appID]
+	if !ok {
+		applicationResponse, err := r.client.GetApplication(appID)
+		if err != nil {
+			logger.WithError(err).Error("unable to get application from Webmethods")
+			return appID
+		}
+		if len(applicationResponse.Applications) == 0 {
+			r.flag(ar.ResourceMeta, ar.Status, fmt.Sprintf("Webmethods application %s no longer exists", appID))
+			return appID
+		}
+		application = applicationResponse.Applications[0]
+		known[appID] = application
+	}
+
+	if subscriptionID, _ := util.GetAgentDetailsValue(ar, common.AttrSubscriptionID); subscriptionID != "" {
+		// the API is consumed through a package subscription
+		return appID
+	}
+
+	instance, err := r.cacheManager.GetAPIServiceInstanceByName(ar.Spec.ApiServiceInstance)
+	if err != nil || instance == nil {
+		return appID
+	}
+	apiID, _ := util.GetAgentDetailsValue(instance, common.AttrAPIID)
+	if apiID == "" || slices.Contains(application.ConsumingAPIs, apiID) {
+		return appID
+	}
+
+	logger.WithField("apiID", apiID).Warn("API is no longer associated to the application, re-associating")
+	defer r.locks.lock(ar.Spec.ManagedApplication)()
+	err = r.client.SubscribeApplication(appID, &webmethods.ApplicationApiSubscription{ApiIDs: []string{apiID}})
+	if err != nil {
+		logger.WithError(err).Error("unable to re-associate API to the application")
+		r.flag(ar.ResourceMeta, ar.Status, fmt.Sprintf("API %s is no longer associated to Webmethods application %s", apiID, appID))
+	}
+	return appID
+}
+
+// reportOrphans logs the applications created by the agent that no managed application refers to
+func (r *Reconciler) reportOrphans(referenced map[string]bool) {
+	applicationResponse, err := r.client.ListApplications()
+	if err != nil {
+		r.log.WithError(err).Error("unable to list applications from Webmethods")
+		return
+	}
+	for _, application := range applicationResponse.Applications {
+		if referenced[application.Id] || !strings.HasPrefix(application.Description, applicationDescriptionPrefix) {
+			continue
+		}
+		r.log.
+			WithField("appName", application.Name).
+			WithField("appID", application.Id).
+			Warn("orphaned application created by the agent found on Webmethods")
+	}
+}
+
+// flag sets a failed status on the resource, keeping the existing status reasons
+func (r *Reconciler) flag(meta v1.ResourceMeta, current *v1.ResourceStatus, msg string) {
+	rs := prov.NewRequestStatusBuilder().SetMessage(msg)
+	if current != nil {
+		rs.SetCurrentStatusReasons(current.Reasons)
+	}
+	status := prov.NewStatusReason(rs.Failed())
+	err := r.central.CreateSubResource(meta, map[string]interface{}{"status": status})
+	if err != nil {
+		r.log.WithField("name", meta.Name).WithError(err).Error("unable to update status")
+	}
+}
+
+func isProvisioned(status *v1.ResourceStatus) bool {
+	return status != nil && status.Level == prov.Success.String()
+}
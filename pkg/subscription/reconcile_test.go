@@ -0,0 +1,152 @@
+package subscription
+
+import (
+	"testing"
+
+	coreapi "github.com/Axway/agent-sdk/pkg/api"
+	v1 "github.com/Axway/agent-sdk/pkg/apic/apiserver/models/api/v1"
+	management "github.com/Axway/agent-sdk/pkg/apic/apiserver/models/management/v1alpha1"
+	prov "github.com/Axway/agent-sdk/pkg/apic/provisioning"
+	"github.com/Axway/agent-sdk/pkg/util"
+	"github.com/Axway/agents-webmethods/pkg/common"
+	"github.com/Axway/agents-webmethods/pkg/config"
+	"github.com/Axway/agents-webmethods/pkg/webmethods"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeCacheManager struct {
+	managedApps    map[string]*v1.ResourceInstance
+	accessRequests []*v1.ResourceInstance
+	instances      map[string]*v1.ResourceInstance
+}
+
+func (m *fakeCacheManager) GetManagedApplicationCacheKeys() []string {
+	keys := []string{}
+	for key := range m.managedApps {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (m *fakeCacheManager) GetManagedApplication(id string) *v1.ResourceInstance {
+	return m.managedApps[id]
+}
+
+func (m *fakeCacheManager) ListAccessRequests() []*v1.ResourceInstance {
+	return m.accessRequests
+}
+
+func (m *fakeCacheManager) GetAPIServiceInstanceByName(name string) (*v1.ResourceInstance, error) {
+	return m.instances[name], nil
+}
+
+type fakeStatusClient struct {
+	statuses map[string]*v1.ResourceStatus
+}
+
+func (c *fakeStatusClient) CreateSubResource(rm v1.ResourceMeta, subs map[string]interface{}) error {
+	c.statuses[rm.Name] = subs["status"].(*v1.ResourceStatus)
+	return nil
+}
+
+func newManagedApplicationInstance(name, appID string) *v1.ResourceInstance {
+	app := management.NewManagedApplication(name, "env")
+	app.Status = &v1.ResourceStatus{Level: prov.Success.String()}
+	util.SetAgentDetails(app, map[string]interface{}{common.AttrAppID: appID})
+	ri, _ := app.AsInstance()
+	return ri
+}
+
+func newAccessRequestInstance(name, appID, instanceName string) *v1.ResourceInstance {
+	ar := management.NewAccessRequest(name, "env")
+	ar.Spec.ApiServiceInstance = instanceName
+	ar.Status = &v1.ResourceStatus{Level: prov.Success.String()}
+	util.SetAgentDetails(ar, map[string]interface{}{common.AttrAppID: appID})
+	ri, _ := ar.AsInstance()
+	return ri
+}
+
+func newServiceInstance(name, apiID string) *v1.ResourceInstance {
+	instance := management.NewAPIServiceInstance(name, "env")
+	util.SetAgentDetails(instance, map[string]interface{}{common.AttrAPIID: apiID})
+	ri, _ := instance.AsInstance()
+	return ri
+}
+
+func newTestReconciler(t *testing.T, gateway *fakeGateway, cm *fakeCacheManager) (*Reconciler, *fakeStatusClient) {
+	mc := &webmethods.MockClient{SendFunc: gateway.send}
+	client, err := webmethods.NewClient(&config.WebMethodConfig{}, mc)
+	assert.Nil(t, err)
+	central := &fakeStatusClient{statuses: map[string]*v1.ResourceStatus{}}
+	return NewReconciler(client, cm, central, logrus.New()), central
+}
+
+func TestReconcileFlagsDeletedApplication(t *testing.T) {
+	gateway := newFakeGateway()
+	cm := &fakeCacheManager{
+		managedApps: map[string]*v1.ResourceInstance{
+			"deleted": newManagedApplicationInstance("deleted", "app-deleted"),
+		},
+		accessRequests: []*v1.ResourceInstance{
+			newAccessRequestInstance("deleted-access", "app-deleted", "petstore"),
+		},
+	}
+	r, central := newTestReconciler(t, gateway, cm)
+
+	assert.Nil(t, r.Execute())
+	assert.Equal(t, prov.Error.String(), central.statuses["deleted"].Level)
+	assert.Equal(t, prov.Error.String(), central.statuses["deleted-access"].Level)
+}
+
+func TestReconcileRepairsAPIAssociation(t *testing.T) {
+	gateway := newFakeGateway()
+	gateway.addApplication(webmethods.Application{Id: "app", Name: "consumer", Description: applicationDescriptionPrefix + "consumer"})
+	cm := &fakeCacheManager{
+		managedApps: map[string]*v1.ResourceInstance{
+			"consumer": newManagedApplicationInstance("consumer", "app"),
+		},
+		accessRequests: []*v1.ResourceInstance{
+			newAccessRequestInstance("consumer-access", "app", "petstore"),
+		},
+		instances: map[string]*v1.ResourceInstance{
+			"petstore": newServiceInstance("petstore", "api-1"),
+		},
+	}
+	r, central := newTestReconciler(t, gateway, cm)
+
+	assert.Nil(t, r.Execute())
+	assert.Empty(t, central.statuses)
+	assert.Equal(t, []string{"api-1"}, gateway.applications["app"].ConsumingAPIs)
+}
+
+func TestReconcileRemovesDeletedStrategies(t *testing.T) {
+	gateway := newFakeGateway()
+	gateway.addApplication(webmethods.Application{Id: "app", Name: "consumer", AuthStrategyIds: []string{"deleted-strategy"}})
+	cm := &fakeCacheManager{
+		managedApps: map[string]*v1.ResourceInstance{
+			"consumer": newManagedApplicationInstance("consumer", "app"),
+		},
+	}
+	r, _ := newTestReconciler(t, gateway, cm)
+
+	assert.Nil(t, r.Execute())
+	assert.Empty(t, gateway.applications["app"].AuthStrategyIds)
+	assert.Contains(t, gateway.calls, coreapi.PUT+" "+applicationsPath+"/app")
+}
+
+func TestReconcileKeepsStrategiesThatCanNotBeRead(t *testing.T) {
+	gateway := newFakeGateway()
+	gateway.addApplication(webmethods.Application{Id: "app", Name: "consumer", AuthStrategyIds: []string{"strategy"}})
+	gateway.failOn(coreapi.GET, strategiesPath)
+	cm := &fakeCacheManager{
+		managedApps: map[string]*v1.ResourceInstance{
+			"consumer": newManagedApplicationInstance("consumer", "app"),
+		},
+	}
+	r, _ := newTestReconciler(t, gateway, cm)
+
+	assert.Nil(t, r.Execute())
+	assert.Equal(t, []string{"strategy"}, gateway.applications["app"].AuthStrategyIds)
+	assert.NotContains(t, gateway.calls, coreapi.PUT+" "+applicationsPath+"/app")
+}
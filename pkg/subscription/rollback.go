@@ -0,0 +1,43 @@
+package subscription
+
+import (
+	"github.com/sirupsen/logrus"
+)
+
+// rollback records the steps a provisioning flow completed on webMethods so they can be undone on a later failure
+type rollback struct {
+	log   logrus.FieldLogger
+	steps []rollbackStep
+}
+
+type rollbackStep struct {
+	name string
+	undo func() error
+}
+
+func newRollback(log logrus.FieldLogger) *rollback {
+	return &rollback{
+		log:   log,
+		steps: []rollbackStep{},
+	}
+}
+
+// add records a completed step along with the function that reverts it
+func (r *rollback) add(name string, undo func() error) {
+	r.steps = append(r.steps, rollbackStep{
+		name: name,
+		undo: undo,
+	})
+}
+
+// run reverts the recorded steps in reverse order. Failures are logged and do not stop the remaining steps.
+func (r *rollback) run() {
+	for i := len(r.steps) - 1; i >= 0; i-- {
+		step := r.steps[i]
+		r.log.WithField("step", step.name).Info("rolling back provisioning step")
+		if err := step.undo(); err != nil {
+			r.log.WithField("step", step.name).WithError(err).Error("failed to roll back provisioning step")
+		}
+	}
+	r.steps = []rollbackStep{}
+}
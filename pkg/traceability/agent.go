@@ -13,7 +13,6 @@ import (
 	"github.com/elastic/beats/v7/libbeat/common"
 
 	coreagent "github.com/Axway/agent-sdk/pkg/agent"
-	coreapi "github.com/Axway/agent-sdk/pkg/api"
 	"github.com/Axway/agent-sdk/pkg/transaction"
 	"github.com/Axway/agent-sdk/pkg/util/errors"
 	agenterrors "github.com/Axway/agent-sdk/pkg/util/errors"
@@ -28,6 +27,7 @@ type Agent struct {
 	doneCh         chan struct{}
 	eventChannel   chan WebmethodsEvent
 	eventProcessor Processor
+	flushInterval  time.Duration
 	webmethods     Emitter
 }
 
@@ -37,8 +37,14 @@ func New(b *beat.Beat, cfg *common.Config) (beat.Beater, error) {
 	if agentCfg == nil {
 		return nil, localerrors.ErrConfigFile
 	}
+	if err := validateEventTypes(agentCfg.WebMethodConfig.EventTypes); err != nil {
+		return nil, err
+	}
 	generator := transaction.NewEventGenerator()
-	httpClient := coreapi.NewClient(agentCfg.WebMethodConfig.TLS, agentCfg.WebMethodConfig.ProxyURL)
+	httpClient, err := webmethods.NewStreamingClient(agentCfg.WebMethodConfig.TLS, agentCfg.WebMethodConfig.ProxyURL)
+	if err != nil {
+		return nil, err
+	}
 	client, err := webmethods.NewClient(agentCfg.WebMethodConfig, httpClient)
 	if err != nil {
 		return nil, err
@@ -51,25 +57,36 @@ func New(b *beat.Beat, cfg *common.Config) (beat.Beater, error) {
 			return nil, errors.Newf(4001, "invalid timestamp %s")
 		}
 	}
-	processor := NewApiEventProcessor(agentCfg, generator)
+	eventProcessor, err := NewApiEventProcessor(agentCfg, generator, client)
+	if err != nil {
+		return nil, err
+	}
+	var processor Processor = eventProcessor
+	if agentCfg.WebMethodConfig.TransactionsMode == config.TransactionsModeMetrics {
+		// the metrics are aggregated from all the transactions, not from the sampled events
+		generator.SetUseTrafficForAggregation(false)
+		processor = NewMetricsProcessor(eventProcessor, agentCfg.WebMethodConfig.MetricsBucketSize, agentCfg.WebMethodConfig.MetricsSampleRate)
+	}
 	eventChannel := make(chan WebmethodsEvent)
 	emitter := NewWebmethodsEventEmitter(*agentCfg, eventChannel, client, *timezoneLocation)
 	emitterJob, err := NewMuleEventEmitterJob(emitter, agentCfg.WebMethodConfig.PollInterval, client)
 	if err != nil {
 		return nil, err
 	}
-	return newAgent(processor, emitterJob, eventChannel)
+	return newAgent(processor, emitterJob, eventChannel, agentCfg.WebMethodConfig.MetricsBucketSize)
 }
 
 func newAgent(
 	processor Processor,
 	emitter Emitter,
 	eventChannel chan WebmethodsEvent,
+	flushInterval time.Duration,
 ) (*Agent, error) {
 	a := &Agent{
 		doneCh:         make(chan struct{}),
 		eventChannel:   eventChannel,
 		eventProcessor: processor,
+		flushInterval:  flushInterval,
 		webmethods:     emitter,
 	}
 
@@ -102,12 +119,25 @@ func (a *Agent) Run(b *beat.Beat) error {
 	gracefulStop := make(chan os.Signal, 1)
 	signal.Notify(gracefulStop, syscall.SIGTERM, os.Interrupt)
 
+	// the processors aggregating the transactions report them at every flush interval
+	var flush <-chan time.Time
+	flusher, isFlusher := a.eventProcessor.(Flusher)
+	if isFlusher {
+		ticker := time.NewTicker(a.flushInterval)
+		defer ticker.Stop()
+		flush = ticker.C
+	}
+
 	for {
 		select {
 		case <-a.doneCh:
+			a.flush(flusher)
 			return a.client.Close()
 		case <-gracefulStop:
+			a.flush(flusher)
 			return a.client.Close()
+		case <-flush:
+			flusher.Flush()
 		case event := <-a.eventChannel:
 			eventsToPublish := a.eventProcessor.ProcessRaw(event)
 			a.client.PublishAll(eventsToPublish)
@@ -115,6 +145,12 @@ func (a *Agent) Run(b *beat.Beat) error {
 	}
 }
 
+func (a *Agent) flush(flusher Flusher) {
+	if flusher != nil {
+		flusher.Flush()
+	}
+}
+
 // onConfigChange apply configuration changes
 func (a *Agent) onConfigChange() {
 	cfg := config.GetConfig()
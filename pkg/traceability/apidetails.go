@@ -0,0 +1,141 @@
+package traceability
+
+import (
+	"time"
+
+	management "github.com/Axway/agent-sdk/pkg/apic/apiserver/models/management/v1alpha1"
+	defs "github.com/Axway/agent-sdk/pkg/apic/definitions"
+	"github.com/Axway/agent-sdk/pkg/util"
+	"github.com/Axway/agents-webmethods/pkg/webmethods"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// maxAPIDetails is the number of APIs whose details are kept
+	maxAPIDetails = 1000
+	// apiDetailsTTL is the time the details of an API are kept before being fetched again
+	apiDetailsTTL = 10 * time.Minute
+)
+
+type apiDetailsClient interface {
+	GetApiDetails(id string) (*webmethods.ApiResponse, error)
+}
+
+type revisionClient interface {
+	GetAPIRevisionByName(name string) (*management.APIServiceRevision, error)
+	GetAPIRevisions(query map[string]string, stage string) ([]*management.APIServiceRevision, error)
+}
+
+// apiDetails are the version, stage and revision reported for the transactions of an API
+type apiDetails struct {
+	version  string
+	stage    string
+	revision int
+}
+
+// apiDetailsCache resolves the details of the APIs of the transactions. The details are fetched from the gateway,
+// the stage and version of the service instance discovered for the API take precedence so the transactions match
+// the access requests of the instance when they are set. The revision is the number of the Central revision of the
+// instance.
+type apiDetailsCache struct {
+	client       apiDetailsClient
+	cacheManager cacheManager
+	revisions    revisionClient
+	defaultStage string
+	details      *boundedCache[apiDetails]
+}
+
+func newAPIDetailsCache(client apiDetailsClient, cacheManager cacheManager, revisions revisionClient, defaultStage string, maxEntries int) *apiDetailsCache {
+	return &apiDetailsCache{
+		client:       client,
+		cacheManager: cacheManager,
+		revisions:    revisions,
+		defaultStage: defaultStage,
+		details:      newBoundedCache[apiDetails](maxEntries),
+	}
+}
+
+// resolve returns the details of the API, the configured maturity state and revision 1 when they are not found
+func (c *apiDetailsCache) resolve(apiID string) apiDetails {
+	if details, ok := c.details.get(apiID); ok {
+		return details
+	}
+
+	details := apiDetails{stage: c.defaultStage, revision: 1}
+	ttl := apiDetailsTTL
+	apiResponse, err := c.client.GetApiDetails(apiID)
+	if err != nil {
+		logrus.WithError(err).WithField("apiId", apiID).Warn("Unable to get the API details, using the configured maturity state")
+		ttl = unresolvedTTL
+	} else {
+		details.version = apiResponse.Api.ApiVersion
+		if apiResponse.Api.MaturityState != "" {
+			details.stage = apiResponse.Api.MaturityState
+		}
+	}
+	if instance := c.findInstance(apiID); instance != nil {
+		if stage, _ := util.GetAgentDetailsValue(instance, defs.AttrExternalAPIStage); stage != "" {
+			details.stage = stage
+		}
+		if version, _ := util.GetAgentDetailsValue(instance, defs.AttrExternalAPIVersion); version != "" {
+			details.version = version
+		}
+		if revision := c.revisionOf(instance); revision > 0 {
+			details.revision = revision
+		}
+	}
+	c.details.set(apiID, details, ttl)
+	return details
+}
+
+// findInstance returns the service instance discovered for the API
+func (c *apiDetailsCache) findInstance(apiID string) *management.APIServiceInstance {
+	for _, key := range c.cacheManager.GetAPIServiceInstanceKeys() {
+		ri, err := c.cacheManager.GetAPIServiceInstanceByID(key)
+		if err != nil || ri == nil {
+			continue
+		}
+		if id, _ := util.GetAgentDetailsValue(ri, defs.AttrExternalAPIID); id != apiID {
+			continue
+		}
+		instance := management.NewAPIServiceInstance("", "")
+		if err := instance.FromInstance(ri); err != nil {
+			continue
+		}
+		return instance
+	}
+	return nil
+}
+
+// revisionOf returns the number of the Central revision of the instance, the revisions of its service being numbered
+// in their creation order, 0 when it is unknown
+func (c *apiDetailsCache) revisionOf(instance *management.APIServiceInstance) int {
+	if c.revisions == nil || instance.Spec.ApiServiceRevision == "" {
+		return 0
+	}
+	logger := logrus.WithField("revision", instance.Spec.ApiServiceRevision)
+	revision, err := c.revisions.GetAPIRevisionByName(instance.Spec.ApiServiceRevision)
+	if err != nil || revision == nil || revision.Spec.ApiService == "" {
+		logger.WithError(err).Debug("Unable to get the revision of the service instance")
+		return 0
+	}
+	revisions, err := c.revisions.GetAPIRevisions(map[string]string{"query": "metadata.references.name==" + revision.Spec.ApiService}, "")
+	if err != nil {
+		logger.WithError(err).Debug("Unable to get the revisions of the service")
+		return 0
+	}
+	created := time.Time(revision.Metadata.Audit.CreateTimestamp)
+	number, found := 0, false
+	for _, r := range revisions {
+		if r.Name == revision.Name {
+			found = true
+		} else if time.Time(r.Metadata.Audit.CreateTimestamp).After(created) {
+			continue
+		}
+		number++
+	}
+	if !found {
+		number++
+	}
+	return number
+}
@@ -0,0 +1,134 @@
+package traceability
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	v1 "github.com/Axway/agent-sdk/pkg/apic/apiserver/models/api/v1"
+	management "github.com/Axway/agent-sdk/pkg/apic/apiserver/models/management/v1alpha1"
+	defs "github.com/Axway/agent-sdk/pkg/apic/definitions"
+	"github.com/Axway/agent-sdk/pkg/util"
+	"github.com/Axway/agents-webmethods/pkg/config"
+	"github.com/Axway/agents-webmethods/pkg/webmethods"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeAPIDetailsClient struct {
+	apis  map[string]webmethods.Api
+	calls int
+}
+
+func (c *fakeAPIDetailsClient) GetApiDetails(id string) (*webmethods.ApiResponse, error) {
+	c.calls++
+	api, ok := c.apis[id]
+	if !ok {
+		return nil, fmt.Errorf("api %s not found", id)
+	}
+	return &webmethods.ApiResponse{Api: api}, nil
+}
+
+type fakeRevisionClient map[string]*management.APIServiceRevision
+
+func (c fakeRevisionClient) GetAPIRevisionByName(name string) (*management.APIServiceRevision, error) {
+	revision, ok := c[name]
+	if !ok {
+		return nil, fmt.Errorf("revision %s not found", name)
+	}
+	return revision, nil
+}
+
+func (c fakeRevisionClient) GetAPIRevisions(query map[string]string, stage string) ([]*management.APIServiceRevision, error) {
+	revisions := []*management.APIServiceRevision{}
+	for _, revision := range c {
+		if query["query"] == "metadata.references.name=="+revision.Spec.ApiService {
+			revisions = append(revisions, revision)
+		}
+	}
+	return revisions, nil
+}
+
+// add adds the revisions of the service, created in the given order
+func (c fakeRevisionClient) add(service string, names ...string) fakeRevisionClient {
+	created := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	for i, name := range names {
+		revision := management.NewAPIServiceRevision(name, "env")
+		// the titles are not used, they can be customized
+		revision.Title = fmt.Sprintf("%s - r %d", service, 10+i)
+		revision.Spec.ApiService = service
+		revision.Metadata.Audit.CreateTimestamp = v1.Time(created.Add(time.Duration(i) * time.Hour))
+		c[name] = revision
+	}
+	return c
+}
+
+func newServiceInstance(name, apiID, stage, version string) *v1.ResourceInstance {
+	instance := management.NewAPIServiceInstance(name, "env")
+	instance.Spec.ApiServiceRevision = name + "-revision"
+	details := map[string]interface{}{defs.AttrExternalAPIID: apiID, defs.AttrExternalAPIStage: stage}
+	if version != "" {
+		details[defs.AttrExternalAPIVersion] = version
+	}
+	util.SetAgentDetails(instance, details)
+	ri, _ := instance.AsInstance()
+	return ri
+}
+
+func TestAPIDetailsFromGateway(t *testing.T) {
+	client := &fakeAPIDetailsClient{apis: map[string]webmethods.Api{
+		"api1": {ApiVersion: "1.0", MaturityState: "Beta", SystemVersion: 3},
+		"api2": {ApiVersion: "2.0"},
+	}}
+	c := newAPIDetailsCache(client, &fakeCacheManager{}, fakeRevisionClient{}, "Production", 10)
+
+	// the revision of the gateway is not the Central revision
+	assert.Equal(t, apiDetails{version: "1.0", stage: "Beta", revision: 1}, c.resolve("api1"))
+	assert.Equal(t, apiDetails{version: "1.0", stage: "Beta", revision: 1}, c.resolve("api1"))
+	assert.Equal(t, 1, client.calls)
+	assert.Equal(t, apiDetails{version: "2.0", stage: "Production", revision: 1}, c.resolve("api2"))
+	assert.Equal(t, apiDetails{stage: "Production", revision: 1}, c.resolve("unknown"))
+}
+
+func TestAPIDetailsFromServiceInstance(t *testing.T) {
+	client := &fakeAPIDetailsClient{apis: map[string]webmethods.Api{
+		"api1": {ApiVersion: "1.0", MaturityState: "Beta", SystemVersion: 2},
+		"api2": {ApiVersion: "2.0", MaturityState: "Beta"},
+		"api3": {ApiVersion: "3.0", MaturityState: "Beta"},
+	}}
+	cacheManager := &fakeCacheManager{instances: map[string]*v1.ResourceInstance{
+		"inst1": newServiceInstance("inst1", "api1", "Beta", "1.0"),
+		// published before the version was set on the instances
+		"inst2": newServiceInstance("inst2", "api2", "Beta", ""),
+		"inst3": newServiceInstance("inst3", "api3", "", "3.1"),
+	}}
+	revisions := fakeRevisionClient{}.
+		add("pets", "pets-1", "pets-2", "pets-3", "inst1-revision", "pets-5").
+		add("orders", "inst2-revision")
+	c := newAPIDetailsCache(client, cacheManager, revisions, "Production", 10)
+
+	assert.Equal(t, apiDetails{version: "1.0", stage: "Beta", revision: 4}, c.resolve("api1"))
+	// the version of the gateway is kept when the instance has none
+	assert.Equal(t, apiDetails{version: "2.0", stage: "Beta", revision: 1}, c.resolve("api2"))
+	// the stage of the gateway is kept when the instance has none, the revision is unknown
+	assert.Equal(t, apiDetails{version: "3.1", stage: "Beta", revision: 1}, c.resolve("api3"))
+}
+
+func TestSummaryUsesAPIDetails(t *testing.T) {
+	setupShowAllRedaction(t)
+	aep := newTestProcessor(&config.WebMethodConfig{})
+	aep.apis.client = &fakeAPIDetailsClient{apis: map[string]webmethods.Api{
+		"api": {ApiVersion: "1.0", MaturityState: "Beta", SystemVersion: 3},
+	}}
+	aep.cacheManager.(*fakeCacheManager).instances = map[string]*v1.ResourceInstance{
+		"inst": newServiceInstance("inst", "api", "Beta", "1.0"),
+	}
+	aep.apis.revisions = fakeRevisionClient{}.add("pets", "pets-1", "inst-revision")
+	event := WebmethodsEvent{ResponseCode: "200", HTTPMethod: "GET", OperationName: "/pets", ApiId: "api", ApiName: "pets"}
+
+	summary, err := aep.createSummaryEvent(0, "session", event, "team", false)
+	assert.Nil(t, err)
+	assert.Equal(t, "remoteApiId_api", summary.TransactionSummary.Proxy.ID)
+	assert.Equal(t, "Beta", summary.TransactionSummary.Proxy.Stage)
+	assert.Equal(t, "1.0", summary.TransactionSummary.Proxy.Version)
+	assert.Equal(t, 2, summary.TransactionSummary.Proxy.Revision)
+}
@@ -13,8 +13,6 @@ import (
 	v1 "github.com/Axway/agent-sdk/pkg/apic/apiserver/models/api/v1"
 	"github.com/Axway/agent-sdk/pkg/transaction"
 	transutil "github.com/Axway/agent-sdk/pkg/transaction/util"
-	"github.com/Axway/agent-sdk/pkg/util"
-	"github.com/Axway/agent-sdk/pkg/util/log"
 	"github.com/Axway/agents-webmethods/pkg/config"
 	"github.com/sirupsen/logrus"
 
@@ -28,9 +26,19 @@ type Processor interface {
 	ProcessRaw(webmethodsEvent WebmethodsEvent) []beat.Event
 }
 
+// Flusher is implemented by the processors aggregating the transactions, Flush reports what was aggregated
+type Flusher interface {
+	Flush()
+}
+
 type cacheManager interface {
 	GetManagedApplicationCacheKeys() []string
 	GetManagedApplication(id string) *v1.ResourceInstance
+	GetManagedApplicationByName(name string) *v1.ResourceInstance
+	ListAccessRequests() []*v1.ResourceInstance
+	GetAccessRequestsByApp(managedAppName string) []*v1.ResourceInstance
+	GetAPIServiceInstanceKeys() []string
+	GetAPIServiceInstanceByID(id string) (*v1.ResourceInstance, error)
 }
 
 // ApiEventProcessor  - represents the processor for received event for Amplify Central
@@ -46,26 +54,58 @@ type ApiEventProcessor struct {
 	cfg            *config.AgentConfig
 	eventGenerator transaction.EventGenerator
 	cacheManager   cacheManager
-	appIDToManApp  map[string]string
+	managedApps    *managedAppCache
+	apis           *apiDetailsCache
+	headers        *headerFilter
+	redactor       *redactor
+	transactions   *boundedCache[bool]
 }
 
 func NewApiEventProcessor(
 	gateway *config.AgentConfig,
 	eventGenerator transaction.EventGenerator,
-) *ApiEventProcessor {
+	client apiDetailsClient,
+) (*ApiEventProcessor, error) {
+	redactor, err := newRedactor(gateway.WebMethodConfig)
+	if err != nil {
+		return nil, err
+	}
+	cacheManager := agent.GetCacheManager()
 	ep := &ApiEventProcessor{
 		cfg:            gateway,
 		eventGenerator: eventGenerator,
-		cacheManager:   agent.GetCacheManager(),
-		appIDToManApp:  make(map[string]string),
+		cacheManager:   cacheManager,
+		managedApps:    newManagedAppCache(cacheManager, maxManagedApps),
+		apis:           newAPIDetailsCache(client, cacheManager, agent.GetCentralClient(), gateway.WebMethodConfig.MaturityState, maxAPIDetails),
+		headers:        newHeaderFilter(gateway.WebMethodConfig.HeadersAllow, gateway.WebMethodConfig.HeadersDeny),
+		redactor:       redactor,
+		transactions:   newBoundedCache[bool](maxReportedTransactions),
 	}
-	return ep
+	return ep, nil
 }
 
 // ProcessRaw - process the received log entry and returns the event to be published to Amplifyingestion service
 func (aep *ApiEventProcessor) ProcessRaw(webmethodsEvent WebmethodsEvent) []beat.Event {
 
-	logrus.Infof("%+v\n", webmethodsEvent)
+	webmethodsEvent = aep.redactor.redact(webmethodsEvent)
+	logrus.Tracef("%+v", webmethodsEvent)
+	switch kind := kindOf(webmethodsEvent.EventType); kind {
+	case lifecycleEvent:
+		// lifecycle events describe the gateway, not a transaction
+		logrus.
+			WithField("eventType", webmethodsEvent.EventType).
+			WithField("serverID", webmethodsEvent.ServerID).
+			WithField("gatewayNode", webmethodsEvent.SourceGatewayNode).
+			Info("Webmethods API Gateway lifecycle event")
+		return nil
+	case errorEvent, policyViolationEvent:
+		if aep.isReportedByTransaction(webmethodsEvent, kind) {
+			return nil
+		}
+		webmethodsEvent = webmethodsEvent.asFailedTransaction(kind)
+	default:
+		aep.isReportedByTransaction(webmethodsEvent, kind)
+	}
 	summaryEvent, logEvents, err := aep.processMapping(webmethodsEvent)
 	if err != nil {
 		logrus.Error(err.Error())
@@ -80,6 +120,27 @@ func (aep *ApiEventProcessor) ProcessRaw(webmethodsEvent WebmethodsEvent) []beat
 	return events
 }
 
+// isReportedByTransaction remembers the requests of the transactional events and returns true for an error or policy
+// violation event of a request whose transactional event was already reported, so the request is counted once
+func (aep *ApiEventProcessor) isReportedByTransaction(webmethodsEvent WebmethodsEvent, kind eventKind) bool {
+	requestID := webmethodsEvent.requestID()
+	if requestID == "" {
+		return false
+	}
+	if kind == transactionalEvent {
+		aep.transactions.set(requestID, true, reportedTransactionTTL)
+		return false
+	}
+	if _, ok := aep.transactions.get(requestID); ok {
+		logrus.
+			WithField("eventType", webmethodsEvent.EventType).
+			WithField("requestId", requestID).
+			Debug("the request was reported by its transactional event, the event is skipped")
+		return true
+	}
+	return false
+}
+
 func (aep *ApiEventProcessor) processMapping(webmethodsEvent WebmethodsEvent) (*transaction.LogEvent, []transaction.LogEvent, error) {
 	centralCfg := agent.GetCentralConfig()
 
@@ -89,33 +150,23 @@ func (aep *ApiEventProcessor) processMapping(webmethodsEvent WebmethodsEvent) (*
 	txID := webmethodsEvent.SessionId
 	txEventID := webmethodsEvent.CorrelationID
 	leg0ID := FormatLeg0(txEventID)
-	leg1ID := FormatLeg1(txEventID)
 
 	transInboundLogEventLeg, err := aep.createTransactionEvent(eventTime, txID, webmethodsEvent, leg0ID, "", "Inbound")
 	if err != nil {
 		return nil, nil, err
 
 	}
-	transOutboundLogEventLeg, err := aep.createOutboundTransactionEvent(txID, webmethodsEvent, leg1ID, leg0ID, "Outbound")
+	transOutboundLogEventLegs, calloutFailed, err := aep.createOutboundTransactionEvents(txID, webmethodsEvent, leg0ID)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	transSummaryLogEvent, err := aep.createSummaryEvent(eventTime, txID, webmethodsEvent, centralCfg.GetTeamID())
+	transSummaryLogEvent, err := aep.createSummaryEvent(eventTime, txID, webmethodsEvent, centralCfg.GetTeamID(), calloutFailed)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	if transOutboundLogEventLeg == nil {
-		return transSummaryLogEvent, []transaction.LogEvent{
-			*transInboundLogEventLeg,
-		}, nil
-	}
-
-	return transSummaryLogEvent, []transaction.LogEvent{
-		*transOutboundLogEventLeg,
-		*transInboundLogEventLeg,
-	}, nil
+	return transSummaryLogEvent, append(transOutboundLogEventLegs, *transInboundLogEventLeg), nil
 }
 
 func (aep *ApiEventProcessor) getTransactionEventStatus(code int) transaction.TxEventStatus {
@@ -138,17 +189,14 @@ func (aep *ApiEventProcessor) getTransactionSummaryStatus(statusCode int) transa
 }
 
 func (aep *ApiEventProcessor) createTransactionEvent(eventTime int64, txID string, webmethodsEvent WebmethodsEvent, eventID, parentId, direction string) (*transaction.LogEvent, error) {
-
-	// req := map[string]string{
-	// 	"User-AgentName": "test",
-	// 	"Request-ID":     eventID,
-	// }
-	// res := map[string]string{
-
-	// 	"Response-Time": "20",
-	// }
+	requestHeaders := aep.headers.apply(webmethodsEvent.RequestHeaders)
+	aep.headers.addCustomFields(webmethodsEvent.CustomFields, requestHeaders)
 
 	httpStatus, _ := strconv.Atoi(webmethodsEvent.ResponseCode)
+	statusText := http.StatusText(httpStatus)
+	if webmethodsEvent.failureReason != "" {
+		statusText = webmethodsEvent.failureReason
+	}
 	host := webmethodsEvent.ServerID
 	port := 443
 	if strings.Index(host, ":") != -1 {
@@ -161,10 +209,11 @@ func (aep *ApiEventProcessor) createTransactionEvent(eventTime int64, txID strin
 		//SetRemoteAddress("test", "localhost", 443).
 		SetURI(webmethodsEvent.OperationName).
 		SetMethod(webmethodsEvent.HTTPMethod).
-		//SetArgs() to setup query string
-		//SetArgsMap() to setup query string
-		//SetHeaders(buildHeaders(req), buildHeaders(res)).
-		SetStatus(httpStatus, http.StatusText(httpStatus)).
+		SetArgsMap(webmethodsEvent.QueryParameters).
+		SetRequestHeaders(requestHeaders).
+		SetResponseHeaders(aep.headers.apply(webmethodsEvent.ResponseHeaders)).
+		SetPayload(webmethodsEvent.ReqPayload, webmethodsEvent.ResPayload).
+		SetStatus(httpStatus, statusText).
 		SetHost(host).
 		SetLocalAddress(host, port).
 		Build()
@@ -192,110 +241,159 @@ func (aep *ApiEventProcessor) createTransactionEvent(eventTime int64, txID strin
 		Build()
 }
 
-func (aep *ApiEventProcessor) createOutboundTransactionEvent(txID string, webmethodsEvent WebmethodsEvent, eventID, parentId, direction string) (*transaction.LogEvent, error) {
-	backendCall := webmethodsEvent.ExternalCalls
-	log.Infof("BAckend call - %v", backendCall)
-	if len(backendCall) > 0 {
-		logrus.Info("Processing outbound calls")
-		httpStatus, _ := strconv.Atoi(backendCall[0].ResponseCode)
-		httpUrl, err := url.Parse(backendCall[0].ExternalURL)
+// createOutboundTransactionEvents creates a leg for each call made by the gateway to a backend, numbered from leg1 in
+// the order of the calls. Returns true when one of the calls failed.
+func (aep *ApiEventProcessor) createOutboundTransactionEvents(txID string, webmethodsEvent WebmethodsEvent, parentId string) ([]transaction.LogEvent, bool, error) {
+	legs := []transaction.LogEvent{}
+	failed := false
+	routing := routingCallIndex(webmethodsEvent.ExternalCalls)
+	for i, backendCall := range webmethodsEvent.ExternalCalls {
+		eventID := FormatLeg(webmethodsEvent.CorrelationID, i+1)
+		leg, err := aep.createOutboundTransactionEvent(txID, webmethodsEvent, backendCall, i == routing, eventID, parentId, "Outbound")
 		if err != nil {
-			logrus.Error("Unable to parse URL", err)
-			return nil, nil
+			return nil, false, err
 		}
-		var host, port string
-		scheme := httpUrl.Scheme
-		if strings.Index(httpUrl.Host, ":") != -1 {
-			host, port, _ = net.SplitHostPort(httpUrl.Host)
-		} else {
-			host = httpUrl.Host
-			if scheme == "https" {
-				port = "443"
-			} else {
-				port = "80"
-			}
+		if leg == nil {
+			continue
 		}
-		portInt, _ := strconv.Atoi(port)
-		httpProtocolDetails, err := transaction.NewHTTPProtocolBuilder().
-			SetByteLength(webmethodsEvent.TotalDataSize, webmethodsEvent.TotalDataSize).
-			//SetRemoteAddress("test", "localhost", 443).
-			SetURI(webmethodsEvent.OperationName).
-			SetMethod(webmethodsEvent.HTTPMethod).
-			// SetRequestHeaders(req).
-			// SetResponseHeaders(res).
-			SetStatus(httpStatus, http.StatusText(httpStatus)).
-			SetHost(host).
-			SetLocalAddress(host, portInt).
-			Build()
-		if err != nil {
-			return nil, err
+		if leg.TransactionEvent.Status == string(transaction.TxEventStatusFail) {
+			failed = true
 		}
-		eventTimestamp := time.UnixMilli(backendCall[0].CallStartTime)
-		eventTime := eventTimestamp.UTC().UnixNano() / int64(time.Millisecond)
-		logrus.Info("Outbound object created")
-		return transaction.NewTransactionEventBuilder().
-			SetTimestamp(eventTime).
-			SetTransactionID(txID).
-			SetID(eventID).
-			SetParentID(parentId).
-			SetSource(WebmethodsProxy).
-			SetDestination(host).
-			SetDirection(direction).
-			SetDuration(backendCall[0].CallDuration).
-			SetStatus(aep.getTransactionEventStatus(httpStatus)).
-			SetProtocolDetail(httpProtocolDetails).
-			Build()
+		legs = append(legs, *leg)
 	}
-	return nil, nil
+	return legs, failed, nil
 }
 
-func (aep *ApiEventProcessor) createSummaryEvent(eventTime int64, txID string, webmethodsEvent WebmethodsEvent, teamID string) (*transaction.LogEvent, error) {
+// routingCallIndex returns the index of the call routing the request to the native API, which is described by the
+// native fields of the event. The first call is taken when none is typed as routing.
+func routingCallIndex(calls []BackendCall) int {
+	for i, call := range calls {
+		if strings.Contains(strings.ToUpper(call.ExternalCallType), "ROUTING") {
+			return i
+		}
+	}
+	return 0
+}
+
+func (aep *ApiEventProcessor) createOutboundTransactionEvent(txID string, webmethodsEvent WebmethodsEvent, backendCall BackendCall, routing bool, eventID, parentId, direction string) (*transaction.LogEvent, error) {
+	httpUrl, err := url.Parse(backendCall.ExternalURL)
+	if err != nil || httpUrl.Host == "" {
+		logrus.WithField("url", backendCall.ExternalURL).Error("Unable to parse URL of the backend call")
+		return nil, nil
+	}
+	var host, port string
+	scheme := httpUrl.Scheme
+	if strings.Index(httpUrl.Host, ":") != -1 {
+		host, port, _ = net.SplitHostPort(httpUrl.Host)
+	} else {
+		host = httpUrl.Host
+		if scheme == "https" {
+			port = "443"
+		} else {
+			port = "80"
+		}
+	}
+	portInt, _ := strconv.Atoi(port)
+	uri := httpUrl.Path
+	if uri == "" {
+		uri = "/"
+	}
+
+	// a call without a valid response code did not reach the backend
+	httpStatus, _ := strconv.Atoi(backendCall.ResponseCode)
+	status := aep.getTransactionEventStatus(httpStatus)
+	if httpStatus < 100 || httpStatus > 599 {
+		httpStatus = http.StatusBadGateway
+		status = transaction.TxEventStatusFail
+	}
+
+	method := webmethodsEvent.NativeHTTPMethod
+	if method == "" {
+		method = webmethodsEvent.HTTPMethod
+	}
+	builder := transaction.NewHTTPProtocolBuilder().
+		SetURI(uri).
+		SetMethod(method).
+		SetArgsMap(urlArgs(backendCall.ExternalURL)).
+		SetStatus(httpStatus, http.StatusText(httpStatus)).
+		SetHost(host).
+		SetLocalAddress(host, portInt)
+	if routing {
+		builder.
+			SetRequestHeaders(aep.headers.apply(webmethodsEvent.NativeRequestHeaders)).
+			SetResponseHeaders(aep.headers.apply(webmethodsEvent.NativeResponseHeaders)).
+			SetPayload(webmethodsEvent.NativeReqPayload, webmethodsEvent.NativeResPayload)
+	} else {
+		builder.SetRequestHeaders(map[string]string{}).SetResponseHeaders(map[string]string{})
+	}
+	httpProtocolDetails, err := builder.Build()
+	if err != nil {
+		return nil, err
+	}
+
+	duration := backendCall.CallDuration
+	if duration == 0 && backendCall.CallEndTime > backendCall.CallStartTime {
+		duration = int(backendCall.CallEndTime - backendCall.CallStartTime)
+	}
+	eventTimestamp := time.UnixMilli(backendCall.CallStartTime)
+	eventTime := eventTimestamp.UTC().UnixNano() / int64(time.Millisecond)
+	return transaction.NewTransactionEventBuilder().
+		SetTimestamp(eventTime).
+		SetTransactionID(txID).
+		SetID(eventID).
+		SetParentID(parentId).
+		SetSource(WebmethodsProxy).
+		SetDestination(host).
+		SetDirection(direction).
+		SetDuration(duration).
+		SetStatus(status).
+		SetProtocolDetail(httpProtocolDetails).
+		Build()
+}
+
+func (aep *ApiEventProcessor) createSummaryEvent(eventTime int64, txID string, webmethodsEvent WebmethodsEvent, teamID string, calloutFailed bool) (*transaction.LogEvent, error) {
 	statusCode, _ := strconv.Atoi(webmethodsEvent.ResponseCode)
+	summaryStatus := aep.getTransactionSummaryStatus(statusCode)
+	if calloutFailed && summaryStatus == transaction.TxSummaryStatusSuccess {
+		// the gateway answered but one of the backend calls failed
+		summaryStatus = transaction.TxSummaryStatusFailure
+	}
 	method := webmethodsEvent.HTTPMethod
 	uri := webmethodsEvent.OperationName
 	host := webmethodsEvent.ApplicationIp
+	api := aep.apis.resolve(webmethodsEvent.ApiId)
 
 	builder := transaction.NewTransactionSummaryBuilder().
 		SetTimestamp(eventTime).
 		SetTransactionID(txID).
-		SetStatus(aep.getTransactionSummaryStatus(statusCode), strconv.Itoa(statusCode)).
+		SetStatus(summaryStatus, strconv.Itoa(statusCode)).
 		SetTeam(teamID).
 		SetDuration(webmethodsEvent.TotalTime).
 		SetEntryPoint("http", method, uri, host).
 		// If the API is published to Central as unified catalog item/API service, se the Proxy details with the API definition
 		// The Proxy.Name represents the name of the API
 		// The Proxy.ID should be of format "remoteApiId_<ID Of the API on remote gateway>". Use transaction.FormatProxyID(<ID Of the API on remote gateway>) to get the formatted value.
-		SetProxyWithStageVersion(transutil.FormatProxyID(webmethodsEvent.ApiId), webmethodsEvent.ApiName, config.GetConfig().WebMethodConfig.MaturityState, "", 1)
-	if webmethodsEvent.ApplicationName != "Unknown" && webmethodsEvent.ApplicationId != "Unknown" {
-		builder.SetApplication(transutil.FormatApplicationID(webmethodsEvent.ApplicationId), webmethodsEvent.ApplicationName)
+		SetProxyWithStageVersion(transutil.FormatProxyID(webmethodsEvent.ApiId), webmethodsEvent.ApiName, api.stage, api.version, api.revision)
+	if webmethodsEvent.ApplicationId != "" && webmethodsEvent.ApplicationName != "Unknown" && webmethodsEvent.ApplicationId != "Unknown" {
+		appID, appName := aep.getApplication(webmethodsEvent)
+		builder.SetApplication(appID, appName)
 	}
 	return builder.Build()
 
 }
 
-func (aep *ApiEventProcessor) getApplicationByName(appId string, appName string) (string, string) {
-	// find the manged application in the cache
-	manAppName := aep.getManagedApplicationNameByID(appId)
-	if manAppName != "" {
-		return appId, manAppName
+// getApplication returns the application of the summary. The name is the one of the managed application of the
+// webMethods application when there is one, so the event generator attaches its access request and subscription.
+func (aep *ApiEventProcessor) getApplication(webmethodsEvent WebmethodsEvent) (string, string) {
+	appID := transutil.FormatApplicationID(webmethodsEvent.ApplicationId)
+	manAppName := aep.managedApps.resolve(webmethodsEvent.ApplicationId)
+	if manAppName == "" {
+		logrus.
+			WithField("applicationId", webmethodsEvent.ApplicationId).
+			Debug("No managed application for the webMethods application, no consumer details attached")
+		return appID, webmethodsEvent.ApplicationName
 	}
-	return appId, appName
-
-}
-
-func (aep *ApiEventProcessor) getManagedApplicationNameByID(appID string) string {
-	if name, ok := aep.appIDToManApp[appID]; ok {
-		return name
-	}
-	for _, key := range aep.cacheManager.GetManagedApplicationCacheKeys() {
-		ri := aep.cacheManager.GetManagedApplication(key)
-		val, _ := util.GetAgentDetailsValue(ri, "webmethodsApplicationId")
-		if val == appID {
-			aep.appIDToManApp[appID] = ri.Name
-			return ri.Name
-		}
-	}
-	return ""
+	return appID, manAppName
 }
 
 func FormatLeg0(id string) string {
@@ -303,14 +401,10 @@ func FormatLeg0(id string) string {
 }
 
 func FormatLeg1(id string) string {
-	return fmt.Sprintf("%s-leg1", id)
+	return FormatLeg(id, 1)
 }
 
-//func buildHeaders(headers map[string]string) string {
-//	jsonHeader, err := json.Marshal(headers)
-//	if err != nil {
-//		log.Error(err.Error())
-//		return ""
-//	}
-//	return string(jsonHeader)
-//}
+// FormatLeg returns the id of the leg of the backend call at the given position, starting at 1
+func FormatLeg(id string, index int) string {
+	return fmt.Sprintf("%s-leg%d", id, index)
+}
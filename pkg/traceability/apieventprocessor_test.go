@@ -0,0 +1,162 @@
+package traceability
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Axway/agent-sdk/pkg/traceability/redaction"
+	"github.com/Axway/agent-sdk/pkg/transaction"
+	"github.com/Axway/agents-webmethods/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupShowAllRedaction(t *testing.T) {
+	showAll := redaction.Filter{Allowed: []redaction.Show{{KeyMatch: ".*"}}}
+	cfg := redaction.DefaultConfig()
+	cfg.Path.Allowed = showAll.Allowed
+	cfg.Args = showAll
+	cfg.RequestHeaders = showAll
+	cfg.ResponseHeaders = showAll
+	assert.Nil(t, redaction.SetupGlobalRedaction(cfg))
+}
+
+func newTestProcessor(webMethodConfig *config.WebMethodConfig) *ApiEventProcessor {
+	cacheManager := &fakeCacheManager{}
+	return &ApiEventProcessor{
+		cfg:          &config.AgentConfig{WebMethodConfig: webMethodConfig},
+		cacheManager: cacheManager,
+		managedApps:  newManagedAppCache(cacheManager, maxManagedApps),
+		apis:         newAPIDetailsCache(&fakeAPIDetailsClient{}, cacheManager, fakeRevisionClient{}, webMethodConfig.MaturityState, maxAPIDetails),
+		headers:      newHeaderFilter(webMethodConfig.HeadersAllow, webMethodConfig.HeadersDeny),
+		transactions: newBoundedCache[bool](maxReportedTransactions),
+	}
+}
+
+func protocolOf(t *testing.T, event *transaction.LogEvent) *transaction.Protocol {
+	protocol, ok := event.TransactionEvent.Protocol.(*transaction.Protocol)
+	assert.True(t, ok)
+	return protocol
+}
+
+func decodeMap(t *testing.T, data string, v interface{}) {
+	assert.Nil(t, json.Unmarshal([]byte(data), v))
+}
+
+const eventWithHeaders = `{
+	"sessionId": "session",
+	"correlationID": "correlation",
+	"responseCode": "200",
+	"operationName": "/pets",
+	"httpMethod": "GET",
+	"serverID": "gateway:5555",
+	"requestHeaders": {"Accept": "application/json", "Authorization": "Basic abc", "X-Forwarded-For": ["10.0.0.1", "10.0.0.2"]},
+	"responseHeaders": {"Content-Type": "application/json"},
+	"queryParameters": {"limit": "10", "tags": ["a", "b"]},
+	"customFields": {"tier": "gold", "score": 3},
+	"nativeRequestHeaders": {"X-Backend": "yes", "Authorization": "Bearer token"},
+	"nativeResponseHeaders": {"Content-Length": 42},
+	"externalCalls": [{"externalURL": "https://backend:8443/pets?limit=10", "responseCode": "200"}]
+}`
+
+func TestTransactionEventsCarryHeadersAndArgs(t *testing.T) {
+	setupShowAllRedaction(t)
+	event := WebmethodsEvent{}
+	assert.Nil(t, json.Unmarshal([]byte(eventWithHeaders), &event))
+	aep := newTestProcessor(&config.WebMethodConfig{HeadersDeny: []string{"authorization"}})
+
+	inbound, err := aep.createTransactionEvent(0, "session", event, "leg0", "", "Inbound")
+	assert.Nil(t, err)
+	protocol := protocolOf(t, inbound)
+	requestHeaders := map[string]string{}
+	decodeMap(t, protocol.RequestHeaders, &requestHeaders)
+	assert.Equal(t, map[string]string{
+		"Accept":                    "application/json",
+		"X-Forwarded-For":           "10.0.0.1, 10.0.0.2",
+		"X-Webmethods-Custom-tier":  "gold",
+		"X-Webmethods-Custom-score": "3",
+	}, requestHeaders)
+	responseHeaders := map[string]string{}
+	decodeMap(t, protocol.ResponseHeaders, &responseHeaders)
+	assert.Equal(t, map[string]string{"Content-Type": "application/json"}, responseHeaders)
+	args := map[string][]string{}
+	decodeMap(t, protocol.Args, &args)
+	assert.Equal(t, map[string][]string{"limit": {"10"}, "tags": {"a", "b"}}, args)
+
+	outbound, failed, err := aep.createOutboundTransactionEvents("session", event, "leg0")
+	assert.Nil(t, err)
+	assert.False(t, failed)
+	assert.Len(t, outbound, 1)
+	protocol = protocolOf(t, &outbound[0])
+	requestHeaders = map[string]string{}
+	decodeMap(t, protocol.RequestHeaders, &requestHeaders)
+	assert.Equal(t, map[string]string{"X-Backend": "yes"}, requestHeaders)
+	responseHeaders = map[string]string{}
+	decodeMap(t, protocol.ResponseHeaders, &responseHeaders)
+	assert.Equal(t, map[string]string{"Content-Length": "42"}, responseHeaders)
+	args = map[string][]string{}
+	decodeMap(t, protocol.Args, &args)
+	assert.Equal(t, map[string][]string{"limit": {"10"}}, args)
+}
+
+func TestHeaderFilterAllowList(t *testing.T) {
+	filter := newHeaderFilter([]string{"accept", " X-Request-ID "}, []string{"x-request-id"})
+	forwarded := filter.apply(HttpHeaders{"Accept": "*/*", "X-Request-Id": "1", "Cookie": "c"})
+	assert.Equal(t, map[string]string{"Accept": "*/*"}, forwarded)
+}
+
+func TestOutboundLegPerBackendCall(t *testing.T) {
+	setupShowAllRedaction(t)
+	aep := newTestProcessor(&config.WebMethodConfig{})
+	event := WebmethodsEvent{
+		CorrelationID:        "correlation",
+		HTTPMethod:           "GET",
+		NativeHTTPMethod:     "POST",
+		NativeRequestHeaders: HttpHeaders{"X-Native": "yes"},
+		ExternalCalls: []BackendCall{
+			{ExternalCallType: "CALLOUT", ExternalURL: "http://auth:8080/token", CallStartTime: 1000, CallEndTime: 1030, ResponseCode: "200"},
+			{ExternalCallType: "ROUTING_CALL", ExternalURL: "https://backend/pets/1?expand=true", CallStartTime: 1040, CallDuration: 50, ResponseCode: "200"},
+			{ExternalCallType: "CALLOUT", ExternalURL: "https://audit/events", CallStartTime: 1100},
+		},
+	}
+
+	legs, failed, err := aep.createOutboundTransactionEvents("session", event, "leg0")
+	assert.Nil(t, err)
+	assert.True(t, failed)
+	assert.Len(t, legs, 3)
+
+	ids, uris, hosts, durations, statuses := []string{}, []string{}, []string{}, []int{}, []string{}
+	for _, leg := range legs {
+		assert.Equal(t, "leg0", leg.TransactionEvent.ParentID)
+		protocol := protocolOf(t, &leg)
+		ids = append(ids, leg.TransactionEvent.ID)
+		uris = append(uris, protocol.URI)
+		hosts = append(hosts, leg.TransactionEvent.Destination)
+		durations = append(durations, leg.TransactionEvent.Duration)
+		statuses = append(statuses, leg.TransactionEvent.Status)
+	}
+	assert.Equal(t, []string{"correlation-leg1", "correlation-leg2", "correlation-leg3"}, ids)
+	assert.Equal(t, []string{"/token", "/pets/1", "/events"}, uris)
+	assert.Equal(t, []string{"auth", "backend", "audit"}, hosts)
+	assert.Equal(t, []int{30, 50, 0}, durations)
+	pass, fail := string(transaction.TxEventStatusPass), string(transaction.TxEventStatusFail)
+	assert.Equal(t, []string{pass, pass, fail}, statuses)
+
+	// the native request belongs to the routing call only
+	assert.Equal(t, "{}", protocolOf(t, &legs[0]).RequestHeaders)
+	assert.Equal(t, "POST", protocolOf(t, &legs[1]).Method)
+	assert.Equal(t, `{"X-Native":"yes"}`, protocolOf(t, &legs[1]).RequestHeaders)
+}
+
+func TestSummaryFailsOnFailedCallout(t *testing.T) {
+	config.SetConfig(&config.AgentConfig{WebMethodConfig: &config.WebMethodConfig{MaturityState: "Beta"}})
+	aep := newTestProcessor(&config.WebMethodConfig{})
+	event := WebmethodsEvent{ResponseCode: "200", HTTPMethod: "GET", OperationName: "/pets", ApiId: "api", ApiName: "pets"}
+
+	summary, err := aep.createSummaryEvent(0, "session", event, "team", false)
+	assert.Nil(t, err)
+	assert.Equal(t, string(transaction.TxSummaryStatusSuccess), summary.TransactionSummary.Status)
+
+	summary, err = aep.createSummaryEvent(0, "session", event, "team", true)
+	assert.Nil(t, err)
+	assert.Equal(t, string(transaction.TxSummaryStatusFailure), summary.TransactionSummary.Status)
+}
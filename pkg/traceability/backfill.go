@@ -0,0 +1,137 @@
+package traceability
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// cacheKeyBackfillProgress is the progress of the backfill window of an event type that failed to be read
+const cacheKeyBackfillProgress = "BACKFILL_PROGRESS"
+
+// BackfillRequest is the time range of transactions to collect again, picked up by the running agent on its next
+// poll. The checkpoints are the end of the last window collected of each event type, the request is removed once they
+// all reach the end. The checkpoint shared by every event type in previous versions is used until a type has its own.
+type BackfillRequest struct {
+	From        string            `json:"from"`
+	To          string            `json:"to"`
+	Checkpoint  string            `json:"checkpoint,omitempty"`
+	Checkpoints map[string]string `json:"checkpoints,omitempty"`
+}
+
+// RequestBackfill saves the request to collect the transactions between from and to, in the Webmethods API Gateway
+// timezone and formatted as 2006-01-02 15:04:05, replacing any backfill in progress
+func RequestBackfill(path, from, to string) error {
+	start, err := time.Parse(dateFormat, from)
+	if err != nil {
+		return fmt.Errorf("invalid backfill start %s: %s", from, err)
+	}
+	end, err := time.Parse(dateFormat, to)
+	if err != nil {
+		return fmt.Errorf("invalid backfill end %s: %s", to, err)
+	}
+	if !start.Before(end) {
+		return errors.New("the backfill start must be before its end")
+	}
+	return saveBackfill(path, &BackfillRequest{From: from, To: to})
+}
+
+func loadBackfill(path string) (*BackfillRequest, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	request := &BackfillRequest{}
+	if err := json.Unmarshal(data, request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+func saveBackfill(path string, request *BackfillRequest) error {
+	data, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// runBackfill collects the next window of each event type of the pending backfill request, leaving the last run
+// untouched. A single window is collected on each poll so the live transactions are not delayed by a long backfill, an
+// event type failing to be collected does not hold back the other event types. The request is removed once the last
+// window of every event type is collected.
+func (we *WebmethodsEventEmitter) runBackfill() error {
+	if we.backfillPath == "" {
+		return nil
+	}
+	request, err := loadBackfill(we.backfillPath)
+	if err != nil || request == nil {
+		return err
+	}
+	endTime, err := time.ParseInLocation(dateFormat, request.To, &we.timezoneLocation)
+	if err != nil {
+		return err
+	}
+	if request.Checkpoints == nil {
+		request.Checkpoints = map[string]string{}
+	}
+
+	var firstErr error
+	completed := true
+	for _, eventType := range we.eventTypes {
+		checkpoint := request.From
+		if request.Checkpoint != "" {
+			checkpoint = request.Checkpoint
+		}
+		if saved, ok := request.Checkpoints[eventType]; ok {
+			checkpoint = saved
+		}
+		startTime, err := time.ParseInLocation(dateFormat, checkpoint, &we.timezoneLocation)
+		if err != nil {
+			return err
+		}
+		windows := splitWindow(startTime, endTime, we.windowSize)
+		if len(windows) == 0 {
+			continue
+		}
+		w := windows[0]
+		strStartTime, strEndTime := w.start.Format(dateFormat), w.end.Format(dateFormat)
+		logrus.
+			WithField("eventType", eventType).
+			WithField("from", request.From).
+			WithField("to", request.To).
+			Infof("backfilling transactions, %d windows left", len(windows))
+		err = we.collectEventType(eventType, strStartTime, strEndTime, progressKey(cacheKeyBackfillProgress, eventType))
+		if err != nil {
+			completed = false
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		request.Checkpoints[eventType] = strEndTime
+		if len(windows) > 1 {
+			completed = false
+		}
+	}
+	if !completed {
+		if err := saveBackfill(we.backfillPath, request); err != nil {
+			return err
+		}
+		return firstErr
+	}
+	logrus.WithField("from", request.From).WithField("to", request.To).Info("backfill of transactions completed")
+	return os.Remove(we.backfillPath)
+}
+
+// FormatBackfillPath returns the path of the backfill request file in the cache directory
+func FormatBackfillPath(path string) string {
+	return fmt.Sprintf("%s/webmethods-backfill.json", path)
+}
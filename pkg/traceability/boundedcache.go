@@ -0,0 +1,90 @@
+package traceability
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+type boundedEntry[V any] struct {
+	key     string
+	value   V
+	expires time.Time
+}
+
+// boundedCache is an LRU cache keeping at most maxEntries values, a value set with a ttl expires after it
+type boundedCache[V any] struct {
+	mutex      sync.Mutex
+	maxEntries int
+	entries    map[string]*list.Element
+	lru        *list.List
+	now        func() time.Time
+}
+
+func newBoundedCache[V any](maxEntries int) *boundedCache[V] {
+	return &boundedCache[V]{
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+		now:        time.Now,
+	}
+}
+
+// get returns the value of the key when it is cached and has not expired
+func (c *boundedCache[V]) get(key string) (V, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var value V
+	element, ok := c.entries[key]
+	if !ok {
+		return value, false
+	}
+	entry := element.Value.(*boundedEntry[V])
+	if !entry.expires.IsZero() && !c.now().Before(entry.expires) {
+		c.remove(element)
+		return value, false
+	}
+	c.lru.MoveToFront(element)
+	return entry.value, true
+}
+
+// set caches the value of the key, it never expires when ttl is 0. The least recently used value is dropped when
+// the cache is full.
+func (c *boundedCache[V]) set(key string, value V, ttl time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry := &boundedEntry[V]{key: key, value: value}
+	if ttl > 0 {
+		entry.expires = c.now().Add(ttl)
+	}
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	if c.lru.Len() > c.maxEntries {
+		c.remove(c.lru.Back())
+	}
+}
+
+// delete drops the value of the key
+func (c *boundedCache[V]) delete(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+}
+
+func (c *boundedCache[V]) len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.lru.Len()
+}
+
+func (c *boundedCache[V]) remove(element *list.Element) {
+	c.lru.Remove(element)
+	delete(c.entries, element.Value.(*boundedEntry[V]).key)
+}
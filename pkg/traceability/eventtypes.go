@@ -0,0 +1,118 @@
+package traceability
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Axway/agents-webmethods/pkg/webmethods"
+	"golang.org/x/exp/slices"
+)
+
+// eventKind is the kind of an event of the transactions export
+type eventKind string
+
+const (
+	transactionalEvent   eventKind = "transaction"
+	errorEvent           eventKind = "error"
+	policyViolationEvent eventKind = "policyViolation"
+	lifecycleEvent       eventKind = "lifecycle"
+)
+
+// kindOf returns the kind of an event from its event type, given either as the exported value like "Policy Violation"
+// or as the requested event type like "policyViolationEvents"
+func kindOf(eventType string) eventKind {
+	normalized := strings.ToLower(strings.ReplaceAll(eventType, " ", ""))
+	switch {
+	case strings.Contains(normalized, "policyviolation"):
+		return policyViolationEvent
+	case strings.Contains(normalized, "error"):
+		return errorEvent
+	case strings.Contains(normalized, "lifecycle"):
+		return lifecycleEvent
+	default:
+		return transactionalEvent
+	}
+}
+
+const (
+	// maxReportedTransactions is the number of requests whose transactional event is remembered
+	maxReportedTransactions = 10000
+	// reportedTransactionTTL is the time a request whose transactional event was reported is remembered
+	reportedTransactionTTL = time.Hour
+)
+
+// transactionalFirst returns the event types with the transactional events first, so the transactional event of a
+// request is collected before its error or policy violation events
+func transactionalFirst(eventTypes []string) []string {
+	sorted := append([]string{}, eventTypes...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return kindOf(sorted[i]) == transactionalEvent && kindOf(sorted[j]) != transactionalEvent
+	})
+	return sorted
+}
+
+// validateEventTypes checks that the configured event types are handled by the agent
+func validateEventTypes(eventTypes []string) error {
+	if len(eventTypes) == 0 {
+		return fmt.Errorf("no event types configured, use one of %s", strings.Join(webmethods.EventTypes, ", "))
+	}
+	for _, eventType := range eventTypes {
+		if !slices.Contains(webmethods.EventTypes, eventType) {
+			return fmt.Errorf("invalid event type %s, use one of %s", eventType, strings.Join(webmethods.EventTypes, ", "))
+		}
+	}
+	return nil
+}
+
+// getFailureReason returns the reason reported for an error or policy violation event
+func (e WebmethodsEvent) getFailureReason() string {
+	for _, reason := range []string{e.ErrorDesc, e.AlertDesc, e.PolicyName} {
+		if reason != "" {
+			return reason
+		}
+	}
+	if kindOf(e.EventType) == policyViolationEvent {
+		return "Policy violation"
+	}
+	return "Error"
+}
+
+// requestID returns the id of the request of the event, shared by its transactional, error and policy violation events
+func (e WebmethodsEvent) requestID() string {
+	if e.CorrelationID != "" {
+		return e.CorrelationID
+	}
+	return e.SessionId
+}
+
+// asFailedTransaction maps an error or policy violation event to a failed transaction with its failure reason,
+// completing the fields the event may not have. The transaction ids are suffixed with the kind so they do not collide with the transactional
+// event of the same request.
+func (e WebmethodsEvent) asFailedTransaction(kind eventKind) WebmethodsEvent {
+	e.failureReason = e.getFailureReason()
+	if code, _ := strconv.Atoi(e.ResponseCode); code < http.StatusBadRequest {
+		if kind == policyViolationEvent {
+			e.ResponseCode = strconv.Itoa(http.StatusForbidden)
+		} else {
+			e.ResponseCode = strconv.Itoa(http.StatusInternalServerError)
+		}
+	}
+	if e.CorrelationID == "" {
+		e.CorrelationID = e.SessionId
+	}
+	e.CorrelationID = fmt.Sprintf("%s-%s", e.CorrelationID, kind)
+	if e.SessionId == "" {
+		e.SessionId = e.CorrelationID
+	}
+	if e.OperationName == "" {
+		e.OperationName = "/"
+	}
+	if e.HTTPMethod == "" {
+		e.HTTPMethod = "UNKNOWN"
+	}
+	return e
+}
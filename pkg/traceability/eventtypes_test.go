@@ -0,0 +1,96 @@
+package traceability
+
+import (
+	"testing"
+
+	"github.com/Axway/agent-sdk/pkg/transaction"
+	"github.com/Axway/agents-webmethods/pkg/config"
+	"github.com/Axway/agents-webmethods/pkg/webmethods"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKindOf(t *testing.T) {
+	assert.Equal(t, transactionalEvent, kindOf("Transactional"))
+	assert.Equal(t, transactionalEvent, kindOf(webmethods.TransactionalEvents))
+	assert.Equal(t, errorEvent, kindOf("Error"))
+	assert.Equal(t, errorEvent, kindOf(webmethods.ErrorEvents))
+	assert.Equal(t, policyViolationEvent, kindOf("Policy Violation"))
+	assert.Equal(t, policyViolationEvent, kindOf(webmethods.PolicyViolationEvents))
+	assert.Equal(t, lifecycleEvent, kindOf("Lifecycle"))
+}
+
+func TestValidateEventTypes(t *testing.T) {
+	assert.Nil(t, validateEventTypes(webmethods.EventTypes))
+	assert.NotNil(t, validateEventTypes([]string{}))
+	assert.NotNil(t, validateEventTypes([]string{webmethods.TransactionalEvents, "auditEvents"}))
+}
+
+func TestTransactionalEventsAreCollectedFirst(t *testing.T) {
+	assert.Equal(t,
+		[]string{webmethods.TransactionalEvents, webmethods.ErrorEvents, webmethods.LifecycleEvents},
+		transactionalFirst([]string{webmethods.ErrorEvents, webmethods.TransactionalEvents, webmethods.LifecycleEvents}))
+}
+
+func TestFailureOfAReportedTransactionIsNotPublished(t *testing.T) {
+	setupShowAllRedaction(t)
+	aep := newTestProcessor(&config.WebMethodConfig{})
+	redactor, err := newRedactor(&config.WebMethodConfig{})
+	assert.Nil(t, err)
+	aep.redactor = redactor
+	violation := WebmethodsEvent{EventType: "Policy Violation", SessionId: "session", CorrelationID: "correlation"}
+
+	assert.False(t, aep.isReportedByTransaction(violation, policyViolationEvent))
+	assert.False(t, aep.isReportedByTransaction(WebmethodsEvent{SessionId: "session", CorrelationID: "correlation"}, transactionalEvent))
+	assert.True(t, aep.isReportedByTransaction(violation, policyViolationEvent))
+	assert.Nil(t, aep.ProcessRaw(violation))
+}
+
+func TestPolicyViolationIsAFailedTransaction(t *testing.T) {
+	setupShowAllRedaction(t)
+	event := WebmethodsEvent{
+		EventType:     "Policy Violation",
+		SessionId:     "session",
+		CorrelationID: "correlation",
+		ApiId:         "api",
+		ApiName:       "pets",
+		AlertDesc:     "Throttling limit exceeded",
+		ServerID:      "gateway:5555",
+	}
+	failed := event.asFailedTransaction(policyViolationEvent)
+	assert.Equal(t, "Throttling limit exceeded", failed.failureReason)
+	assert.Equal(t, "403", failed.ResponseCode)
+	assert.Equal(t, "correlation-policyViolation", failed.CorrelationID)
+	assert.Equal(t, "/", failed.OperationName)
+	assert.NotEmpty(t, failed.HTTPMethod)
+
+	aep := newTestProcessor(&config.WebMethodConfig{})
+	summary, err := aep.createSummaryEvent(0, "session", failed, "team", false)
+	assert.Nil(t, err)
+	assert.Equal(t, string(transaction.TxSummaryStatusFailure), summary.TransactionSummary.Status)
+	// the status code is kept as status detail, the SDK aggregates the metrics by it
+	assert.Equal(t, "403", summary.TransactionSummary.StatusDetail)
+
+	inbound, err := aep.createTransactionEvent(0, "session", failed, "leg0", "", "Inbound")
+	assert.Nil(t, err)
+	assert.Equal(t, "Throttling limit exceeded", protocolOf(t, inbound).StatusText)
+}
+
+func TestErrorKeepsItsResponseCode(t *testing.T) {
+	event := WebmethodsEvent{EventType: "Error", SessionId: "session", ResponseCode: "504", HTTPMethod: "POST"}
+	failed := event.asFailedTransaction(errorEvent)
+	assert.Equal(t, "Error", failed.failureReason)
+	assert.Equal(t, "504", failed.ResponseCode)
+	assert.Equal(t, "session-error", failed.CorrelationID)
+	assert.Equal(t, "POST", failed.HTTPMethod)
+
+	failed = WebmethodsEvent{EventType: "Error", ResponseCode: "200"}.asFailedTransaction(errorEvent)
+	assert.Equal(t, "500", failed.ResponseCode)
+}
+
+func TestLifecycleEventsAreNotPublished(t *testing.T) {
+	redactor, err := newRedactor(&config.WebMethodConfig{})
+	assert.Nil(t, err)
+	aep := newTestProcessor(&config.WebMethodConfig{})
+	aep.redactor = redactor
+	assert.Nil(t, aep.ProcessRaw(WebmethodsEvent{EventType: "Lifecycle", ServerID: "gateway:5555"}))
+}
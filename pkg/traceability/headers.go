@@ -0,0 +1,69 @@
+package traceability
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// customFieldHeaderPrefix prefixes the custom fields of the gateway forwarded with the inbound request headers
+const customFieldHeaderPrefix = "X-Webmethods-Custom-"
+
+// headerFilter selects the headers forwarded to Central by name, ignoring the case. Every header is forwarded when no
+// allowed header is configured, the denied headers are never forwarded.
+type headerFilter struct {
+	allow map[string]bool
+	deny  map[string]bool
+}
+
+func newHeaderFilter(allow, deny []string) *headerFilter {
+	return &headerFilter{allow: headerSet(allow), deny: headerSet(deny)}
+}
+
+func headerSet(names []string) map[string]bool {
+	set := map[string]bool{}
+	for _, name := range names {
+		if name = strings.TrimSpace(name); name != "" {
+			set[strings.ToLower(name)] = true
+		}
+	}
+	return set
+}
+
+func (f *headerFilter) forwarded(name string) bool {
+	name = strings.ToLower(name)
+	if f.deny[name] {
+		return false
+	}
+	return len(f.allow) == 0 || f.allow[name]
+}
+
+// apply returns the forwarded headers
+func (f *headerFilter) apply(headers HttpHeaders) map[string]string {
+	forwarded := map[string]string{}
+	for name, value := range headers {
+		if f.forwarded(name) {
+			forwarded[name] = value
+		}
+	}
+	return forwarded
+}
+
+// addCustomFields adds the forwarded custom fields to the request headers
+func (f *headerFilter) addCustomFields(fields map[string]interface{}, headers map[string]string) {
+	for name, value := range fields {
+		header := customFieldHeaderPrefix + name
+		if value != nil && f.forwarded(header) {
+			headers[header] = fmt.Sprint(value)
+		}
+	}
+}
+
+// urlArgs returns the query parameters of a url
+func urlArgs(rawURL string) map[string][]string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil
+	}
+	return u.Query()
+}
@@ -0,0 +1,96 @@
+package traceability
+
+import (
+	"time"
+
+	management "github.com/Axway/agent-sdk/pkg/apic/apiserver/models/management/v1alpha1"
+	"github.com/Axway/agent-sdk/pkg/util"
+	"github.com/Axway/agents-webmethods/pkg/common"
+)
+
+const (
+	// maxManagedApps is the number of webMethods applications whose managed application is kept
+	maxManagedApps = 1000
+	// unresolvedTTL is the time an application without managed application is not looked up again
+	unresolvedTTL = time.Minute
+)
+
+// managedAppCache resolves the managed application of a webMethods application from the agent cache, the application
+// of the managed application or a dedicated application created for one of its access requests. The resolved
+// names are kept in a bounded cache, an entry is dropped when its managed application is removed or no longer
+// references the webMethods application, and an unresolved application is looked up again after unresolvedTTL.
+type managedAppCache struct {
+	cacheManager cacheManager
+	names        *boundedCache[string]
+}
+
+func newManagedAppCache(cacheManager cacheManager, maxEntries int) *managedAppCache {
+	return &managedAppCache{
+		cacheManager: cacheManager,
+		names:        newBoundedCache[string](maxEntries),
+	}
+}
+
+// resolve returns the name of the managed application of the webMethods application, empty when there is none
+func (c *managedAppCache) resolve(appID string) string {
+	if name, ok := c.names.get(appID); ok {
+		if name == "" || c.isValid(appID, name) {
+			return name
+		}
+		c.names.delete(appID)
+	}
+
+	name := c.lookup(appID)
+	if name == "" {
+		c.names.set(appID, name, unresolvedTTL)
+	} else {
+		c.names.set(appID, name, 0)
+	}
+	return name
+}
+
+func (c *managedAppCache) isValid(appID, name string) bool {
+	ri := c.cacheManager.GetManagedApplicationByName(name)
+	if ri == nil {
+		return false
+	}
+	if val, _ := util.GetAgentDetailsValue(ri, common.AttrAppID); val == appID {
+		return true
+	}
+	for _, ar := range c.cacheManager.GetAccessRequestsByApp(name) {
+		if ar == nil {
+			continue
+		}
+		if val, _ := util.GetAgentDetailsValue(ar, common.AttrAppID); val == appID {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *managedAppCache) lookup(appID string) string {
+	for _, key := range c.cacheManager.GetManagedApplicationCacheKeys() {
+		ri := c.cacheManager.GetManagedApplication(key)
+		if ri == nil {
+			continue
+		}
+		val, _ := util.GetAgentDetailsValue(ri, common.AttrAppID)
+		if val == appID {
+			return ri.Name
+		}
+	}
+	// the dedicated applications are recorded on the access requests they were created for
+	for _, ri := range c.cacheManager.ListAccessRequests() {
+		if ri == nil {
+			continue
+		}
+		if val, _ := util.GetAgentDetailsValue(ri, common.AttrAppID); val != appID {
+			continue
+		}
+		ar := &management.AccessRequest{}
+		if err := ar.FromInstance(ri); err == nil && ar.Spec.ManagedApplication != "" {
+			return ar.Spec.ManagedApplication
+		}
+	}
+	return ""
+}
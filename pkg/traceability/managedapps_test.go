@@ -0,0 +1,176 @@
+package traceability
+
+import (
+	"testing"
+	"time"
+
+	v1 "github.com/Axway/agent-sdk/pkg/apic/apiserver/models/api/v1"
+	management "github.com/Axway/agent-sdk/pkg/apic/apiserver/models/management/v1alpha1"
+	"github.com/Axway/agent-sdk/pkg/util"
+	"github.com/Axway/agents-webmethods/pkg/common"
+	"github.com/Axway/agents-webmethods/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeCacheManager struct {
+	managedApps    map[string]*v1.ResourceInstance
+	accessRequests []*v1.ResourceInstance
+	instances      map[string]*v1.ResourceInstance
+	lookups        int
+}
+
+func (m *fakeCacheManager) GetManagedApplicationCacheKeys() []string {
+	m.lookups++
+	keys := []string{}
+	for key := range m.managedApps {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (m *fakeCacheManager) GetManagedApplication(id string) *v1.ResourceInstance {
+	return m.managedApps[id]
+}
+
+func (m *fakeCacheManager) GetManagedApplicationByName(name string) *v1.ResourceInstance {
+	for _, ri := range m.managedApps {
+		if ri.Name == name {
+			return ri
+		}
+	}
+	return nil
+}
+
+func (m *fakeCacheManager) ListAccessRequests() []*v1.ResourceInstance {
+	return m.accessRequests
+}
+
+func (m *fakeCacheManager) GetAccessRequestsByApp(managedAppName string) []*v1.ResourceInstance {
+	accessRequests := []*v1.ResourceInstance{}
+	for _, ri := range m.accessRequests {
+		ar := &management.AccessRequest{}
+		if ar.FromInstance(ri) == nil && ar.Spec.ManagedApplication == managedAppName {
+			accessRequests = append(accessRequests, ri)
+		}
+	}
+	return accessRequests
+}
+
+func (m *fakeCacheManager) GetAPIServiceInstanceKeys() []string {
+	keys := []string{}
+	for key := range m.instances {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (m *fakeCacheManager) GetAPIServiceInstanceByID(id string) (*v1.ResourceInstance, error) {
+	return m.instances[id], nil
+}
+
+func newManagedApplicationInstance(name, appID string) *v1.ResourceInstance {
+	app := management.NewManagedApplication(name, "env")
+	util.SetAgentDetails(app, map[string]interface{}{common.AttrAppID: appID})
+	ri, _ := app.AsInstance()
+	return ri
+}
+
+func TestManagedAppCacheResolvesAndInvalidates(t *testing.T) {
+	cacheManager := &fakeCacheManager{managedApps: map[string]*v1.ResourceInstance{
+		"id1": newManagedApplicationInstance("mapp1", "app1"),
+	}}
+	c := newManagedAppCache(cacheManager, 10)
+
+	assert.Equal(t, "mapp1", c.resolve("app1"))
+	assert.Equal(t, "mapp1", c.resolve("app1"))
+	assert.Equal(t, 1, cacheManager.lookups)
+
+	// the managed application now references another webMethods application
+	cacheManager.managedApps["id1"] = newManagedApplicationInstance("mapp1", "app2")
+	assert.Equal(t, "", c.resolve("app1"))
+	assert.Equal(t, 2, cacheManager.lookups)
+
+	// removed managed application
+	delete(cacheManager.managedApps, "id1")
+	assert.Equal(t, "", c.resolve("app2"))
+}
+
+func TestManagedAppCacheRetriesUnresolvedApps(t *testing.T) {
+	cacheManager := &fakeCacheManager{managedApps: map[string]*v1.ResourceInstance{}}
+	c := newManagedAppCache(cacheManager, 10)
+	now := time.Now()
+	c.names.now = func() time.Time { return now }
+
+	assert.Equal(t, "", c.resolve("app1"))
+	cacheManager.managedApps["id1"] = newManagedApplicationInstance("mapp1", "app1")
+	assert.Equal(t, "", c.resolve("app1"))
+	assert.Equal(t, 1, cacheManager.lookups)
+
+	now = now.Add(unresolvedTTL)
+	assert.Equal(t, "mapp1", c.resolve("app1"))
+}
+
+func TestManagedAppCacheResolvesDedicatedApplications(t *testing.T) {
+	ar := management.NewAccessRequest("ar1", "env")
+	ar.Spec.ManagedApplication = "mapp1"
+	util.SetAgentDetails(ar, map[string]interface{}{common.AttrAppID: "dedicated", common.AttrDedicatedApp: "true"})
+	arInstance, _ := ar.AsInstance()
+	cacheManager := &fakeCacheManager{
+		managedApps:    map[string]*v1.ResourceInstance{"id1": newManagedApplicationInstance("mapp1", "app1")},
+		accessRequests: []*v1.ResourceInstance{arInstance},
+	}
+	c := newManagedAppCache(cacheManager, 10)
+
+	assert.Equal(t, "mapp1", c.resolve("dedicated"))
+	assert.Equal(t, "mapp1", c.resolve("dedicated"))
+	assert.Equal(t, 1, cacheManager.lookups)
+
+	// the access request was removed with its dedicated application
+	cacheManager.accessRequests = nil
+	assert.Equal(t, "", c.resolve("dedicated"))
+	assert.Equal(t, "mapp1", c.resolve("app1"))
+}
+
+func TestManagedAppCacheIsBounded(t *testing.T) {
+	cacheManager := &fakeCacheManager{managedApps: map[string]*v1.ResourceInstance{
+		"id1": newManagedApplicationInstance("mapp1", "app1"),
+		"id2": newManagedApplicationInstance("mapp2", "app2"),
+		"id3": newManagedApplicationInstance("mapp3", "app3"),
+	}}
+	c := newManagedAppCache(cacheManager, 2)
+
+	c.resolve("app1")
+	c.resolve("app2")
+	c.resolve("app1")
+	c.resolve("app3")
+	assert.Equal(t, 2, c.names.len())
+	_, ok := c.names.get("app1")
+	assert.True(t, ok)
+	_, ok = c.names.get("app2")
+	assert.False(t, ok)
+}
+
+func TestSummaryUsesManagedApplication(t *testing.T) {
+	setupShowAllRedaction(t)
+	aep := newTestProcessor(&config.WebMethodConfig{})
+	aep.cacheManager.(*fakeCacheManager).managedApps = map[string]*v1.ResourceInstance{
+		"id1": newManagedApplicationInstance("mapp1", "app1"),
+	}
+	event := WebmethodsEvent{ResponseCode: "200", HTTPMethod: "GET", OperationName: "/pets", ApiId: "api", ApiName: "pets"}
+
+	event.ApplicationId, event.ApplicationName = "app1", "webMethods app"
+	summary, err := aep.createSummaryEvent(0, "session", event, "team", false)
+	assert.Nil(t, err)
+	assert.Equal(t, "remoteAppId_app1", summary.TransactionSummary.Application.ID)
+	assert.Equal(t, "mapp1", summary.TransactionSummary.Application.Name)
+
+	event.ApplicationId, event.ApplicationName = "app2", "other app"
+	summary, err = aep.createSummaryEvent(0, "session", event, "team", false)
+	assert.Nil(t, err)
+	assert.Equal(t, "other app", summary.TransactionSummary.Application.Name)
+
+	event.ApplicationId, event.ApplicationName = "Unknown", "Unknown"
+	summary, err = aep.createSummaryEvent(0, "session", event, "team", false)
+	assert.Nil(t, err)
+	assert.Nil(t, summary.TransactionSummary.Application)
+}
@@ -0,0 +1,204 @@
+package traceability
+
+import (
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/Axway/agent-sdk/pkg/agent"
+	"github.com/Axway/agent-sdk/pkg/transaction/metric"
+	"github.com/Axway/agent-sdk/pkg/transaction/models"
+	transutil "github.com/Axway/agent-sdk/pkg/transaction/util"
+	"github.com/elastic/beats/v7/libbeat/beat"
+	metrics "github.com/rcrowley/go-metrics"
+	"github.com/sirupsen/logrus"
+)
+
+// durationSampleSize is the number of durations kept by bucket to compute the percentiles
+const durationSampleSize = 1028
+
+// durationPercentiles are the percentiles of the durations reported for each bucket
+var durationPercentiles = []float64{0.5, 0.9, 0.99}
+
+type metricCollector interface {
+	InitializeBatch()
+	AddAPIMetricDetail(detail metric.MetricDetail)
+	Publish()
+}
+
+type metricKey struct {
+	apiID      string
+	appID      string
+	statusCode string
+	start      int64
+}
+
+// metricBucket aggregates the transactions of an API and application with the same status code in a time bucket
+type metricBucket struct {
+	api        models.APIDetails
+	app        models.AppDetails
+	statusCode string
+	start      time.Time
+	end        time.Time
+	count      int64
+	min        int64
+	max        int64
+	total      int64
+	durations  metrics.Histogram
+}
+
+// add counts a transaction of the given duration
+func (b *metricBucket) add(duration int64) {
+	if b.count == 0 || duration < b.min {
+		b.min = duration
+	}
+	if duration > b.max {
+		b.max = duration
+	}
+	b.count++
+	b.total += duration
+	b.durations.Update(duration)
+}
+
+// MetricsProcessor aggregates the transactions by API, application, status code and time bucket and reports them
+// through the metric collector of the SDK. The events of a sample of the transactions are still published, the
+// sampled transactions are chosen by session so all the events of a transaction are published together.
+type MetricsProcessor struct {
+	mutex      sync.Mutex
+	events     *ApiEventProcessor
+	collector  func() metricCollector
+	bucketSize time.Duration
+	sampleRate int
+	teamID     string
+	buckets    map[metricKey]*metricBucket
+}
+
+// NewMetricsProcessor creates a MetricsProcessor publishing the sampled transactions with the events processor
+func NewMetricsProcessor(events *ApiEventProcessor, bucketSize time.Duration, sampleRate int) *MetricsProcessor {
+	teamID := ""
+	if centralCfg := agent.GetCentralConfig(); centralCfg != nil {
+		teamID = centralCfg.GetTeamID()
+	}
+	return &MetricsProcessor{
+		events: events,
+		collector: func() metricCollector {
+			return metric.GetMetricCollector()
+		},
+		bucketSize: bucketSize,
+		sampleRate: sampleRate,
+		teamID:     teamID,
+		buckets:    make(map[metricKey]*metricBucket),
+	}
+}
+
+// ProcessRaw aggregates the transaction and returns its events when it is sampled
+func (mp *MetricsProcessor) ProcessRaw(webmethodsEvent WebmethodsEvent) []beat.Event {
+	switch kind := kindOf(webmethodsEvent.EventType); kind {
+	case lifecycleEvent:
+		return mp.events.ProcessRaw(webmethodsEvent)
+	case errorEvent, policyViolationEvent:
+		if mp.events.isReportedByTransaction(webmethodsEvent, kind) {
+			return nil
+		}
+		mp.aggregate(webmethodsEvent.asFailedTransaction(kind))
+	default:
+		mp.events.isReportedByTransaction(webmethodsEvent, kind)
+		mp.aggregate(webmethodsEvent)
+	}
+	if !mp.isSampled(webmethodsEvent) {
+		return nil
+	}
+	return mp.events.ProcessRaw(webmethodsEvent)
+}
+
+func (mp *MetricsProcessor) isSampled(webmethodsEvent WebmethodsEvent) bool {
+	if mp.sampleRate <= 0 {
+		return false
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(webmethodsEvent.SessionId + webmethodsEvent.CorrelationID))
+	return int(hash.Sum32()%100) < mp.sampleRate
+}
+
+func (mp *MetricsProcessor) aggregate(webmethodsEvent WebmethodsEvent) {
+	details := mp.events.apis.resolve(webmethodsEvent.ApiId)
+	app := models.AppDetails{}
+	if webmethodsEvent.ApplicationId != "" && webmethodsEvent.ApplicationName != "Unknown" && webmethodsEvent.ApplicationId != "Unknown" {
+		app.ID, app.Name = mp.events.getApplication(webmethodsEvent)
+	}
+	statusCode := webmethodsEvent.ResponseCode
+	start := time.UnixMilli(webmethodsEvent.CreationDate).Truncate(mp.bucketSize)
+	key := metricKey{apiID: webmethodsEvent.ApiId, appID: app.ID, statusCode: statusCode, start: start.UnixMilli()}
+
+	mp.mutex.Lock()
+	defer mp.mutex.Unlock()
+	bucket, ok := mp.buckets[key]
+	if !ok {
+		bucket = &metricBucket{
+			api: models.APIDetails{
+				ID:       transutil.FormatProxyID(webmethodsEvent.ApiId),
+				Name:     webmethodsEvent.ApiName,
+				Revision: details.revision,
+				TeamID:   mp.teamID,
+				Stage:    details.stage,
+				Version:  details.version,
+			},
+			app:        app,
+			statusCode: statusCode,
+			start:      start,
+			end:        start.Add(mp.bucketSize),
+			durations:  metrics.NewHistogram(metrics.NewUniformSample(durationSampleSize)),
+		}
+		mp.buckets[key] = bucket
+	}
+	bucket.add(int64(webmethodsEvent.TotalTime))
+}
+
+// Flush reports the aggregated metrics. A bucket receiving transactions after it was reported is reported again with
+// these transactions. The metric detail of the SDK only carries the minimum, maximum and average durations, the
+// duration percentiles of each bucket are logged with it.
+func (mp *MetricsProcessor) Flush() {
+	mp.mutex.Lock()
+	buckets := mp.buckets
+	mp.buckets = make(map[metricKey]*metricBucket)
+	mp.mutex.Unlock()
+
+	if len(buckets) == 0 {
+		return
+	}
+	collector := mp.collector()
+	if collector == nil {
+		logrus.Warn("The metric collector is not available, the aggregated metrics are dropped")
+		return
+	}
+	collector.InitializeBatch()
+	for _, bucket := range buckets {
+		percentiles := bucket.durations.Percentiles(durationPercentiles)
+		logrus.
+			WithField("apiId", bucket.api.ID).
+			WithField("application", bucket.app.Name).
+			WithField("statusCode", bucket.statusCode).
+			WithField("start", bucket.start).
+			WithField("count", bucket.count).
+			WithField("p50", percentiles[0]).
+			WithField("p90", percentiles[1]).
+			WithField("p99", percentiles[2]).
+			Info("Reporting aggregated transactions")
+		collector.AddAPIMetricDetail(metric.MetricDetail{
+			APIDetails: bucket.api,
+			AppDetails: bucket.app,
+			StatusCode: bucket.statusCode,
+			Count:      bucket.count,
+			Response: metric.ResponseMetrics{
+				Max: bucket.max,
+				Min: bucket.min,
+				Avg: float64(bucket.total) / float64(bucket.count),
+			},
+			Observation: metric.ObservationDetails{
+				Start: bucket.start.UnixMilli(),
+				End:   bucket.end.UnixMilli(),
+			},
+		})
+	}
+	collector.Publish()
+}
@@ -0,0 +1,156 @@
+package traceability
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	v1 "github.com/Axway/agent-sdk/pkg/apic/apiserver/models/api/v1"
+	"github.com/Axway/agent-sdk/pkg/transaction/metric"
+	"github.com/Axway/agent-sdk/pkg/transaction/models"
+	"github.com/Axway/agents-webmethods/pkg/config"
+	"github.com/Axway/agents-webmethods/pkg/webmethods"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeMetricCollector struct {
+	batches int
+	details []metric.MetricDetail
+}
+
+func (c *fakeMetricCollector) InitializeBatch() {
+	c.batches++
+}
+
+func (c *fakeMetricCollector) AddAPIMetricDetail(detail metric.MetricDetail) {
+	c.details = append(c.details, detail)
+}
+
+func (c *fakeMetricCollector) Publish() {}
+
+func newTestMetricsProcessor(sampleRate int) (*MetricsProcessor, *fakeMetricCollector) {
+	events := newTestProcessor(&config.WebMethodConfig{})
+	events.apis.client = &fakeAPIDetailsClient{apis: map[string]webmethods.Api{
+		"api": {ApiVersion: "1.0", MaturityState: "Beta", SystemVersion: 2},
+	}}
+	events.cacheManager.(*fakeCacheManager).managedApps = map[string]*v1.ResourceInstance{
+		"id1": newManagedApplicationInstance("mapp1", "app1"),
+	}
+	events.cacheManager.(*fakeCacheManager).instances = map[string]*v1.ResourceInstance{
+		"inst": newServiceInstance("inst", "api", "Beta", "1.0"),
+	}
+	events.apis.revisions = fakeRevisionClient{}.add("pets", "pets-1", "inst-revision")
+	collector := &fakeMetricCollector{}
+	mp := NewMetricsProcessor(events, time.Minute, sampleRate)
+	mp.collector = func() metricCollector { return collector }
+	return mp, collector
+}
+
+func metricEvent(session, code string, creation time.Time, totalTime int) WebmethodsEvent {
+	return WebmethodsEvent{
+		SessionId:       session,
+		CorrelationID:   session,
+		ApiId:           "api",
+		ApiName:         "pets",
+		ApplicationId:   "app1",
+		ApplicationName: "webMethods app",
+		ResponseCode:    code,
+		CreationDate:    creation.UnixMilli(),
+		TotalTime:       totalTime,
+	}
+}
+
+func TestMetricsAreAggregatedByBucket(t *testing.T) {
+	mp, collector := newTestMetricsProcessor(0)
+	bucket := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	for i, duration := range []int{10, 20, 30} {
+		assert.Nil(t, mp.ProcessRaw(metricEvent(fmt.Sprint("ok", i), "200", bucket.Add(time.Duration(i)*time.Second), duration)))
+	}
+	mp.ProcessRaw(metricEvent("ko", "500", bucket, 5))
+	mp.ProcessRaw(metricEvent("next", "200", bucket.Add(time.Minute), 40))
+	mp.Flush()
+
+	assert.Equal(t, 1, collector.batches)
+	assert.Len(t, collector.details, 3)
+	details := map[string]metric.MetricDetail{}
+	for _, detail := range collector.details {
+		details[fmt.Sprint(detail.StatusCode, "-", detail.Observation.Start)] = detail
+	}
+	ok := details[fmt.Sprint("200-", bucket.UnixMilli())]
+	assert.Equal(t, int64(3), ok.Count)
+	assert.Equal(t, metric.ResponseMetrics{Max: 30, Min: 10, Avg: 20}, ok.Response)
+	assert.Equal(t, bucket.Add(time.Minute).UnixMilli(), ok.Observation.End)
+	assert.Equal(t, "remoteApiId_api", ok.APIDetails.ID)
+	assert.Equal(t, "Beta", ok.APIDetails.Stage)
+	assert.Equal(t, "1.0", ok.APIDetails.Version)
+	assert.Equal(t, 2, ok.APIDetails.Revision)
+	assert.Equal(t, models.AppDetails{ID: "remoteAppId_app1", Name: "mapp1"}, ok.AppDetails)
+	assert.Equal(t, int64(1), details[fmt.Sprint("500-", bucket.UnixMilli())].Count)
+	assert.Equal(t, int64(1), details[fmt.Sprint("200-", bucket.Add(time.Minute).UnixMilli())].Count)
+
+	// the reported buckets are not reported again
+	mp.Flush()
+	assert.Equal(t, 1, collector.batches)
+}
+
+func TestMetricsCountPolicyViolationsAsFailures(t *testing.T) {
+	mp, collector := newTestMetricsProcessor(0)
+	event := metricEvent("session", "", time.Now(), 1)
+	event.EventType = "Policy Violation"
+	mp.ProcessRaw(event)
+	mp.Flush()
+
+	assert.Len(t, collector.details, 1)
+	assert.Equal(t, "403", collector.details[0].StatusCode)
+}
+
+func TestMetricsCountFailuresOfReportedTransactionsOnce(t *testing.T) {
+	mp, collector := newTestMetricsProcessor(0)
+	now := time.Now()
+	mp.ProcessRaw(metricEvent("session", "403", now, 1))
+	violation := metricEvent("session", "", now, 1)
+	violation.EventType = "Policy Violation"
+	mp.ProcessRaw(violation)
+	// a policy violation without transactional event is counted
+	violation.SessionId, violation.CorrelationID = "other", "other"
+	mp.ProcessRaw(violation)
+	mp.Flush()
+
+	assert.Len(t, collector.details, 1)
+	assert.Equal(t, "403", collector.details[0].StatusCode)
+	assert.Equal(t, int64(2), collector.details[0].Count)
+}
+
+func TestMetricsSampling(t *testing.T) {
+	none, _ := newTestMetricsProcessor(0)
+	all, _ := newTestMetricsProcessor(100)
+	some, _ := newTestMetricsProcessor(50)
+
+	sampled := 0
+	for i := 0; i < 1000; i++ {
+		event := metricEvent(fmt.Sprint("session", i), "200", time.Now(), 1)
+		assert.False(t, none.isSampled(event))
+		assert.True(t, all.isSampled(event))
+		if some.isSampled(event) {
+			sampled++
+		}
+		// the same transaction is always sampled the same way
+		assert.Equal(t, some.isSampled(event), some.isSampled(event))
+	}
+	assert.InDelta(t, 500, sampled, 100)
+}
+
+func TestMetricsBucketDurationPercentiles(t *testing.T) {
+	mp, _ := newTestMetricsProcessor(0)
+	bucket := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	for i := 1; i <= 100; i++ {
+		mp.ProcessRaw(metricEvent(fmt.Sprint("ok", i), "200", bucket, i))
+	}
+
+	assert.Len(t, mp.buckets, 1)
+	for _, b := range mp.buckets {
+		assert.Equal(t, []float64{50.5, 90.9, 99.99}, b.durations.Percentiles(durationPercentiles))
+	}
+}
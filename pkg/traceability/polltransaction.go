@@ -6,10 +6,12 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"github.com/Axway/agent-sdk/pkg/util/log"
 	"io"
+	"os"
 	"time"
 
+	"github.com/Axway/agent-sdk/pkg/util/log"
+
 	"github.com/Axway/agent-sdk/pkg/cache"
 	"github.com/Axway/agents-webmethods/pkg/config"
 	"github.com/Axway/agents-webmethods/pkg/webmethods"
@@ -22,8 +24,12 @@ import (
 )
 
 const (
+	// CacheKeyTimeStamp is the last run shared by every event type in previous versions, the event types now have
+	// their own last run and fall back to it until they are first collected
 	CacheKeyTimeStamp = "LAST_RUN"
-	dateFormat        = "2006-01-02 15:04:05"
+	// cacheKeyProgress is the progress of the window of an event type that failed to be read
+	cacheKeyProgress = "PROGRESS"
+	dateFormat       = "2006-01-02 15:04:05"
 )
 
 type TraceCache struct {
@@ -43,6 +49,12 @@ type WebmethodsEventEmitter struct {
 	cachePath        string
 	timezoneLocation time.Location
 	analyticsDelay   time.Duration
+	windowSize       time.Duration
+	maxLookback      time.Duration
+	eventTypes       []string
+	rejects          *rejectsWriter
+	backfillPath     string
+	archiveDir       string
 }
 
 // WebmethodsEmitterJob wraps an Emitter and implements the Job interface so that it can be executed by the sdk.
@@ -62,97 +74,331 @@ func NewWebmethodsEventEmitter(agentConfig config.AgentConfig, eventChannel chan
 		pollInterval:     agentConfig.WebMethodConfig.PollInterval,
 		timezoneLocation: timezoneLocation,
 		analyticsDelay:   agentConfig.WebMethodConfig.AnalyticsDelay,
+		windowSize:       agentConfig.WebMethodConfig.TransactionWindow,
+		maxLookback:      agentConfig.WebMethodConfig.MaxLookback,
+		eventTypes:       transactionalFirst(agentConfig.WebMethodConfig.EventTypes),
 	}
 	we.cachePath = formatCachePath(agentConfig.WebMethodConfig.CachePath)
 	we.cache = cache.Load(we.cachePath)
+	we.rejects = newRejectsWriter(formatRejectsPath(agentConfig.WebMethodConfig.CachePath))
+	we.backfillPath = FormatBackfillPath(agentConfig.WebMethodConfig.CachePath)
+	we.archiveDir = agentConfig.WebMethodConfig.CachePath
 	return we
 }
 
-func (we *WebmethodsEventEmitter) unzipFile(body []byte) ([]byte, error) {
-	zipReader, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+// transactionFileStats counts the events read from a file of the transactions archive
+type transactionFileStats struct {
+	name      string
+	eventType string
+	events    int
+	rejected  int
+	// lines is the number of lines of the file read, including the lines skipped
+	lines int
+	err   error
+}
+
+// windowProgress records the number of lines of each transactions file read for a window, so the events already sent
+// are skipped when the window is retrieved again after one of its files failed to be read
+type windowProgress struct {
+	Start string         `json:"start"`
+	End   string         `json:"end"`
+	Lines map[string]int `json:"lines"`
+}
+
+// processArchive sends the events of every file in the transactions archive at the path on the event channel. The
+// files are decompressed and parsed line by line as they are read, the lines already read according to the progress
+// are skipped and the progress updated with the lines read.
+func (we *WebmethodsEventEmitter) processArchive(path string, eventType string, progress *windowProgress) ([]transactionFileStats, error) {
+	zipReader, err := zip.OpenReader(path)
 	if err != nil {
-		logrus.WithError(err).Error("Error extracting zip file")
 		return nil, err
 	}
-	var unzippedFileBytes []byte
+	defer zipReader.Close()
+	files := []transactionFileStats{}
 	for _, zipFile := range zipReader.File {
-		logrus.Infof("Reading file: %s", zipFile.Name)
-		unzippedFileBytes, err = readZipFile(zipFile)
-		if err != nil {
+		if zipFile.FileInfo().IsDir() {
 			continue
 		}
+		skip := 0
+		if progress != nil {
+			skip = progress.Lines[zipFile.Name]
+		}
+		stats := we.processFile(zipFile, eventType, skip)
+		if progress != nil && stats.lines > skip {
+			progress.Lines[zipFile.Name] = stats.lines
+		}
+		logger := logrus.WithField("file", stats.name).WithField("events", stats.events).WithField("rejected", stats.rejected)
+		if stats.err != nil {
+			logger.WithError(stats.err).Error("failed to read transactions file")
+		} else {
+			logger.Info("processed transactions file")
+		}
+		files = append(files, stats)
 	}
-	return unzippedFileBytes, nil
-
+	return files, nil
 }
 
-func readZipFile(zf *zip.File) ([]byte, error) {
+func (we *WebmethodsEventEmitter) processFile(zf *zip.File, eventType string, skip int) transactionFileStats {
+	stats := transactionFileStats{name: zf.Name, eventType: eventType}
 	f, err := zf.Open()
 	if err != nil {
-		return nil, err
+		stats.err = err
+		return stats
 	}
-	defer func(f io.ReadCloser) {
-		err := f.Close()
-		if err != nil {
-			fmt.Printf("Error closing zip file %s", err)
+	defer f.Close()
+	stats.err = we.processEvents(f, &stats, skip)
+	return stats
+}
+
+// processEvents sends each JSON line of the reader after the lines to skip on the event channel. Lines of any length
+// are read, the lines that are not valid events are counted, written to the rejects file and skipped.
+func (we *WebmethodsEventEmitter) processEvents(r io.Reader, stats *transactionFileStats, skip int) error {
+	reader := bufio.NewReader(r)
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if record := bytes.TrimSpace(line); len(record) > 0 && lineNumber > skip {
+			event := WebmethodsEvent{}
+			if parseErr := json.Unmarshal(record, &event); parseErr != nil {
+				stats.rejected++
+				if rejectErr := we.rejects.write(stats.name, lineNumber, record, parseErr); rejectErr != nil {
+					log.Warnf("failed to persist rejected event of %s: %s", stats.name, rejectErr.Error())
+				}
+			} else {
+				if event.EventType == "" {
+					event.EventType = stats.eventType
+				}
+				we.eventChannel <- event
+				stats.events++
+			}
+		}
+		stats.lines = lineNumber
+		if err == io.EOF {
+			return nil
 		}
-	}(f)
-	return io.ReadAll(f)
+	}
 }
 
-// Start retrieves analytics data from webmethods and sends them on the event channel for processing.
+// Start retrieves analytics data from webmethods and sends them on the event channel for processing. Each event type
+// keeps its own last run: the time since the last run is retrieved in windows of at most the configured size, the last
+// run is moved to the end of each window once every file of the window was read. An event type failing to be retrieved
+// does not hold back the other event types, it is retrieved again from its last run on the next poll without sending
+// again the events of the window already sent.
 func (we *WebmethodsEventEmitter) Start() error {
-	strStartTime, strEndTime := we.getLastRun()
-	logrus.Infof("Start time : %s End time :%s", strStartTime, strEndTime)
-	data, err := we.client.GetTransactionsWindow(strStartTime, strEndTime)
-	eventsBytes, err := we.unzipFile(data)
+	if err := we.runBackfill(); err != nil {
+		logrus.WithError(err).Error("failed to backfill transactions")
+	}
+	var firstErr error
+	for _, eventType := range we.eventTypes {
+		if err := we.collectSinceLastRun(eventType); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (we *WebmethodsEventEmitter) collectSinceLastRun(eventType string) error {
+	startTime, endTime := we.getLastRun(eventType)
+	key := progressKey(cacheKeyProgress, eventType)
+	windows := splitWindow(startTime, endTime, we.windowSize)
+	if end, ok := we.progressEnd(key, startTime); ok && end.Before(endTime) {
+		// the window that failed is retrieved again with the same end, so its files are the same
+		// the last run is saved to the second, the rest of the window is dropped when it is shorter
+		windows = append([]timeWindow{{start: startTime, end: end}}, splitWindow(end, endTime.Truncate(time.Second), we.windowSize)...)
+	}
+	for _, w := range windows {
+		strStartTime, strEndTime := w.start.Format(dateFormat), w.end.Format(dateFormat)
+		logrus.WithField("eventType", eventType).Infof("Start time : %s End time :%s", strStartTime, strEndTime)
+		if err := we.collectEventType(eventType, strStartTime, strEndTime, key); err != nil {
+			return err
+		}
+		we.saveLastRun(eventType, strEndTime)
+	}
+	return nil
+}
 
+// collectEventType sends the events of the event type between the start and end times on the event channel. The
+// progress of the window is kept under the progress key when a file fails to be read, the events already sent are
+// skipped when the window is retrieved again.
+func (we *WebmethodsEventEmitter) collectEventType(eventType, strStartTime, strEndTime, key string) error {
+	path, err := we.downloadArchive(eventType, strStartTime, strEndTime)
+	if err != nil {
+		logrus.WithField("eventType", eventType).WithError(err).Error("failed to get transactions data")
+		return err
+	}
+	defer os.Remove(path)
+	progress := we.loadProgress(key, strStartTime, strEndTime)
+	files, err := we.processArchive(path, eventType, progress)
 	if err != nil {
 		logrus.WithError(err).Error("failed to unzip transactions data")
 		return err
 	}
 
-	reader := bytes.NewReader(eventsBytes)
-	scanner := bufio.NewScanner(reader)
-	events := make([]WebmethodsEvent, 0)
-	for scanner.Scan() {
-		lineItem := scanner.Bytes()
-		event := &WebmethodsEvent{}
-		err = json.Unmarshal(lineItem, event)
-		events = append(events, *event)
+	events, rejected, failed := 0, 0, 0
+	for _, file := range files {
+		events += file.events
+		rejected += file.rejected
+		if file.err != nil {
+			failed++
+		}
+	}
+	logrus.
+		WithField("eventType", eventType).
+		WithField("files", len(files)).
+		WithField("rejected", rejected).
+		Infof("Total number of events retrieved  from Webmethods : %d", events)
+	if rejected > 0 && we.rejects != nil {
+		logrus.Warnf("%d transaction events could not be parsed and were written to %s", rejected, we.rejects.path)
+	}
+	if failed > 0 {
+		we.saveProgress(key, progress)
+		return fmt.Errorf("failed to read %d of the %d transactions files, the window will be retrieved again", failed, len(files))
+	}
+	we.saveProgress(key, nil)
+	return nil
+}
+
+// progressKey returns the cache key of the progress of the event type
+func progressKey(prefix, eventType string) string {
+	return prefix + "-" + eventType
+}
+
+// loadProgress returns the progress saved under the key for the window, an empty progress when another window was
+// saved
+func (we *WebmethodsEventEmitter) loadProgress(key, strStartTime, strEndTime string) *windowProgress {
+	if saved := we.savedProgress(key); saved != nil && saved.Start == strStartTime && saved.End == strEndTime {
+		return saved
+	}
+	return &windowProgress{Start: strStartTime, End: strEndTime, Lines: map[string]int{}}
+}
+
+// progressEnd returns the end of the window saved under the key when it starts at the start time
+func (we *WebmethodsEventEmitter) progressEnd(key string, startTime time.Time) (time.Time, bool) {
+	saved := we.savedProgress(key)
+	if saved == nil || saved.Start != startTime.Format(dateFormat) {
+		return time.Time{}, false
 	}
-	logrus.Infof("Total number of events retrieved  from Webmethods : %d", len(events))
+	end, err := time.ParseInLocation(dateFormat, saved.End, &we.timezoneLocation)
+	return end, err == nil
+}
+
+func (we *WebmethodsEventEmitter) savedProgress(key string) *windowProgress {
+	value, err := we.cache.Get(key)
 	if err != nil {
-		logrus.WithFields(logrus.Fields{"strStartTime": strStartTime}).Warn("Unable to Parse Last Time")
+		return nil
+	}
+	data, ok := value.(string)
+	if !ok {
+		return nil
 	}
-	for _, event := range events {
+	progress := &windowProgress{}
+	if err := json.Unmarshal([]byte(data), progress); err != nil || progress.Lines == nil {
+		return nil
+	}
+	return progress
+}
 
+// saveProgress saves the progress under the key, a nil progress removes it
+func (we *WebmethodsEventEmitter) saveProgress(key string, progress *windowProgress) {
+	if progress == nil {
+		if _, err := we.cache.Get(key); err != nil {
+			return
+		}
+		we.cache.Delete(key)
+	} else {
+		data, err := json.Marshal(progress)
 		if err != nil {
-			log.Warnf("failed to marshal event: %s", err.Error())
+			return
+		}
+		if err := we.cache.Set(key, string(data)); err != nil {
+			log.Error("Failed to set value to cache")
 		}
-		we.eventChannel <- event
 	}
-	we.saveLastRun(strEndTime)
-	return nil
+	if err := we.cache.Save(we.cachePath); err != nil {
+		log.Error("Failed to save value to cache")
+	}
 }
 
-func (we *WebmethodsEventEmitter) getLastRun() (string, string) {
-	tStamp, _ := we.cache.Get(CacheKeyTimeStamp)
-	//tNow := fmt.Sprintf("%d-%02d-%d %d:%d:%d", now.Year(), int(now.Month()), now.Day(), now.Hour(), now.Minute(), now.Second())
-	//tNow := now.Format(dateFormat)
-	var tNow string
-	now := time.Now()
-	now = now.In(&we.timezoneLocation)
+// downloadArchive writes the transactions archive of the window to a temporary file of the archive directory and
+// returns its path, so the archive is not kept in memory
+func (we *WebmethodsEventEmitter) downloadArchive(eventType, strStartTime, strEndTime string) (string, error) {
+	f, err := os.CreateTemp(we.archiveDir, "webmethods-transactions-*.zip")
+	if err != nil {
+		return "", err
+	}
+	err = we.client.GetTransactionsWindow(eventType, strStartTime, strEndTime, f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// lastRunKey returns the cache key of the last run of the event type
+func lastRunKey(eventType string) string {
+	return CacheKeyTimeStamp + "-" + eventType
+}
+
+// getLastRun returns the window of the event type to retrieve, from its last run up to now minus the analytics delay.
+// The start of the window is moved forward when it is further in the past than the max look back.
+func (we *WebmethodsEventEmitter) getLastRun(eventType string) (time.Time, time.Time) {
+	now := time.Now().In(&we.timezoneLocation)
+	endTime := now.Add(-we.analyticsDelay)
+	startTime := now.Add(-we.analyticsDelay * 2)
+	tStamp, _ := we.cache.Get(lastRunKey(eventType))
 	if tStamp == nil {
-		tStamp = now.Add(-we.analyticsDelay * 2).Format(dateFormat)
-		tNow = now.Add(-we.analyticsDelay).Format(dateFormat)
-	} else {
-		tNow = now.Add(-we.analyticsDelay).Format(dateFormat)
+		tStamp, _ = we.cache.Get(CacheKeyTimeStamp)
+	}
+	if tStamp != nil {
+		lastRun, err := time.ParseInLocation(dateFormat, fmt.Sprint(tStamp), &we.timezoneLocation)
+		if err != nil {
+			logrus.WithField("lastRun", tStamp).Warn("Unable to Parse Last Time")
+		} else {
+			startTime = lastRun
+		}
+	}
+	if we.maxLookback > 0 && startTime.Before(endTime.Add(-we.maxLookback)) {
+		logrus.
+			WithField("lastRun", startTime.Format(dateFormat)).
+			Warnf("last run is older than the max look back of %s, transactions before %s are skipped", we.maxLookback, endTime.Add(-we.maxLookback).Format(dateFormat))
+		startTime = endTime.Add(-we.maxLookback)
 	}
-	return tStamp.(string), tNow
+	return startTime, endTime
 }
-func (we *WebmethodsEventEmitter) saveLastRun(lastTime string) {
-	err := we.cache.Set(CacheKeyTimeStamp, lastTime)
+
+// timeWindow is a range of transactions retrieved in a single request
+type timeWindow struct {
+	start time.Time
+	end   time.Time
+}
+
+// splitWindow splits the range in consecutive windows of at most the given size, a size of 0 keeps a single window
+func splitWindow(start, end time.Time, size time.Duration) []timeWindow {
+	windows := []timeWindow{}
+	if !start.Before(end) {
+		return windows
+	}
+	if size <= 0 {
+		return append(windows, timeWindow{start: start, end: end})
+	}
+	for start.Before(end) {
+		next := start.Add(size)
+		if next.After(end) {
+			next = end
+		}
+		windows = append(windows, timeWindow{start: start, end: next})
+		start = next
+	}
+	return windows
+}
+
+func (we *WebmethodsEventEmitter) saveLastRun(eventType, lastTime string) {
+	err := we.cache.Set(lastRunKey(eventType), lastTime)
 	if err != nil {
 		log.Error("Failed to set value to cache")
 	}
@@ -0,0 +1,356 @@
+package traceability
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"hash/crc32"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	coreapi "github.com/Axway/agent-sdk/pkg/api"
+	"github.com/Axway/agent-sdk/pkg/cache"
+	"github.com/Axway/agents-webmethods/pkg/config"
+	"github.com/Axway/agents-webmethods/pkg/webmethods"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTransactionsArchive(t *testing.T, files map[string]string) []byte {
+	buf := &bytes.Buffer{}
+	w := zip.NewWriter(buf)
+	for name, content := range files {
+		f, err := w.Create(name)
+		assert.Nil(t, err)
+		_, err = f.Write([]byte(content))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, w.Close())
+	return buf.Bytes()
+}
+
+// writeTransactionsArchive writes the archive to a file and returns its path
+func writeTransactionsArchive(t *testing.T, archive []byte) string {
+	path := filepath.Join(t.TempDir(), "transactions.zip")
+	assert.Nil(t, os.WriteFile(path, archive, 0600))
+	return path
+}
+
+func TestProcessArchiveReadsEveryFile(t *testing.T) {
+	archive := newTransactionsArchive(t, map[string]string{
+		"transactions-1.json": "{\"apiId\":\"api-1\"}\n{\"apiId\":\"api-2\"}\n",
+		"transactions-2.json": "{\"apiId\":\"api-3\"}\nnot json\n\n",
+	})
+	eventChannel := make(chan WebmethodsEvent, 10)
+	rejectsPath := filepath.Join(t.TempDir(), "rejects.jsonl")
+	we := &WebmethodsEventEmitter{eventChannel: eventChannel, rejects: newRejectsWriter(rejectsPath)}
+
+	files, err := we.processArchive(writeTransactionsArchive(t, archive), webmethods.TransactionalEvents, nil)
+	assert.Nil(t, err)
+	close(eventChannel)
+
+	counts := map[string][2]int{}
+	for _, file := range files {
+		counts[file.name] = [2]int{file.events, file.rejected}
+	}
+	assert.Equal(t, map[string][2]int{"transactions-1.json": {2, 0}, "transactions-2.json": {1, 1}}, counts)
+	apis := []string{}
+	for event := range eventChannel {
+		apis = append(apis, event.ApiId)
+	}
+	assert.ElementsMatch(t, []string{"api-1", "api-2", "api-3"}, apis)
+}
+
+func TestProcessArchiveRejectsInvalidArchive(t *testing.T) {
+	we := &WebmethodsEventEmitter{eventChannel: make(chan WebmethodsEvent)}
+	_, err := we.processArchive(writeTransactionsArchive(t, []byte("not a zip")), webmethods.TransactionalEvents, nil)
+	assert.NotNil(t, err)
+}
+
+func TestProcessEventsReadsLargeLinesAndPersistsRejects(t *testing.T) {
+	large := "{\"apiId\":\"api-1\",\"requestBody\":\"" + strings.Repeat("a", 1024*1024) + "\"}"
+	eventChannel := make(chan WebmethodsEvent, 10)
+	rejectsPath := filepath.Join(t.TempDir(), "rejects.jsonl")
+	we := &WebmethodsEventEmitter{eventChannel: eventChannel, rejects: newRejectsWriter(rejectsPath)}
+
+	stats := transactionFileStats{name: "transactions.json"}
+	assert.Nil(t, we.processEvents(strings.NewReader(large+"\n{\"apiId\":\n{\"apiId\":\"api-2\"}"), &stats, 0))
+	assert.Equal(t, 2, stats.events)
+	assert.Equal(t, 1, stats.rejected)
+
+	data, err := os.ReadFile(rejectsPath)
+	assert.Nil(t, err)
+	rejected := rejectedEvent{}
+	assert.Nil(t, json.Unmarshal(data, &rejected))
+	assert.Equal(t, "transactions.json", rejected.File)
+	assert.Equal(t, 2, rejected.Line)
+	assert.Equal(t, "{\"apiId\":", rejected.Record)
+}
+
+func newTestEmitter(t *testing.T, send func(request coreapi.Request) (*coreapi.Response, error)) *WebmethodsEventEmitter {
+	client, err := webmethods.NewClient(&config.WebMethodConfig{}, &webmethods.MockClient{SendFunc: send})
+	assert.Nil(t, err)
+	dir := t.TempDir()
+	return &WebmethodsEventEmitter{
+		client:           client,
+		eventChannel:     make(chan WebmethodsEvent, 10),
+		cache:            cache.New(),
+		cachePath:        formatCachePath(dir),
+		timezoneLocation: *time.UTC,
+		analyticsDelay:   time.Minute,
+		rejects:          newRejectsWriter(formatRejectsPath(dir)),
+		backfillPath:     FormatBackfillPath(dir),
+		archiveDir:       dir,
+		eventTypes:       []string{webmethods.TransactionalEvents},
+	}
+}
+
+func TestStartKeepsLastRunWhenWindowIsNotRetrieved(t *testing.T) {
+	we := newTestEmitter(t, func(request coreapi.Request) (*coreapi.Response, error) {
+		return &coreapi.Response{Code: http.StatusInternalServerError}, nil
+	})
+	assert.NotNil(t, we.Start())
+	_, err := we.cache.Get(lastRunKey(webmethods.TransactionalEvents))
+	assert.NotNil(t, err)
+
+	archive := newTransactionsArchive(t, map[string]string{"transactions.json": "{\"apiId\":\"api-1\"}\n"})
+	we.client, _ = webmethods.NewClient(&config.WebMethodConfig{}, &webmethods.MockClient{
+		SendFunc: func(request coreapi.Request) (*coreapi.Response, error) {
+			return &coreapi.Response{Code: http.StatusOK, Body: archive}, nil
+		},
+	})
+	assert.Nil(t, we.Start())
+	_, err = we.cache.Get(lastRunKey(webmethods.TransactionalEvents))
+	assert.Nil(t, err)
+	// the downloaded archives are removed once read
+	archives, _ := filepath.Glob(filepath.Join(we.archiveDir, "webmethods-transactions-*"))
+	assert.Empty(t, archives)
+}
+
+// newCorruptedTransactionsArchive returns an archive holding the files, the checksum of the corrupted file does not
+// match so it fails to be read at its end
+func newCorruptedTransactionsArchive(t *testing.T, files map[string]string, corrupted string) []byte {
+	buf := &bytes.Buffer{}
+	w := zip.NewWriter(buf)
+	for name, content := range files {
+		header := &zip.FileHeader{Name: name, Method: zip.Store, CRC32: crc32.ChecksumIEEE([]byte(content))}
+		header.CompressedSize64 = uint64(len(content))
+		header.UncompressedSize64 = uint64(len(content))
+		if name == corrupted {
+			header.CRC32++
+		}
+		f, err := w.CreateRaw(header)
+		assert.Nil(t, err)
+		_, err = f.Write([]byte(content))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestStartSkipsEventsSentBeforeTheWindowFailed(t *testing.T) {
+	files := map[string]string{
+		"transactions-1.json": "{\"apiId\":\"api-1\"}\n",
+		"transactions-2.json": "{\"apiId\":\"api-2\"}\n{\"apiId\":\"api-3\"}\n{\"apiId\":\"api-4\"}",
+	}
+	archive := newCorruptedTransactionsArchive(t, files, "transactions-2.json")
+	windows := [][2]string{}
+	we := newTestEmitter(t, func(request coreapi.Request) (*coreapi.Response, error) {
+		windows = append(windows, [2]string{request.QueryParams["startDate"], request.QueryParams["endDate"]})
+		return &coreapi.Response{Code: http.StatusOK, Body: archive}, nil
+	})
+	we.eventChannel = make(chan WebmethodsEvent, 100)
+	lastRun := time.Now().UTC().Add(-time.Hour).Format(dateFormat)
+	we.cache.Set(lastRunKey(webmethods.TransactionalEvents), lastRun)
+
+	assert.NotNil(t, we.Start())
+	collected, _ := we.cache.Get(lastRunKey(webmethods.TransactionalEvents))
+	assert.Equal(t, lastRun, collected)
+
+	// the window is retrieved again, only the events that were not sent are sent
+	archive = newTransactionsArchive(t, files)
+	assert.Nil(t, we.Start())
+	close(we.eventChannel)
+	apis := []string{}
+	for event := range we.eventChannel {
+		apis = append(apis, event.ApiId)
+	}
+	assert.ElementsMatch(t, []string{"api-1", "api-2", "api-3", "api-4"}, apis)
+	assert.Len(t, windows, 2)
+	assert.Equal(t, windows[0], windows[1])
+	_, err := we.cache.Get(progressKey(cacheKeyProgress, webmethods.TransactionalEvents))
+	assert.NotNil(t, err)
+}
+
+func TestSplitWindow(t *testing.T) {
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	windows := splitWindow(start, start.Add(40*time.Minute), 15*time.Minute)
+	ends := []string{}
+	for _, w := range windows {
+		ends = append(ends, w.end.Format(dateFormat))
+	}
+	assert.Equal(t, []string{"2024-05-01 10:15:00", "2024-05-01 10:30:00", "2024-05-01 10:40:00"}, ends)
+	assert.Equal(t, start, windows[0].start)
+	assert.Equal(t, windows[0].end, windows[1].start)
+
+	assert.Len(t, splitWindow(start, start.Add(40*time.Minute), 0), 1)
+	assert.Empty(t, splitWindow(start, start, 15*time.Minute))
+}
+
+func newWindowRecorder(t *testing.T) (*[][2]string, func(request coreapi.Request) (*coreapi.Response, error)) {
+	archive := newTransactionsArchive(t, map[string]string{"transactions.json": "{\"apiId\":\"api-1\"}\n"})
+	windows := &[][2]string{}
+	return windows, func(request coreapi.Request) (*coreapi.Response, error) {
+		*windows = append(*windows, [2]string{request.QueryParams["startDate"], request.QueryParams["endDate"]})
+		return &coreapi.Response{Code: http.StatusOK, Body: archive}, nil
+	}
+}
+
+func TestStartSplitsGapsWithinMaxLookback(t *testing.T) {
+	windows, send := newWindowRecorder(t)
+	we := newTestEmitter(t, send)
+	we.eventChannel = make(chan WebmethodsEvent, 100)
+	we.windowSize = time.Hour
+	we.maxLookback = 3 * time.Hour
+	we.cache.Set(lastRunKey(webmethods.TransactionalEvents), time.Now().UTC().Add(-48*time.Hour).Format(dateFormat))
+
+	assert.Nil(t, we.Start())
+	assert.Len(t, *windows, 3)
+	for i := 1; i < len(*windows); i++ {
+		assert.Equal(t, (*windows)[i-1][1], (*windows)[i][0])
+	}
+	lastRun, _ := we.cache.Get(lastRunKey(webmethods.TransactionalEvents))
+	assert.Equal(t, (*windows)[2][1], lastRun)
+}
+
+func TestStartKeepsLastRunOfEachEventType(t *testing.T) {
+	archive := newTransactionsArchive(t, map[string]string{"transactions.json": "{\"apiId\":\"api-1\"}\n"})
+	requested := []string{}
+	failing := webmethods.ErrorEvents
+	we := newTestEmitter(t, func(request coreapi.Request) (*coreapi.Response, error) {
+		requested = append(requested, request.QueryParams["eventType"]+" "+request.QueryParams["startDate"])
+		if request.QueryParams["eventType"] == failing {
+			return &coreapi.Response{Code: http.StatusInternalServerError}, nil
+		}
+		return &coreapi.Response{Code: http.StatusOK, Body: archive}, nil
+	})
+	we.eventChannel = make(chan WebmethodsEvent, 100)
+	we.eventTypes = []string{webmethods.TransactionalEvents, webmethods.ErrorEvents, webmethods.PolicyViolationEvents}
+	lastRun := time.Now().UTC().Add(-time.Hour).Format(dateFormat)
+	we.cache.Set(CacheKeyTimeStamp, lastRun)
+
+	// the failing event type does not hold back the event types after it
+	assert.NotNil(t, we.Start())
+	assert.Len(t, requested, 3)
+	for _, eventType := range []string{webmethods.TransactionalEvents, webmethods.PolicyViolationEvents} {
+		collected, err := we.cache.Get(lastRunKey(eventType))
+		assert.Nil(t, err)
+		assert.NotEqual(t, lastRun, collected)
+	}
+	_, err := we.cache.Get(lastRunKey(webmethods.ErrorEvents))
+	assert.NotNil(t, err)
+
+	// the failed event type is retrieved again from the last run
+	failing = ""
+	requested = []string{}
+	assert.Nil(t, we.Start())
+	assert.Contains(t, requested, webmethods.ErrorEvents+" "+lastRun)
+	assert.NotContains(t, requested, webmethods.TransactionalEvents+" "+lastRun)
+}
+
+func TestBackfillKeepsLastRun(t *testing.T) {
+	windows, send := newWindowRecorder(t)
+	we := newTestEmitter(t, send)
+	we.eventChannel = make(chan WebmethodsEvent, 100)
+	we.windowSize = time.Hour
+	assert.Nil(t, RequestBackfill(we.backfillPath, "2024-05-01 10:00:00", "2024-05-01 12:30:00"))
+	assert.NotNil(t, RequestBackfill(we.backfillPath, "2024-05-01 12:30:00", "2024-05-01 10:00:00"))
+
+	// a single window is collected on each poll
+	assert.Nil(t, we.runBackfill())
+	assert.Equal(t, [][2]string{{"2024-05-01 10:00:00", "2024-05-01 11:00:00"}}, *windows)
+	request, err := loadBackfill(we.backfillPath)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{webmethods.TransactionalEvents: "2024-05-01 11:00:00"}, request.Checkpoints)
+
+	assert.Nil(t, we.runBackfill())
+	assert.Nil(t, we.runBackfill())
+	assert.Equal(t, [][2]string{
+		{"2024-05-01 10:00:00", "2024-05-01 11:00:00"},
+		{"2024-05-01 11:00:00", "2024-05-01 12:00:00"},
+		{"2024-05-01 12:00:00", "2024-05-01 12:30:00"},
+	}, *windows)
+	_, err = os.Stat(we.backfillPath)
+	assert.True(t, os.IsNotExist(err))
+	_, err = we.cache.Get(lastRunKey(webmethods.TransactionalEvents))
+	assert.NotNil(t, err)
+}
+
+func TestStartCollectsOneBackfillWindowAndTheLiveWindow(t *testing.T) {
+	windows, send := newWindowRecorder(t)
+	we := newTestEmitter(t, send)
+	we.eventChannel = make(chan WebmethodsEvent, 100)
+	we.windowSize = time.Hour
+	assert.Nil(t, RequestBackfill(we.backfillPath, "2024-05-01 10:00:00", "2024-05-01 12:30:00"))
+
+	assert.Nil(t, we.Start())
+	assert.Len(t, *windows, 2)
+	assert.Equal(t, [2]string{"2024-05-01 10:00:00", "2024-05-01 11:00:00"}, (*windows)[0])
+	lastRun, _ := we.cache.Get(lastRunKey(webmethods.TransactionalEvents))
+	assert.Equal(t, (*windows)[1][1], lastRun)
+}
+
+func TestBackfillResumesFromCheckpoint(t *testing.T) {
+	windows, send := newWindowRecorder(t)
+	we := newTestEmitter(t, send)
+	we.windowSize = time.Hour
+	assert.Nil(t, saveBackfill(we.backfillPath, &BackfillRequest{
+		From:       "2024-05-01 10:00:00",
+		To:         "2024-05-01 12:00:00",
+		Checkpoint: "2024-05-01 11:00:00",
+	}))
+
+	assert.Nil(t, we.runBackfill())
+	assert.Equal(t, [][2]string{{"2024-05-01 11:00:00", "2024-05-01 12:00:00"}}, *windows)
+}
+
+func TestBackfillKeepsCheckpointOfEachEventType(t *testing.T) {
+	archive := newTransactionsArchive(t, map[string]string{"transactions.json": "{\"apiId\":\"api-1\"}\n"})
+	requested := []string{}
+	failing := webmethods.ErrorEvents
+	we := newTestEmitter(t, func(request coreapi.Request) (*coreapi.Response, error) {
+		requested = append(requested, request.QueryParams["eventType"]+" "+request.QueryParams["startDate"])
+		if request.QueryParams["eventType"] == failing {
+			return &coreapi.Response{Code: http.StatusInternalServerError}, nil
+		}
+		return &coreapi.Response{Code: http.StatusOK, Body: archive}, nil
+	})
+	we.eventChannel = make(chan WebmethodsEvent, 100)
+	we.windowSize = time.Hour
+	we.eventTypes = []string{webmethods.TransactionalEvents, webmethods.ErrorEvents}
+	assert.Nil(t, RequestBackfill(we.backfillPath, "2024-05-01 10:00:00", "2024-05-01 12:00:00"))
+
+	// the failing event type does not hold back the other event type
+	assert.NotNil(t, we.runBackfill())
+	request, err := loadBackfill(we.backfillPath)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{webmethods.TransactionalEvents: "2024-05-01 11:00:00"}, request.Checkpoints)
+
+	// only the failed window of the failed event type is collected again
+	failing = ""
+	requested = []string{}
+	assert.Nil(t, we.runBackfill())
+	assert.Equal(t, []string{
+		webmethods.TransactionalEvents + " 2024-05-01 11:00:00",
+		webmethods.ErrorEvents + " 2024-05-01 10:00:00",
+	}, requested)
+
+	requested = []string{}
+	assert.Nil(t, we.runBackfill())
+	assert.Equal(t, []string{webmethods.ErrorEvents + " 2024-05-01 11:00:00"}, requested)
+	_, err = os.Stat(we.backfillPath)
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,228 @@
+package traceability
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Axway/agents-webmethods/pkg/config"
+)
+
+// redactedValue replaces the masked values
+const redactedValue = "****"
+
+// jsonPathSegment is a key of a JSON path, deep segments match the key at any depth below the previous segment
+type jsonPathSegment struct {
+	key  string
+	deep bool
+}
+
+// redactor masks the sensitive data of the transaction events before they are sent to Central. Header, query argument
+// and custom field values are masked by name, JSON payload fields by path, and the patterns are masked in the payloads,
+// query argument and custom field values. A custom field is also masked when its header is a masked header. Payloads are truncated to the max payload size, and dropped when it is 0.
+type redactor struct {
+	headers        map[string]bool
+	queryArgs      map[string]bool
+	customFields   map[string]bool
+	jsonPaths      [][]jsonPathSegment
+	patterns       []*regexp.Regexp
+	maxPayloadSize int
+}
+
+func newRedactor(cfg *config.WebMethodConfig) (*redactor, error) {
+	r := &redactor{
+		headers:        headerSet(cfg.RedactHeaders),
+		queryArgs:      headerSet(cfg.RedactQueryArgs),
+		customFields:   headerSet(cfg.RedactCustomFields),
+		maxPayloadSize: cfg.MaxPayloadSize,
+	}
+	for _, path := range cfg.RedactJSONPaths {
+		segments, err := parseJSONPath(path)
+		if err != nil {
+			return nil, err
+		}
+		r.jsonPaths = append(r.jsonPaths, segments)
+	}
+	for _, pattern := range cfg.RedactPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %s: %s", pattern, err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	return r, nil
+}
+
+// parseJSONPath parses paths like $.card.number, $..cvv or $.items[*].card, array elements are always traversed so
+// [*] is optional
+func parseJSONPath(path string) ([]jsonPathSegment, error) {
+	rest := strings.TrimPrefix(strings.TrimSpace(path), "$")
+	segments := []jsonPathSegment{}
+	for rest != "" {
+		deep := strings.HasPrefix(rest, "..")
+		if !deep && !strings.HasPrefix(rest, ".") {
+			return nil, fmt.Errorf("invalid redaction JSON path %s", path)
+		}
+		rest = strings.TrimLeft(rest, ".")
+		end := strings.Index(rest, ".")
+		if end == -1 {
+			end = len(rest)
+		}
+		key := strings.ReplaceAll(rest[:end], "[*]", "")
+		if key == "" || strings.ContainsAny(key, "[]") {
+			return nil, fmt.Errorf("invalid redaction JSON path %s", path)
+		}
+		segments = append(segments, jsonPathSegment{key: key, deep: deep})
+		rest = rest[end:]
+	}
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("invalid redaction JSON path %s", path)
+	}
+	return segments, nil
+}
+
+// redact returns the event with its sensitive data masked
+func (r *redactor) redact(event WebmethodsEvent) WebmethodsEvent {
+	event.RequestHeaders = r.redactHeaders(event.RequestHeaders)
+	event.ResponseHeaders = r.redactHeaders(event.ResponseHeaders)
+	event.NativeRequestHeaders = r.redactHeaders(event.NativeRequestHeaders)
+	event.NativeResponseHeaders = r.redactHeaders(event.NativeResponseHeaders)
+	event.QueryParameters = r.redactArgs(event.QueryParameters)
+	event.CustomFields = r.redactCustomFields(event.CustomFields)
+	event.NativeURL = r.redactURL(event.NativeURL)
+	calls := make([]BackendCall, len(event.ExternalCalls))
+	for i, call := range event.ExternalCalls {
+		call.ExternalURL = r.redactURL(call.ExternalURL)
+		calls[i] = call
+	}
+	event.ExternalCalls = calls
+	event.ReqPayload = r.redactPayload(event.ReqPayload)
+	event.ResPayload = r.redactPayload(event.ResPayload)
+	event.NativeReqPayload = r.redactPayload(event.NativeReqPayload)
+	event.NativeResPayload = r.redactPayload(event.NativeResPayload)
+	return event
+}
+
+func (r *redactor) redactHeaders(headers HttpHeaders) HttpHeaders {
+	if headers == nil {
+		return nil
+	}
+	redacted := HttpHeaders{}
+	for name, value := range headers {
+		if r.headers[strings.ToLower(name)] {
+			value = redactedValue
+		}
+		redacted[name] = value
+	}
+	return redacted
+}
+
+func (r *redactor) redactArgs(args map[string][]string) map[string][]string {
+	if args == nil {
+		return nil
+	}
+	redacted := map[string][]string{}
+	for name, values := range args {
+		masked := make([]string, len(values))
+		for i, value := range values {
+			if r.queryArgs[strings.ToLower(name)] {
+				masked[i] = redactedValue
+			} else {
+				masked[i] = r.maskPatterns(value)
+			}
+		}
+		redacted[name] = masked
+	}
+	return redacted
+}
+
+func (r *redactor) redactCustomFields(fields map[string]interface{}) map[string]interface{} {
+	if fields == nil {
+		return nil
+	}
+	redacted := map[string]interface{}{}
+	for name, value := range fields {
+		switch {
+		case value == nil:
+			redacted[name] = nil
+		case r.customFields[strings.ToLower(name)] || r.headers[strings.ToLower(customFieldHeaderPrefix+name)]:
+			redacted[name] = redactedValue
+		default:
+			redacted[name] = r.maskPatterns(fmt.Sprint(value))
+		}
+	}
+	return redacted
+}
+
+func (r *redactor) redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.RawQuery == "" {
+		return rawURL
+	}
+	u.RawQuery = url.Values(r.redactArgs(u.Query())).Encode()
+	return u.String()
+}
+
+// redactPayload masks the JSON paths when the payload is JSON, then the patterns, and truncates the result
+func (r *redactor) redactPayload(payload string) string {
+	if payload == "" || r.maxPayloadSize <= 0 {
+		return ""
+	}
+	if len(r.jsonPaths) > 0 {
+		var body interface{}
+		if err := json.Unmarshal([]byte(payload), &body); err == nil {
+			for _, path := range r.jsonPaths {
+				body = maskJSONPath(body, path)
+			}
+			if data, err := json.Marshal(body); err == nil {
+				payload = string(data)
+			}
+		}
+	}
+	return truncate(r.maskPatterns(payload), r.maxPayloadSize)
+}
+
+func (r *redactor) maskPatterns(value string) string {
+	for _, re := range r.patterns {
+		value = re.ReplaceAllString(value, redactedValue)
+	}
+	return value
+}
+
+// maskJSONPath replaces the values matching the path, the elements of arrays are traversed with the same path
+func maskJSONPath(node interface{}, path []jsonPathSegment) interface{} {
+	if len(path) == 0 {
+		return redactedValue
+	}
+	switch node := node.(type) {
+	case []interface{}:
+		for i, item := range node {
+			node[i] = maskJSONPath(item, path)
+		}
+	case map[string]interface{}:
+		segment := path[0]
+		for key, value := range node {
+			if key == segment.key {
+				node[key] = maskJSONPath(value, path[1:])
+			} else if segment.deep {
+				node[key] = maskJSONPath(value, path)
+			}
+		}
+	}
+	return node
+}
+
+// truncate cuts the value to at most size bytes without splitting a character
+func truncate(value string, size int) string {
+	if len(value) <= size {
+		return value
+	}
+	value = value[:size]
+	for len(value) > 0 && !utf8.ValidString(value) {
+		value = value[:len(value)-1]
+	}
+	return value
+}
@@ -0,0 +1,68 @@
+package traceability
+
+import (
+	"testing"
+
+	"github.com/Axway/agents-webmethods/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRedactor(t *testing.T) *redactor {
+	r, err := newRedactor(&config.WebMethodConfig{
+		RedactHeaders:      []string{"Authorization"},
+		RedactQueryArgs:    []string{"apikey"},
+		RedactCustomFields: []string{"clientSecret"},
+		RedactJSONPaths:    []string{"$.card.number", "$..cvv", "$.items[*].secret"},
+		RedactPatterns:     []string{`\b\d{16}\b`},
+		MaxPayloadSize:     200,
+	})
+	assert.Nil(t, err)
+	return r
+}
+
+func TestRedactEvent(t *testing.T) {
+	r := newTestRedactor(t)
+	event := r.redact(WebmethodsEvent{
+		RequestHeaders:  HttpHeaders{"authorization": "Basic abc", "Accept": "*/*"},
+		QueryParameters: Parameters{"apikey": {"key"}, "card": {"4111111111111111"}, "limit": {"10"}},
+		ExternalCalls:   []BackendCall{{ExternalURL: "https://backend/pets?apikey=key&limit=10"}},
+		ReqPayload:      `{"card":{"number":"4111","cvv":"123"},"items":[{"secret":"s","id":1}],"nested":{"cvv":"456"}}`,
+		ResPayload:      "card 4111111111111111 accepted",
+		CustomFields:    map[string]interface{}{"ClientSecret": "secret", "card": 4111111111111111, "region": "eu", "empty": nil},
+	})
+
+	assert.Equal(t, HttpHeaders{"authorization": redactedValue, "Accept": "*/*"}, event.RequestHeaders)
+	assert.Equal(t, Parameters{"apikey": {redactedValue}, "card": {redactedValue}, "limit": {"10"}}, event.QueryParameters)
+	assert.Equal(t, "https://backend/pets?apikey=%2A%2A%2A%2A&limit=10", event.ExternalCalls[0].ExternalURL)
+	assert.JSONEq(t, `{"card":{"number":"****","cvv":"****"},"items":[{"secret":"****","id":1}],"nested":{"cvv":"****"}}`, event.ReqPayload)
+	assert.Equal(t, "card **** accepted", event.ResPayload)
+	assert.Equal(t, map[string]interface{}{"ClientSecret": redactedValue, "card": redactedValue, "region": "eu", "empty": nil}, event.CustomFields)
+
+	// a custom field is masked when its header is masked
+	r, err := newRedactor(&config.WebMethodConfig{RedactHeaders: []string{"X-Webmethods-Custom-Token"}})
+	assert.Nil(t, err)
+	event = r.redact(WebmethodsEvent{CustomFields: map[string]interface{}{"token": "abc"}})
+	assert.Equal(t, map[string]interface{}{"token": redactedValue}, event.CustomFields)
+}
+
+func TestRedactPayloadSize(t *testing.T) {
+	r := newTestRedactor(t)
+	r.maxPayloadSize = 5
+	assert.Equal(t, "héll", r.redactPayload("héllo world"))
+
+	r.maxPayloadSize = 0
+	assert.Equal(t, "", r.redactPayload("hello"))
+}
+
+func TestParseJSONPath(t *testing.T) {
+	segments, err := parseJSONPath("$.items[*]..number")
+	assert.Nil(t, err)
+	assert.Equal(t, []jsonPathSegment{{key: "items"}, {key: "number", deep: true}}, segments)
+
+	for _, path := range []string{"", "$", "card", "$.card[0]", "$.card."} {
+		_, err := parseJSONPath(path)
+		assert.NotNil(t, err, path)
+	}
+	_, err = newRedactor(&config.WebMethodConfig{RedactPatterns: []string{"("}})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,68 @@
+package traceability
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// maxRejectsSize is the size from which the rejects file is moved aside, only the previous file is kept
+const maxRejectsSize = 10 * 1024 * 1024
+
+// rejectedEvent is the line written to the rejects file for a transaction event that could not be parsed
+type rejectedEvent struct {
+	Time   time.Time `json:"time"`
+	File   string    `json:"file"`
+	Line   int       `json:"line"`
+	Error  string    `json:"error"`
+	Record string    `json:"record"`
+}
+
+// rejectsWriter keeps the transaction events that could not be parsed as JSON lines for inspection
+type rejectsWriter struct {
+	mutex sync.Mutex
+	path  string
+}
+
+func newRejectsWriter(path string) *rejectsWriter {
+	return &rejectsWriter{path: path}
+}
+
+// write appends the rejected record, a nil writer drops it
+func (w *rejectsWriter) write(file string, line int, record []byte, reason error) error {
+	if w == nil {
+		return nil
+	}
+	data, err := json.Marshal(rejectedEvent{
+		Time:   time.Now().UTC(),
+		File:   file,
+		Line:   line,
+		Error:  reason.Error(),
+		Record: string(record),
+	})
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if info, err := os.Stat(w.path); err == nil && info.Size()+int64(len(data)) > maxRejectsSize {
+		if err := os.Rename(w.path, w.path+".1"); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(w.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(data)
+	return err
+}
+
+func formatRejectsPath(path string) string {
+	return fmt.Sprintf("%s/webmethods-rejects.jsonl", path)
+}
@@ -1,5 +1,11 @@
 package traceability
 
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
 // # Transaction event logs
 // 1 EVENT_TYPE
 // 2 ROOT_CONTEXT
@@ -85,46 +91,96 @@ type GwTrafficLogEntry struct {
 }
 
 type WebmethodsEvent struct {
-	EventType       string      `json:"eventType"`
-	SourceGateway   string      `json:"sourceGateway"`
-	CreationDate    int64       `json:"creationDate"`
-	ApiName         string      `json:"apiName"`
-	ApiVersion      string      `json:"apiVersion"`
-	ApiId           string      `json:"apiId"`
-	TotalTime       int         `json:"totalTime"`
-	SessionId       string      `json:"sessionId"`
-	GatewayTime     int         `json:"gatewayTime"`
-	ApplicationName string      `json:"applicationName"`
-	ApplicationIp   string      `json:"applicationIp"`
-	ApplicationId   string      `json:"applicationId"`
-	Status          string      `json:"status"`
-	ReqPayload      string      `json:"reqPayload"`
-	ResPayload      string      `json:"resPayload"`
-	TotalDataSize   int         `json:"totalDataSize"`
-	ResponseCode    string      `json:"responseCode"`
-	OperationName   string      `json:"operationName"`
-	HTTPMethod      string      `json:"httpMethod"`
-	RequestHeaders  HttpHeaders `json:"requestHeaders"`
-	ResponseHeaders HttpHeaders `json:"responseHeaders"`
-	QueryParameters struct {
-	} `json:"queryParameters"`
-	CorrelationID string `json:"correlationID"`
-	CustomFields  struct {
-	} `json:"customFields"`
-	ErrorOrigin           string        `json:"errorOrigin"`
-	NativeRequestHeaders  HttpHeaders   `json:"nativeRequestHeaders"`
-	NativeReqPayload      string        `json:"nativeReqPayload"`
-	NativeResponseHeaders HttpHeaders   `json:"nativeResponseHeaders"`
-	NativeResPayload      string        `json:"nativeResPayload"`
-	NativeHTTPMethod      string        `json:"nativeHttpMethod"`
-	NativeURL             string        `json:"nativeURL"`
-	ServerID              string        `json:"serverID"`
-	ExternalCalls         []BackendCall `json:"externalCalls"`
-	SourceGatewayNode     string        `json:"sourceGatewayNode"`
-	CallbackRequest       bool          `json:"callbackRequest"`
+	EventType             string                 `json:"eventType"`
+	SourceGateway         string                 `json:"sourceGateway"`
+	CreationDate          int64                  `json:"creationDate"`
+	ApiName               string                 `json:"apiName"`
+	ApiVersion            string                 `json:"apiVersion"`
+	ApiId                 string                 `json:"apiId"`
+	TotalTime             int                    `json:"totalTime"`
+	SessionId             string                 `json:"sessionId"`
+	GatewayTime           int                    `json:"gatewayTime"`
+	ApplicationName       string                 `json:"applicationName"`
+	ApplicationIp         string                 `json:"applicationIp"`
+	ApplicationId         string                 `json:"applicationId"`
+	Status                string                 `json:"status"`
+	ReqPayload            string                 `json:"reqPayload"`
+	ResPayload            string                 `json:"resPayload"`
+	TotalDataSize         int                    `json:"totalDataSize"`
+	ResponseCode          string                 `json:"responseCode"`
+	OperationName         string                 `json:"operationName"`
+	HTTPMethod            string                 `json:"httpMethod"`
+	RequestHeaders        HttpHeaders            `json:"requestHeaders"`
+	ResponseHeaders       HttpHeaders            `json:"responseHeaders"`
+	QueryParameters       Parameters             `json:"queryParameters"`
+	CorrelationID         string                 `json:"correlationID"`
+	CustomFields          map[string]interface{} `json:"customFields"`
+	ErrorOrigin           string                 `json:"errorOrigin"`
+	NativeRequestHeaders  HttpHeaders            `json:"nativeRequestHeaders"`
+	NativeReqPayload      string                 `json:"nativeReqPayload"`
+	NativeResponseHeaders HttpHeaders            `json:"nativeResponseHeaders"`
+	NativeResPayload      string                 `json:"nativeResPayload"`
+	NativeHTTPMethod      string                 `json:"nativeHttpMethod"`
+	NativeURL             string                 `json:"nativeURL"`
+	ServerID              string                 `json:"serverID"`
+	ExternalCalls         []BackendCall          `json:"externalCalls"`
+	SourceGatewayNode     string                 `json:"sourceGatewayNode"`
+	CallbackRequest       bool                   `json:"callbackRequest"`
+	ErrorDesc             string                 `json:"errorDesc"`
+	AlertDesc             string                 `json:"alertDesc"`
+	PolicyName            string                 `json:"policyName"`
+	// failureReason is the reason of an error or policy violation event, reported as status text of the inbound leg
+	failureReason string
+}
+
+// HttpHeaders are the headers of a request or response by name, multiple values are joined with a comma
+type HttpHeaders map[string]string
+
+// UnmarshalJSON accepts header values given as strings, numbers or lists
+func (h *HttpHeaders) UnmarshalJSON(data []byte) error {
+	values, err := unmarshalValues(data)
+	if err != nil {
+		return err
+	}
+	headers := HttpHeaders{}
+	for name, value := range values {
+		headers[name] = strings.Join(value, ", ")
+	}
+	*h = headers
+	return nil
+}
+
+// Parameters are the query parameters of a request by name
+type Parameters map[string][]string
+
+// UnmarshalJSON accepts parameter values given as strings, numbers or lists
+func (p *Parameters) UnmarshalJSON(data []byte) error {
+	values, err := unmarshalValues(data)
+	if err != nil {
+		return err
+	}
+	*p = values
+	return nil
 }
 
-type HttpHeaders struct {
+func unmarshalValues(data []byte) (map[string][]string, error) {
+	raw := map[string]interface{}{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	values := map[string][]string{}
+	for name, value := range raw {
+		switch value := value.(type) {
+		case nil:
+		case []interface{}:
+			for _, item := range value {
+				values[name] = append(values[name], fmt.Sprint(item))
+			}
+		default:
+			values[name] = []string{fmt.Sprint(value)}
+		}
+	}
+	return values, nil
 }
 
 type BackendCall struct {
@@ -4,6 +4,7 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"strings"
 
@@ -29,6 +30,9 @@ var (
 	token string
 )
 
+// ErrStrategyNotFound is returned when the requested strategy does not exist on the gateway
+var ErrStrategyNotFound = agenterrors.New(2002, "Strategy not found")
+
 // Client interface to gateway
 type Client interface {
 	createAuthToken() string
@@ -43,17 +47,26 @@ type Client interface {
 	UpdateApplication(application *Application) (*Application, error)
 	SubscribeApplication(applicationId string, ApplicationApiSubscription *ApplicationApiSubscription) error
 	GetApplication(applicationId string) (*ApplicationResponse, error)
+	ListApplications() (*ApplicationResponse, error)
 	RotateApplicationApikey(applicationId string) error
+	SuspendApplication(applicationId string) error
+	EnableApplication(applicationId string) error
 	CreateOauth2Strategy(strategy *Strategy) (*StrategyResponse, error)
 	DeleteStrategy(strategyId string) error
 	RefereshOauth2Credential(strategyId string) (*StrategyResponse, error)
 	GetStrategy(strategyId string) (*StrategyResponse, error)
+	UpdateStrategy(strategy *Strategy) (*StrategyResponse, error)
 	DeleteApplication(applicationId string) error
 	OnConfigChange(webMethodConfig *config.WebMethodConfig) error
 	DeleteApplicationAccessTokens(applicationId string) error
 	UnsubscribeApplication(applicationId string, apiId string) error
 	ListOauth2Servers() (*OauthServers, error)
-	GetTransactionsWindow(startDate, endDate string) ([]byte, error)
+	ListPackages() (*PackageResponse, error)
+	ListPlans() (*PlanResponse, error)
+	CreateSubscription(subscription *Subscription) (*SubscriptionResponse, error)
+	UpdateSubscription(subscription *Subscription) (*SubscriptionResponse, error)
+	DeleteSubscription(subscriptionId string) error
+	GetTransactionsWindow(eventType, startDate, endDate string, w io.Writer) error
 	Healthcheck(name string) (status *hc.Status)
 }
 
@@ -304,6 +317,14 @@ func (c *WebMethodClient) FindApplicationByName(applicationName string) (*Search
 	if err != nil {
 		return nil, err
 	}
+	// the search matches keywords, keep only the applications with exactly the requested name
+	exact := []SearchApplication{}
+	for _, application := range applicationResponse.SearchApplication {
+		if application.Name == applicationName {
+			exact = append(exact, application)
+		}
+	}
+	applicationResponse.SearchApplication = exact
 	return applicationResponse, nil
 }
 
@@ -331,6 +352,34 @@ func (c *WebMethodClient) GetApplication(applicationId string) (*ApplicationResp
 	return applicationResponse, nil
 }
 
+// ListApplications lists all the applications on the gateway
+func (c *WebMethodClient) ListApplications() (*ApplicationResponse, error) {
+	applicationResponse := &ApplicationResponse{}
+	url := fmt.Sprintf("%s/rest/apigateway/applications", c.url)
+	headers := map[string]string{
+		"Authorization": c.createAuthToken(),
+		"Accept":        "application/json",
+	}
+	request := coreapi.Request{
+		Method:  coreapi.GET,
+		URL:     url,
+		Headers: headers,
+	}
+	response, err := c.httpClient.Send(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.Code != http.StatusOK {
+		return nil, agenterrors.Newf(2001, "Unable to list Applications")
+	}
+
+	err = json.Unmarshal(response.Body, applicationResponse)
+	if err != nil {
+		return nil, err
+	}
+	return applicationResponse, nil
+}
+
 func (c *WebMethodClient) CreateApplication(application *Application) (*Application, error) {
 	responseApplication := &Application{}
 	url := fmt.Sprintf("%s/rest/apigateway/applications", c.url)
@@ -433,6 +482,45 @@ func (c *WebMethodClient) GetStrategy(strategyId string) (*StrategyResponse, err
 	if err != nil {
 		return nil, err
 	}
+	if response.Code == http.StatusNotFound {
+		return nil, ErrStrategyNotFound
+	}
+	if response.Code != http.StatusOK {
+		return nil, agenterrors.Newf(2001, "Unable to get strategy")
+	}
+
+	err = json.Unmarshal(response.Body, strategyResponse)
+	if err != nil {
+		return nil, err
+	}
+	return strategyResponse, nil
+}
+
+func (c *WebMethodClient) UpdateStrategy(strategy *Strategy) (*StrategyResponse, error) {
+	strategyResponse := &StrategyResponse{}
+	url := fmt.Sprintf("%s/rest/apigateway/strategies/%s", c.url, strategy.Id)
+	headers := map[string]string{
+		"Authorization": c.createAuthToken(),
+		"Content-Type":  "application/json",
+	}
+	buffer, err := json.Marshal(strategy)
+	if err != nil {
+		return nil, agenterrors.Newf(2000, err.Error())
+	}
+	request := coreapi.Request{
+		Method:  coreapi.PUT,
+		URL:     url,
+		Headers: headers,
+		Body:    buffer,
+	}
+
+	response, err := c.httpClient.Send(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.Code != 200 {
+		return nil, agenterrors.Newf(2001, "Unable to update strategy")
+	}
 
 	err = json.Unmarshal(response.Body, strategyResponse)
 	if err != nil {
@@ -492,6 +580,53 @@ func (c *WebMethodClient) RotateApplicationApikey(applicationId string) error {
 	return nil
 }
 
+// SuspendApplication suspends the application, the gateway rejects calls made with its credentials until it is enabled again
+func (c *WebMethodClient) SuspendApplication(applicationId string) error {
+	return c.setApplicationSuspended(applicationId, true)
+}
+
+// EnableApplication reactivates a suspended application
+func (c *WebMethodClient) EnableApplication(applicationId string) error {
+	return c.setApplicationSuspended(applicationId, false)
+}
+
+func (c *WebMethodClient) setApplicationSuspended(applicationId string, suspended bool) error {
+	applicationResponse, err := c.GetApplication(applicationId)
+	if err != nil {
+		return err
+	}
+	if len(applicationResponse.Applications) == 0 {
+		return agenterrors.Newf(2001, "Unable to find Application")
+	}
+	application := applicationResponse.Applications[0]
+	if application.IsSuspended == suspended {
+		return nil
+	}
+	application.IsSuspended = suspended
+	buffer, err := json.Marshal(application)
+	if err != nil {
+		return agenterrors.Newf(2000, err.Error())
+	}
+	request := coreapi.Request{
+		Method: coreapi.PUT,
+		URL:    fmt.Sprintf(getApplicationURL, c.url, applicationId),
+		Headers: map[string]string{
+			"Authorization": c.createAuthToken(),
+			"Content-Type":  "application/json",
+		},
+		Body: buffer,
+	}
+
+	response, err := c.httpClient.Send(request)
+	if err != nil {
+		return err
+	}
+	if response.Code != 200 {
+		return agenterrors.Newf(2001, "Unable to update Application suspension")
+	}
+	return nil
+}
+
 func (c *WebMethodClient) DeleteStrategy(strategyId string) error {
 	url := fmt.Sprintf("%s/rest/apigateway/strategies/%s", c.url, strategyId)
 	headers := map[string]string{
@@ -613,6 +748,145 @@ func (c *WebMethodClient) UnsubscribeApplication(applicationId string, apiId str
 	return nil
 }
 
+func (c *WebMethodClient) ListPackages() (*PackageResponse, error) {
+	packageResponse := &PackageResponse{}
+	url := fmt.Sprintf("%s/rest/apigateway/packages", c.url)
+	headers := map[string]string{
+		"Authorization": c.createAuthToken(),
+		"Accept":        "application/json",
+	}
+	request := coreapi.Request{
+		Method:  coreapi.GET,
+		URL:     url,
+		Headers: headers,
+	}
+	response, err := c.httpClient.Send(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.Code != http.StatusOK {
+		return nil, agenterrors.Newf(2001, "Unable to list Packages")
+	}
+
+	err = json.Unmarshal(response.Body, packageResponse)
+	if err != nil {
+		return nil, err
+	}
+	return packageResponse, nil
+}
+
+func (c *WebMethodClient) ListPlans() (*PlanResponse, error) {
+	planResponse := &PlanResponse{}
+	url := fmt.Sprintf("%s/rest/apigateway/plans", c.url)
+	headers := map[string]string{
+		"Authorization": c.createAuthToken(),
+		"Accept":        "application/json",
+	}
+	request := coreapi.Request{
+		Method:  coreapi.GET,
+		URL:     url,
+		Headers: headers,
+	}
+	response, err := c.httpClient.Send(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.Code != http.StatusOK {
+		return nil, agenterrors.Newf(2001, "Unable to list Plans")
+	}
+
+	err = json.Unmarshal(response.Body, planResponse)
+	if err != nil {
+		return nil, err
+	}
+	return planResponse, nil
+}
+
+func (c *WebMethodClient) CreateSubscription(subscription *Subscription) (*SubscriptionResponse, error) {
+	subscriptionResponse := &SubscriptionResponse{}
+	url := fmt.Sprintf("%s/rest/apigateway/subscriptions", c.url)
+	headers := map[string]string{
+		"Authorization": c.createAuthToken(),
+		"Content-Type":  "application/json",
+	}
+	buffer, err := json.Marshal(subscription)
+	if err != nil {
+		return nil, agenterrors.Newf(2000, err.Error())
+	}
+	request := coreapi.Request{
+		Method:  coreapi.POST,
+		URL:     url,
+		Headers: headers,
+		Body:    buffer,
+	}
+	response, err := c.httpClient.Send(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.Code != http.StatusCreated {
+		return nil, agenterrors.Newf(2001, "Unable to create Subscription")
+	}
+
+	err = json.Unmarshal(response.Body, subscriptionResponse)
+	if err != nil {
+		return nil, err
+	}
+	return subscriptionResponse, nil
+}
+
+func (c *WebMethodClient) UpdateSubscription(subscription *Subscription) (*SubscriptionResponse, error) {
+	subscriptionResponse := &SubscriptionResponse{}
+	url := fmt.Sprintf("%s/rest/apigateway/subscriptions/%s", c.url, subscription.Id)
+	headers := map[string]string{
+		"Authorization": c.createAuthToken(),
+		"Content-Type":  "application/json",
+	}
+	buffer, err := json.Marshal(subscription)
+	if err != nil {
+		return nil, agenterrors.Newf(2000, err.Error())
+	}
+	request := coreapi.Request{
+		Method:  coreapi.PUT,
+		URL:     url,
+		Headers: headers,
+		Body:    buffer,
+	}
+	response, err := c.httpClient.Send(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.Code != http.StatusOK {
+		return nil, agenterrors.Newf(2001, "Unable to update Subscription")
+	}
+
+	err = json.Unmarshal(response.Body, subscriptionResponse)
+	if err != nil {
+		return nil, err
+	}
+	return subscriptionResponse, nil
+}
+
+func (c *WebMethodClient) DeleteSubscription(subscriptionId string) error {
+	url := fmt.Sprintf("%s/rest/apigateway/subscriptions/%s", c.url, subscriptionId)
+	headers := map[string]string{
+		"Authorization": c.createAuthToken(),
+		"Content-Type":  "application/json",
+	}
+	request := coreapi.Request{
+		Method:  coreapi.DELETE,
+		URL:     url,
+		Headers: headers,
+	}
+	response, err := c.httpClient.Send(request)
+	if err != nil {
+		return err
+	}
+	if response.Code != http.StatusNoContent {
+		return agenterrors.Newf(2001, "Unable to delete Subscription")
+	}
+	return nil
+}
+
 func (c *WebMethodClient) ListOauth2Servers() (*OauthServers, error) {
 	requestStr := `{
 		"types": [
@@ -659,9 +933,11 @@ func (c *WebMethodClient) ListOauth2Servers() (*OauthServers, error) {
 	return oauthServers, nil
 }
 
-func (c *WebMethodClient) GetTransactionsWindow(startDate, endDate string) ([]byte, error) {
+// GetTransactionsWindow writes the archive of the transactions of the event type between the dates to the writer. The
+// archive is streamed when the http client is a Downloader.
+func (c *WebMethodClient) GetTransactionsWindow(eventType, startDate, endDate string, w io.Writer) error {
 	query := map[string]string{
-		"eventType": "transactionalEvents",
+		"eventType": eventType,
 		"startDate": startDate,
 		"endDate":   endDate,
 	}
@@ -675,11 +951,25 @@ func (c *WebMethodClient) GetTransactionsWindow(startDate, endDate string) ([]by
 		Headers:     headers,
 		QueryParams: query,
 	}
+	if downloader, ok := c.httpClient.(Downloader); ok {
+		code, err := downloader.Download(request, w)
+		if err != nil {
+			return err
+		}
+		if code != http.StatusOK {
+			return agenterrors.Newf(2001, "Unable to get transactions")
+		}
+		return nil
+	}
 	response, err := c.httpClient.Send(request)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return response.Body, nil
+	if response.Code != http.StatusOK {
+		return agenterrors.Newf(2001, "Unable to get transactions")
+	}
+	_, err = w.Write(response.Body)
+	return err
 }
 
 func (c *WebMethodClient) createAuthToken() string {
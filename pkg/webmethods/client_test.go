@@ -1,7 +1,11 @@
 package webmethods
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	coreapi "github.com/Axway/agent-sdk/pkg/api"
@@ -1770,6 +1774,7 @@ func TestApiDetails(t *testing.T) {
 	assert.Equal(t, apiResponse.GatewayEndPoints[0], "http://env688761.apigw-aw-us.webmethods.io/gateway/petstore/1.0.17")
 	assert.Equal(t, apiResponse.Api.Owner, "wecare@apiwheel.dev")
 	assert.Equal(t, apiResponse.Api.ApiVersion, "1.0.17")
+	assert.Equal(t, apiResponse.Api.SystemVersion, 1)
 	assert.Equal(t, apiResponse.Api.ApiDefinition.Info.Title, "Swagger Petstore - OpenAPI 3.0")
 	assert.NotNil(t, apiResponse.Api.ApiDescription)
 	assert.NotNil(t, apiResponse.Api.ApiGroups[0], "Finance Banking and Insurance")
@@ -3417,6 +3422,52 @@ func TestGetApplication(t *testing.T) {
 	assert.Equal(t, applicationResponse.Applications[0].AccessTokens.ApiAccessKeyCredentials.ApiAccessKey, "e5c8641a-9b41-4b6b-8db2-4c75b24ed104")
 }
 
+func TestListApplications(t *testing.T) {
+
+	response := `{
+		"applications": [
+			{
+				"name": "consumerapp",
+				"description": "Amplify consumerapp",
+				"identifiers": [],
+				"authStrategyIds": [],
+				"id": "1cc88555-b7df-4e5b-a9e3-1728cc0ecfe6",
+				"consumingAPIs": ["2b598e47-3e0c-4b0f-8a72-da7fdf6c5ea2"]
+			},
+			{
+				"name": "partnerapp",
+				"description": "Partner application",
+				"identifiers": [],
+				"authStrategyIds": [],
+				"id": "8c3b2f1e-52c4-4a55-9d0e-3f2a1b6c7d8e",
+				"consumingAPIs": []
+			}
+		]
+	}`
+	mc := &MockClient{}
+	webMethodsClient, _ := NewClient(cfg, mc)
+	mc.SendFunc = func(request coreapi.Request) (*coreapi.Response, error) {
+		return &coreapi.Response{
+			Code: 200,
+			Body: []byte(response),
+		}, nil
+	}
+	applicationResponse, err := webMethodsClient.ListApplications()
+	assert.Nil(t, err)
+	assert.Equal(t, len(applicationResponse.Applications), 2)
+	assert.Equal(t, applicationResponse.Applications[0].Id, "1cc88555-b7df-4e5b-a9e3-1728cc0ecfe6")
+	assert.Equal(t, applicationResponse.Applications[0].ConsumingAPIs, []string{"2b598e47-3e0c-4b0f-8a72-da7fdf6c5ea2"})
+	assert.Equal(t, applicationResponse.Applications[1].Name, "partnerapp")
+
+	mc.SendFunc = func(request coreapi.Request) (*coreapi.Response, error) {
+		return &coreapi.Response{
+			Code: 500,
+		}, nil
+	}
+	_, err = webMethodsClient.ListApplications()
+	assert.NotNil(t, err)
+}
+
 func TestCreateApplication(t *testing.T) {
 
 	request := `{
@@ -3504,6 +3555,45 @@ func TestRotateApplicationApikey(t *testing.T) {
 	assert.Nil(t, err)
 }
 
+func TestSuspendApplication(t *testing.T) {
+
+	response := `{
+		"applications": [
+			{
+				"name": "consumerapp",
+				"id": "1cc88555-b7df-4e5b-a9e3-1728cc0ecfe6",
+				"isSuspended": false
+			}
+		]
+	}`
+	mc := &MockClient{}
+	webMethodsClient, _ := NewClient(cfg, mc)
+	var updated *Application
+	mc.SendFunc = func(request coreapi.Request) (*coreapi.Response, error) {
+		if request.Method == coreapi.PUT {
+			updated = &Application{}
+			json.Unmarshal(request.Body, updated)
+			return &coreapi.Response{
+				Code: 200,
+				Body: request.Body,
+			}, nil
+		}
+		return &coreapi.Response{
+			Code: 200,
+			Body: []byte(response),
+		}, nil
+	}
+	err := webMethodsClient.SuspendApplication("1cc88555-b7df-4e5b-a9e3-1728cc0ecfe6")
+	assert.Nil(t, err)
+	assert.True(t, updated.IsSuspended)
+
+	// already enabled, nothing to update
+	updated = nil
+	err = webMethodsClient.EnableApplication("1cc88555-b7df-4e5b-a9e3-1728cc0ecfe6")
+	assert.Nil(t, err)
+	assert.Nil(t, updated)
+}
+
 func TestDeleteApplication(t *testing.T) {
 
 	mc := &MockClient{}
@@ -5289,6 +5379,34 @@ func TestFindApplicationByName(t *testing.T) {
 
 }
 
+func TestFindApplicationByNameExactMatch(t *testing.T) {
+
+	response := `{
+		"application": [
+			{
+				"applicationID": "7a1e9f3c-6a55-4c4e-9f0e-1f4b5c0d2e11",
+				"name": "oauthokta-admin"
+			},
+			{
+				"applicationID": "5dc30779-2d4b-441c-97d0-b2c05a2ae1c8",
+				"name": "oauthokta"
+			}
+		]
+	}`
+	mc := &MockClient{}
+	webMethodsClient, _ := NewClient(cfg, mc)
+	mc.SendFunc = func(request coreapi.Request) (*coreapi.Response, error) {
+		return &coreapi.Response{
+			Code: 200,
+			Body: []byte(response),
+		}, nil
+	}
+	applicationResponse, err := webMethodsClient.FindApplicationByName("oauthokta")
+	assert.Nil(t, err)
+	assert.Equal(t, len(applicationResponse.SearchApplication), 1)
+	assert.Equal(t, applicationResponse.SearchApplication[0].ApplicationID, "5dc30779-2d4b-441c-97d0-b2c05a2ae1c8")
+}
+
 func TestFindApplicationByNameNegative(t *testing.T) {
 
 	response := `{
@@ -5466,6 +5584,44 @@ func TestGetStrategy(t *testing.T) {
 	assert.Equal(t, strategyResponse.Strategy.ClientRegistration.ClientSecret, "xxxxxxx")
 	assert.Equal(t, strategyResponse.Strategy.ClientRegistration.RedirectUris[0], "https://redirectURI.com")
 
+	for code, expected := range map[int]error{404: ErrStrategyNotFound, 401: nil, 500: nil} {
+		mc.SendFunc = func(request coreapi.Request) (*coreapi.Response, error) {
+			return &coreapi.Response{Code: code, Body: []byte(`{"strategy": {}}`)}, nil
+		}
+		_, err = webMethodsClient.GetStrategy("1b322290-9805-4057-91cb-c803b9750227")
+		assert.NotNil(t, err)
+		assert.Equal(t, expected != nil, errors.Is(err, ErrStrategyNotFound))
+	}
+}
+
+func TestUpdateStrategy(t *testing.T) {
+	response := `{
+		"strategy": {
+			"id": "1b322290-9805-4057-91cb-c803b9750227",
+			"name": "consumerapp",
+			"type": "OAUTH2",
+			"clientRegistration": {
+				"clientId": "aaaaaaa",
+				"clientSecret": "xxxxxxx",
+				"enabled": false
+			}
+		}
+	}`
+	mc := &MockClient{}
+	webMethodsClient, _ := NewClient(cfg, mc)
+	mc.SendFunc = func(request coreapi.Request) (*coreapi.Response, error) {
+		assert.Equal(t, coreapi.PUT, request.Method)
+		assert.Equal(t, "/rest/apigateway/strategies/1b322290-9805-4057-91cb-c803b9750227", request.URL)
+		return &coreapi.Response{
+			Code: 200,
+			Body: []byte(response),
+		}, nil
+	}
+	strategy := &Strategy{Id: "1b322290-9805-4057-91cb-c803b9750227"}
+	strategyResponse, err := webMethodsClient.UpdateStrategy(strategy)
+	assert.Nil(t, err)
+	assert.False(t, strategyResponse.Strategy.ClientRegistration.Enabled)
+	assert.Equal(t, strategyResponse.Strategy.ClientRegistration.ClientId, "aaaaaaa")
 }
 
 func TestListOauth2Servers(t *testing.T) {
@@ -5590,3 +5746,140 @@ func TestSearchApis(t *testing.T) {
 	assert.Equal(t, apis.WebmethodsApi[3].ApiName, "watchlist")
 
 }
+
+func TestListPackagesAndPlans(t *testing.T) {
+
+	packagesResponse := `{
+		"packages": [
+			{
+				"id": "7b5e4f4e-0c1b-4a59-9f8a-1a1d0e6a2f10",
+				"name": "Petstore",
+				"active": true,
+				"apis": ["9bf61a62-20f7-47f5-bd10-806d98330622"],
+				"plans": ["d2f6a1c3-5e2b-4b7a-8f0e-3c9d6b1a4e22"]
+			}
+		]
+	}`
+	plansResponse := `{
+		"plans": [
+			{
+				"id": "d2f6a1c3-5e2b-4b7a-8f0e-3c9d6b1a4e22",
+				"name": "Gold",
+				"description": "1000 requests per minute",
+				"rateLimit": {
+					"maxRequestCount": 1000,
+					"intervalInSeconds": 60
+				}
+			}
+		]
+	}`
+	mc := &MockClient{}
+	webMethodsClient, _ := NewClient(cfg, mc)
+	mc.SendFunc = func(request coreapi.Request) (*coreapi.Response, error) {
+		body := packagesResponse
+		if request.URL == "/rest/apigateway/plans" {
+			body = plansResponse
+		}
+		return &coreapi.Response{
+			Code: 200,
+			Body: []byte(body),
+		}, nil
+	}
+	packages, err := webMethodsClient.ListPackages()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(packages.Packages))
+	assert.Equal(t, "Petstore", packages.Packages[0].Name)
+	assert.Equal(t, []string{"d2f6a1c3-5e2b-4b7a-8f0e-3c9d6b1a4e22"}, packages.Packages[0].Plans)
+
+	plans, err := webMethodsClient.ListPlans()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(plans.Plans))
+	assert.Equal(t, "Gold", plans.Plans[0].Name)
+	assert.Equal(t, int64(1000), plans.Plans[0].RateLimit.MaxRequestCount)
+
+	mc.SendFunc = func(request coreapi.Request) (*coreapi.Response, error) {
+		return &coreapi.Response{
+			Code: 500,
+		}, nil
+	}
+	_, err = webMethodsClient.ListPackages()
+	assert.NotNil(t, err)
+	_, err = webMethodsClient.ListPlans()
+	assert.NotNil(t, err)
+}
+
+func TestSubscriptionLifecycle(t *testing.T) {
+
+	mc := &MockClient{}
+	webMethodsClient, _ := NewClient(cfg, mc)
+	mc.SendFunc = func(request coreapi.Request) (*coreapi.Response, error) {
+		switch request.Method {
+		case coreapi.POST:
+			subscription := &Subscription{}
+			json.Unmarshal(request.Body, subscription)
+			subscription.Id = "4c3b2a19-8f7e-4d6c-b5a4-392817e6d5c4"
+			body, _ := json.Marshal(SubscriptionResponse{Subscription: *subscription})
+			return &coreapi.Response{Code: 201, Body: body}, nil
+		case coreapi.PUT:
+			assert.Equal(t, "/rest/apigateway/subscriptions/4c3b2a19-8f7e-4d6c-b5a4-392817e6d5c4", request.URL)
+			body := `{"subscription": {"id": "4c3b2a19-8f7e-4d6c-b5a4-392817e6d5c4", "planId": "silver"}}`
+			return &coreapi.Response{Code: 200, Body: []byte(body)}, nil
+		}
+		return &coreapi.Response{Code: 204}, nil
+	}
+	subscriptionResponse, err := webMethodsClient.CreateSubscription(&Subscription{ApplicationId: "app", PackageId: "package", PlanId: "gold"})
+	assert.Nil(t, err)
+	assert.Equal(t, "4c3b2a19-8f7e-4d6c-b5a4-392817e6d5c4", subscriptionResponse.Subscription.Id)
+	assert.Equal(t, "gold", subscriptionResponse.Subscription.PlanId)
+
+	subscription := subscriptionResponse.Subscription
+	subscription.PlanId = "silver"
+	subscriptionResponse, err = webMethodsClient.UpdateSubscription(&subscription)
+	assert.Nil(t, err)
+	assert.Equal(t, "silver", subscriptionResponse.Subscription.PlanId)
+
+	err = webMethodsClient.DeleteSubscription(subscription.Id)
+	assert.Nil(t, err)
+
+	mc.SendFunc = func(request coreapi.Request) (*coreapi.Response, error) {
+		return &coreapi.Response{
+			Code: 400,
+		}, nil
+	}
+	_, err = webMethodsClient.CreateSubscription(&subscription)
+	assert.NotNil(t, err)
+	err = webMethodsClient.DeleteSubscription(subscription.Id)
+	assert.NotNil(t, err)
+}
+
+func TestGetTransactionsWindowStreamsTheArchive(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/rest/apigateway/apitransactions", r.URL.Path)
+		assert.Equal(t, "errorEvents", r.URL.Query().Get("eventType"))
+		assert.Equal(t, "2024-05-01 10:00:00", r.URL.Query().Get("startDate"))
+		assert.NotEmpty(t, r.Header.Get("Authorization"))
+		if r.URL.Query().Get("endDate") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte("archive"))
+	}))
+	defer server.Close()
+	httpClient, err := NewStreamingClient(nil, "")
+	assert.Nil(t, err)
+	webMethodsClient, _ := NewClient(&config.WebMethodConfig{WebmethodsApimUrl: server.URL, Username: "user", Password: "pass"}, httpClient)
+
+	archive := &bytes.Buffer{}
+	assert.Nil(t, webMethodsClient.GetTransactionsWindow(ErrorEvents, "2024-05-01 10:00:00", "2024-05-01 11:00:00", archive))
+	assert.Equal(t, "archive", archive.String())
+	assert.NotNil(t, webMethodsClient.GetTransactionsWindow(ErrorEvents, "2024-05-01 10:00:00", "", &bytes.Buffer{}))
+
+	// the clients that do not stream keep the archive in memory
+	mc := &MockClient{SendFunc: func(request coreapi.Request) (*coreapi.Response, error) {
+		return &coreapi.Response{Code: 200, Body: []byte("archive")}, nil
+	}}
+	webMethodsClient, _ = NewClient(cfg, mc)
+	archive = &bytes.Buffer{}
+	assert.Nil(t, webMethodsClient.GetTransactionsWindow(ErrorEvents, "2024-05-01 10:00:00", "2024-05-01 11:00:00", archive))
+	assert.Equal(t, "archive", archive.String())
+}
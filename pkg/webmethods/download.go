@@ -0,0 +1,77 @@
+package webmethods
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	coreapi "github.com/Axway/agent-sdk/pkg/api"
+	corecfg "github.com/Axway/agent-sdk/pkg/config"
+)
+
+// downloadHeaderTimeout is the time waited for the response of a download, the body is read without time limit
+const downloadHeaderTimeout = time.Minute
+
+// Downloader is implemented by the http clients able to stream a response body to a writer instead of keeping it in
+// memory
+type Downloader interface {
+	Download(request coreapi.Request, w io.Writer) (int, error)
+}
+
+// streamingClient sends the requests with the http client of the SDK and streams the downloads with an http client
+// sharing its TLS and proxy configuration
+type streamingClient struct {
+	coreapi.Client
+	download *http.Client
+}
+
+// NewStreamingClient creates the http client of the gateway, streaming the transactions archives
+func NewStreamingClient(tlsCfg corecfg.TLSConfig, proxyURL string) (coreapi.Client, error) {
+	transport := &http.Transport{ResponseHeaderTimeout: downloadHeaderTimeout}
+	if tlsCfg != nil {
+		transport.TLSClientConfig = tlsCfg.BuildTLSConfig()
+	}
+	if proxyURL != "" {
+		proxy, err := url.Parse(proxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy url %s: %s", proxyURL, err)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	return &streamingClient{
+		Client:   coreapi.NewClient(tlsCfg, proxyURL),
+		download: &http.Client{Transport: transport},
+	}, nil
+}
+
+// Download sends the request and copies the response body to the writer, returning the status code of the response
+func (c *streamingClient) Download(request coreapi.Request, w io.Writer) (int, error) {
+	requestURL, err := url.Parse(request.URL)
+	if err != nil {
+		return 0, err
+	}
+	query := requestURL.Query()
+	for name, value := range request.QueryParams {
+		query.Set(name, value)
+	}
+	requestURL.RawQuery = query.Encode()
+	req, err := http.NewRequest(request.Method, requestURL.String(), nil)
+	if err != nil {
+		return 0, err
+	}
+	for name, value := range request.Headers {
+		req.Header.Set(name, value)
+	}
+	res, err := c.download.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return res.StatusCode, nil
+	}
+	_, err = io.Copy(w, res.Body)
+	return res.StatusCode, err
+}
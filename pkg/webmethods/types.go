@@ -1,5 +1,16 @@
 package webmethods
 
+// Event types of the transactions export
+const (
+	TransactionalEvents   = "transactionalEvents"
+	ErrorEvents           = "errorEvents"
+	PolicyViolationEvents = "policyViolationEvents"
+	LifecycleEvents       = "lifecycleEvents"
+)
+
+// EventTypes are the event types of the transactions export handled by the traceability agent
+var EventTypes = []string{TransactionalEvents, ErrorEvents, PolicyViolationEvents, LifecycleEvents}
+
 // API -
 type AmplifyAPI struct {
 	ApiSpec       []byte
@@ -11,6 +22,9 @@ type AmplifyAPI struct {
 	Documentation []byte
 	AuthPolicy    string
 	ApiType       string
+	Packages      []string
+	Plans         []string
+	PlanNames     []string
 }
 
 type ListApi struct {
@@ -53,6 +67,7 @@ type Api struct {
 	Title          string
 	ApiDefinition  ApiDefinition
 	MaturityState  string
+	SystemVersion  int
 	ApiGroups      []string
 	Owner          string
 }
@@ -109,31 +124,33 @@ type SearchApplication struct {
 }
 
 type Application struct {
-	Id            string `json:"id"`
-	ApplicationID string `json:"applicationID"`
-	Name          string `json:"name"`
-	Description   string `json:"description"`
-	Owner         string `json:"owner"`
-	Identifiers   []struct {
-		ID    string   `json:"id"`
-		Key   string   `json:"key"`
-		Name  string   `json:"name"`
-		Value []string `json:"value"`
-	} `json:"identifiers"`
-	ContactEmails         []string     `json:"contactEmails"`
-	IconbyteArray         string       `json:"iconbyteArray"`
-	AccessTokens          AccessTokens `json:"accessTokens"`
-	CreationDate          string       `json:"creationDate"`
-	LastModified          string       `json:"lastModified"`
-	LastUpdated           string       `json:"lastUpdated"`
-	SiteURLs              []string     `json:"siteURLs"`
-	JsOrigins             []string     `json:"jsOrigins"`
-	Version               string       `json:"version"`
-	IsSuspended           string       `json:"isSuspended"`
-	AuthStrategyIds       []string     `json:"authStrategyIds"`
-	Subscription          bool         `json:"subscription"`
-	ConsumingAPIs         []string     `json:"consumingAPIs"`
-	NewApisForAssociation []string     `json:"newApisForAssociation"`
+	Id                    string                  `json:"id"`
+	ApplicationID         string                  `json:"applicationID"`
+	Name                  string                  `json:"name"`
+	Description           string                  `json:"description"`
+	Owner                 string                  `json:"owner"`
+	Identifiers           []ApplicationIdentifier `json:"identifiers"`
+	ContactEmails         []string                `json:"contactEmails"`
+	IconbyteArray         string                  `json:"iconbyteArray"`
+	AccessTokens          AccessTokens            `json:"accessTokens"`
+	CreationDate          string                  `json:"creationDate"`
+	LastModified          string                  `json:"lastModified"`
+	LastUpdated           string                  `json:"lastUpdated"`
+	SiteURLs              []string                `json:"siteURLs"`
+	JsOrigins             []string                `json:"jsOrigins"`
+	Version               string                  `json:"version"`
+	IsSuspended           bool                    `json:"isSuspended"`
+	AuthStrategyIds       []string                `json:"authStrategyIds"`
+	Subscription          bool                    `json:"subscription"`
+	ConsumingAPIs         []string                `json:"consumingAPIs"`
+	NewApisForAssociation []string                `json:"newApisForAssociation"`
+}
+
+type ApplicationIdentifier struct {
+	ID    string   `json:"id,omitempty"`
+	Key   string   `json:"key"`
+	Name  string   `json:"name"`
+	Value []string `json:"value"`
 }
 
 type SavedSettings struct {
@@ -207,17 +224,62 @@ type ClientRegistration struct {
 }
 
 type OauthServers struct {
-	Alias []struct {
-		ID                  string       `json:"id"`
-		Name                string       `json:"name"`
-		Description         string       `json:"description,omitempty"`
-		Type                string       `json:"type"`
-		Scopes              []OauthScope `json:"scopes"`
-		SupportedGrantTypes []string     `json:"supportedGrantTypes"`
-	} `json:"alias"`
+	Alias []OauthServerAlias `json:"alias"`
+}
+
+type OauthServerAlias struct {
+	ID                  string       `json:"id"`
+	Name                string       `json:"name"`
+	Description         string       `json:"description,omitempty"`
+	Type                string       `json:"type"`
+	Scopes              []OauthScope `json:"scopes"`
+	SupportedGrantTypes []string     `json:"supportedGrantTypes"`
 }
 
 type OauthScope struct {
 	Name        string `json:"name"`
 	Description string `json:"description"`
 }
+
+// packages and plans
+
+type PackageResponse struct {
+	Packages []Package `json:"packages"`
+}
+
+type Package struct {
+	Id          string   `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Active      bool     `json:"active"`
+	Apis        []string `json:"apis"`
+	Plans       []string `json:"plans"`
+}
+
+type PlanResponse struct {
+	Plans []Plan `json:"plans"`
+}
+
+type Plan struct {
+	Id          string     `json:"id"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	RateLimit   *PlanLimit `json:"rateLimit,omitempty"`
+	Quota       *PlanLimit `json:"quota,omitempty"`
+}
+
+type PlanLimit struct {
+	MaxRequestCount   int64 `json:"maxRequestCount"`
+	IntervalInSeconds int64 `json:"intervalInSeconds"`
+}
+
+type Subscription struct {
+	Id            string `json:"id,omitempty"`
+	ApplicationId string `json:"applicationId"`
+	PackageId     string `json:"packageId"`
+	PlanId        string `json:"planId"`
+}
+
+type SubscriptionResponse struct {
+	Subscription Subscription `json:"subscription"`
+}